@@ -2,16 +2,20 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/url"
 	"os"
 	"os/exec"
 	"runtime"
 	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gookit/color"
 	"github.com/saenuma/flaarum/internal"
+	"github.com/tidwall/pretty"
 )
 
 const VersionFormat = "20060102T150405MST"
@@ -47,9 +51,21 @@ Table(s) Commands:
   lt    List Tables: Expects a project name after the command.
   ct    Create Table: Expects a project name and the path to a file containing the table structure
   uts   Update Table Structure: Expects a project name and the path to a file containing the table structure
+  duts  Dry-run Update Table Structure: Expects the same arguments as 'uts'. It prints the changes to the
+        fields, the count of rows which would violate new constraints and the indexes to create or drop.
   ctvn  Current Table Version Number: Expects a project and table combo eg. 'first_proj/users'
   ts    Table Structure Statement: Expects a project and table combo eg. 'first_proj/users' and a valid number.
   dt    Delete Table: Expects one or more project and table combo eg. 'first_proj/users'.
+  rt    Rename Table: Expects a project and table combo eg. 'first_proj/users' and the new table name.
+        Foreign keys pointing to the table are updated.
+  cpt   Copy Table: Expects a project and table combo eg. 'first_proj/users' and the new table name.
+        Add 'data' after the new table name to copy the rows as well as the structure.
+  gto   Get Table Options: Expects a project and table combo eg. 'first_proj/users'
+  sto   Set Table Option: Expects a project and table combo eg. 'first_proj/users', an option name and a value.
+        eg. 'sto first_proj/users history true'
+  sts   Storage Stats: Expects a project or a project and table combo eg. 'first_proj/users'
+        It prints the row count, the live and dead bytes of the files, the distinct values of each index,
+        the structure versions of the rows and the last id.
 
 
 Table Data Commands:
@@ -72,9 +88,30 @@ Table Data Commands:
 
   vr    View Row: Expects a project, table and id combo eg. 'first_proj/users/31'
 
+  rh    Row History: Expects a project, table and id combo eg. 'first_proj/users/31'
+        The table must have the 'history' option set to true.
+
+  ltr   List Trash: Expects a project and table combo eg. 'first_proj/users'
+        The table must have the 'soft_delete' option set to true.
+
+  rtr   Restore From Trash: Expects a project and table combo eg. 'first_proj/users' and one or more ids.
+
+  ptr   Purge Trash: Expects a project and table combo eg. 'first_proj/users' and optionally ids.
+        Without ids, it empties the trash.
+
+
+Webhook Commands:
+
+  awh   Add Webhook: Expects a project, a table or '*' for all the tables and a url.
+        It prints the webhook's id and the secret used to sign its payloads.
+  lwh   List Webhooks: Expects a project.
+  twh   Test Webhook: Expects a project and the id of a webhook. It posts a test event to the webhook.
+  rwh   Remove Webhook: Expects a project and the id of a webhook.
+
 
 Table Search Commands:
   st    Search Table: Expects a project and a file containing the search statement.
+        Add a line 'as of <datetime>' to the statement to search the table as it was at that time.
   arc   All Rows Count: Expects a project and table combo eg. 'first_proj/users'
   rc    Count of rows found in a search. Expects a project and a file containing a search statement.
 
@@ -221,6 +258,81 @@ Table Search Commands:
 			}
 		}
 
+	case "rt":
+		if len(os.Args) != 4 {
+			color.Red.Println("'rt' command expects a project and table combo eg. 'first_proj/users' and the new table name.")
+			os.Exit(1)
+		}
+
+		parts := strings.Split(os.Args[2], "/")
+		_, err := internal.LocalRequest(fmt.Sprintf("rename-table/%s/%s/%s", parts[0], parts[1], os.Args[3]), nil)
+		if err != nil {
+			color.Red.Printf("Error renaming table '%s' to '%s'.\nError: %s\n", os.Args[2], os.Args[3], err)
+			os.Exit(1)
+		}
+
+	case "cpt":
+		if len(os.Args) != 4 && len(os.Args) != 5 {
+			color.Red.Println("'cpt' command expects a project and table combo eg. 'first_proj/users', the new table name and optionally 'data'.")
+			os.Exit(1)
+		}
+
+		withData := "f"
+		if len(os.Args) == 5 && os.Args[4] == "data" {
+			withData = "t"
+		}
+
+		parts := strings.Split(os.Args[2], "/")
+		_, err := internal.LocalRequest(fmt.Sprintf("copy-table/%s/%s/%s", parts[0], parts[1], os.Args[3]),
+			url.Values{"with-data": {withData}})
+		if err != nil {
+			color.Red.Printf("Error copying table '%s' to '%s'.\nError: %s\n", os.Args[2], os.Args[3], err)
+			os.Exit(1)
+		}
+
+	case "gto":
+		if len(os.Args) != 3 {
+			color.Red.Println("'gto' command expects a project and table combo eg. 'first_proj/users'.")
+			os.Exit(1)
+		}
+
+		parts := strings.Split(os.Args[2], "/")
+		out, err := internal.LocalRequest(fmt.Sprintf("get-table-options/%s/%s", parts[0], parts[1]), nil)
+		if err != nil {
+			color.Red.Printf("Error reading the options of table '%s'.\nError: %s\n", os.Args[2], err)
+			os.Exit(1)
+		}
+
+		fmt.Println(string(pretty.Pretty(out)))
+
+	case "sts":
+		if len(os.Args) != 3 {
+			color.Red.Println("'sts' command expects a project or a project and table combo eg. 'first_proj/users'.")
+			os.Exit(1)
+		}
+
+		out, err := internal.LocalRequest("stats/"+os.Args[2], nil)
+		if err != nil {
+			color.Red.Printf("Error reading the stats of '%s'.\nError: %s\n", os.Args[2], err)
+			os.Exit(1)
+		}
+
+		fmt.Println(string(pretty.Pretty(out)))
+
+	case "sto":
+		if len(os.Args) != 5 {
+			color.Red.Println("'sto' command expects a project and table combo eg. 'first_proj/users', an option name and a value.")
+			os.Exit(1)
+		}
+
+		parts := strings.Split(os.Args[2], "/")
+		_, err := internal.LocalRequest(fmt.Sprintf("set-table-options/%s/%s", parts[0], parts[1]),
+			url.Values{os.Args[3]: {os.Args[4]}})
+		if err != nil {
+			color.Red.Printf("Error setting the options of table '%s'.\nError: %s\n", os.Args[2], err)
+			os.Exit(1)
+		}
+
 	case "ct":
 		if len(os.Args) != 4 {
 			color.Red.Println("'ct' command expects the project name and a file containing table structure.")
@@ -269,6 +381,34 @@ Table Search Commands:
 			os.Exit(1)
 		}
 
+	case "duts":
+		if len(os.Args) != 4 {
+			color.Red.Println("'duts' command expects the project name and a file containing table structure.")
+			os.Exit(1)
+		}
+
+		inputPath, err := internal.GetFlaarumPath(os.Args[3])
+		if err != nil {
+			color.Red.Printf("The supplied path '%s' does not exists.\n", inputPath)
+			os.Exit(1)
+		}
+		raw, err := os.ReadFile(inputPath)
+		if err != nil {
+			color.Red.Printf("The supplied path '%s' does not exists.\n", inputPath)
+			os.Exit(1)
+		}
+
+		out, err := internal.LocalRequest("update-table-structure/"+os.Args[2], url.Values{
+			"stmt":    {string(raw)},
+			"dry-run": {"t"},
+		})
+		if err != nil {
+			color.Red.Printf("Error doing a dry run of the table update.\nError: %s\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Println(string(pretty.Pretty(out)))
+
 	case "bir":
 		if len(os.Args) != 3 {
 			color.Red.Println(`'bir' command expects a project and table combo eg. 'first_proj/users' `)
@@ -481,6 +621,140 @@ Table Search Commands:
 		}
 		fmt.Println()
 
+	case "rh":
+		if len(os.Args) != 3 {
+			color.Red.Println("'rh' command expects a project, table and id combo eg. 'first_proj/users/31'")
+			os.Exit(1)
+		}
+
+		parts := strings.Split(os.Args[2], "/")
+		out, err := internal.LocalRequest(fmt.Sprintf("row-history/%s/%s/%s", parts[0], parts[1], parts[2]), nil)
+		if err != nil {
+			color.Red.Printf("Error reading the history of row '%s'.\nError: %s\n", os.Args[2], err)
+			os.Exit(1)
+		}
+
+		versions := make([]internal.RowVersion, 0)
+		err = json.Unmarshal(out, &versions)
+		if err != nil {
+			color.Red.Printf("Error reading the history of row '%s'.\nError: %s\n", os.Args[2], err)
+			os.Exit(1)
+		}
+
+		for _, version := range versions {
+			fmt.Printf("%s  %s\n", version.Time.Format(VersionFormat), version.Op)
+			keys := make([]string, 0)
+			for k := range version.Row {
+				keys = append(keys, k)
+			}
+			slices.Sort(keys)
+			for _, k := range keys {
+				fmt.Printf("    %s: %s\n", k, version.Row[k])
+			}
+		}
+		fmt.Println()
+
+	case "ltr":
+		if len(os.Args) != 3 {
+			color.Red.Println("'ltr' command expects a project and table combo eg. 'first_proj/users'")
+			os.Exit(1)
+		}
+
+		parts := strings.Split(os.Args[2], "/")
+		out, err := internal.LocalRequest(fmt.Sprintf("list-trash/%s/%s", parts[0], parts[1]), nil)
+		if err != nil {
+			color.Red.Printf("Error listing the trash of table '%s'.\nError: %s\n", os.Args[2], err)
+			os.Exit(1)
+		}
+
+		rows := make([]map[string]string, 0)
+		err = json.Unmarshal(out, &rows)
+		if err != nil {
+			color.Red.Printf("Error listing the trash of table '%s'.\nError: %s\n", os.Args[2], err)
+			os.Exit(1)
+		}
+
+		for _, row := range rows {
+			deletedAt, _ := strconv.ParseInt(row["_deleted_at"], 10, 64)
+			fmt.Printf("id: %s  deleted: %s\n", row["id"], time.Unix(deletedAt, 0).Format(VersionFormat))
+		}
+		fmt.Println()
+
+	case "rtr", "ptr":
+		if (os.Args[1] == "rtr" && len(os.Args) < 4) || len(os.Args) < 3 {
+			color.Red.Printf("'%s' command expects a project and table combo eg. 'first_proj/users' and ids\n", os.Args[1])
+			os.Exit(1)
+		}
+
+		endpoint := "restore-rows"
+		if os.Args[1] == "ptr" {
+			endpoint = "purge-trash"
+		}
+
+		parts := strings.Split(os.Args[2], "/")
+		_, err := internal.LocalRequest(fmt.Sprintf("%s/%s/%s", endpoint, parts[0], parts[1]),
+			url.Values{"ids": {strings.Join(os.Args[3:], ",")}})
+		if err != nil {
+			color.Red.Printf("Error with the trash of table '%s'.\nError: %s\n", os.Args[2], err)
+			os.Exit(1)
+		}
+
+	case "awh":
+		if len(os.Args) != 5 {
+			color.Red.Println("'awh' command expects a project, a table or '*' and a url.")
+			os.Exit(1)
+		}
+
+		out, err := internal.LocalRequest("add-webhook/"+os.Args[2],
+			url.Values{"table": {os.Args[3]}, "url": {os.Args[4]}})
+		if err != nil {
+			color.Red.Printf("Error adding webhook.\nError: %s\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Println(string(pretty.Pretty(out)))
+
+	case "lwh":
+		if len(os.Args) != 3 {
+			color.Red.Println("'lwh' command expects a project.")
+			os.Exit(1)
+		}
+
+		out, err := internal.LocalRequest("list-webhooks/"+os.Args[2], nil)
+		if err != nil {
+			color.Red.Printf("Error listing webhooks.\nError: %s\n", err)
+			os.Exit(1)
+		}
+
+		hooks := make([]internal.Webhook, 0)
+		err = json.Unmarshal(out, &hooks)
+		if err != nil {
+			color.Red.Printf("Error listing webhooks.\nError: %s\n", err)
+			os.Exit(1)
+		}
+
+		for _, hook := range hooks {
+			fmt.Printf("%s  table: %s  url: %s  created: %s\n", hook.Id, hook.Table, hook.URL, hook.Created)
+		}
+
+	case "twh", "rwh":
+		if len(os.Args) != 4 {
+			color.Red.Printf("'%s' command expects a project and the id of a webhook.\n", os.Args[1])
+			os.Exit(1)
+		}
+
+		endpoint := "test-webhook"
+		if os.Args[1] == "rwh" {
+			endpoint = "remove-webhook"
+		}
+
+		_, err := internal.LocalRequest(fmt.Sprintf("%s/%s/%s", endpoint, os.Args[2], os.Args[3]), nil)
+		if err != nil {
+			color.Red.Printf("Error with webhook '%s'.\nError: %s\n", os.Args[3], err)
+			os.Exit(1)
+		}
+		fmt.Println("ok")
+
 	case "st":
 		if len(os.Args) != 4 {
 			color.Red.Println("'st' expects a project and a file containing the search statment.")
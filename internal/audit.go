@@ -0,0 +1,86 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// AUDIT_MAX_SIZE is the size in bytes at which the audit log is rotated.
+const AUDIT_MAX_SIZE = 10 * 1024 * 1024
+
+// AuditEntry is a line of the audit log. The store writes one for every successful mutation.
+type AuditEntry struct {
+	Time       string   `json:"time"`
+	ClientAddr string   `json:"client_addr"`
+	Key        string   `json:"key"`
+	Action     string   `json:"action"`
+	Project    string   `json:"project"`
+	Table      string   `json:"table,omitempty"`
+	Statement  string   `json:"statement,omitempty"`
+	Ids        []string `json:"ids,omitempty"`
+}
+
+// GetAuditLogPath returns the path of the audit log being written. Rotated logs are kept beside
+// it with the time of rotation added to their names.
+func GetAuditLogPath() string {
+	rootPath, err := GetRootPath()
+	if err != nil {
+		panic(err)
+	}
+	return filepath.Join(rootPath, "flaarum.audit")
+}
+
+// ListAuditLogFiles returns the paths of all the audit logs, oldest first.
+func ListAuditLogFiles() ([]string, error) {
+	rootPath, _ := GetRootPath()
+	dirFIs, err := os.ReadDir(rootPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "directory read error")
+	}
+
+	rotated := make([]string, 0)
+	for _, dirFI := range dirFIs {
+		if strings.HasPrefix(dirFI.Name(), "flaarum.audit.") {
+			rotated = append(rotated, filepath.Join(rootPath, dirFI.Name()))
+		}
+	}
+	sort.Strings(rotated)
+
+	if DoesPathExists(GetAuditLogPath()) {
+		rotated = append(rotated, GetAuditLogPath())
+	}
+	return rotated, nil
+}
+
+// ReadAuditEntries reads all the entries of an audit log file.
+func ReadAuditEntries(path string) ([]AuditEntry, error) {
+	entries := make([]AuditEntry, 0)
+	f, err := os.Open(path)
+	if err != nil {
+		return entries, errors.Wrap(err, "os error")
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var entry AuditEntry
+		err = json.Unmarshal([]byte(line), &entry)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
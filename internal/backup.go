@@ -0,0 +1,596 @@
+package internal
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// BackupManifest is stored as 'manifest.json' in every backup archive.
+type BackupManifest struct {
+	Project string            `json:"project"`
+	Created string            `json:"created"`
+	Tables  []string          `json:"tables"`
+	Files   map[string]string `json:"files"` // path in archive to sha256 checksum
+	Seqs    map[string]int64  `json:"seqs"`  // table to the sequence number of its last change
+
+	// Incremental backups hold the rows changed since the backup named by Parent, which is in the same
+	// folder, the backups folder of the project. FullTables are the tables which are copied whole because they could not be exported
+	// incrementally.
+	Incremental bool     `json:"incremental,omitempty"`
+	Parent      string   `json:"parent,omitempty"`
+	FullTables  []string `json:"full_tables,omitempty"`
+}
+
+// IncrementalTableChanges is stored as '<table>/changes.json' in incremental backups.
+type IncrementalTableChanges struct {
+	Changed map[string]map[string]string `json:"changed"`
+	Deleted []string                     `json:"deleted"`
+}
+
+// files of a table which are copied into every incremental backup. The trash and history files are
+// copied whole as their changes are not in the change log.
+var incrementalCopiedFiles = []string{"options.zconf", "lastId.txt", "lastSeq.txt", "trash.flaa1", "trash.flaa2",
+	"history.flaa1", "history.flaa2"}
+
+// GetBackupsPath returns the folder where backups are written. It is created if necessary.
+func GetBackupsPath() string {
+	rootPath, _ := GetRootPath()
+	backupsPath := filepath.Join(rootPath, "flaarum_backups")
+	os.MkdirAll(backupsPath, 0777)
+	return backupsPath
+}
+
+// GetProjectBackupsPath returns the folder of the backups of a project. It is created if necessary.
+func GetProjectBackupsPath(projName string) string {
+	backupsPath := filepath.Join(GetBackupsPath(), projName)
+	os.MkdirAll(backupsPath, 0777)
+	return backupsPath
+}
+
+// ResolveBackupName returns the path of the backup archive backupName of the project projName. Only
+// the names of the archives in the project's backups folder are accepted.
+func ResolveBackupName(projName, backupName string) (string, error) {
+	if backupName != filepath.Base(backupName) || !strings.HasSuffix(backupName, ".tar.gz") {
+		return "", errors.New(fmt.Sprintf("'%s' is not the name of a backup archive", backupName))
+	}
+
+	backupsPath, err := filepath.EvalSymlinks(GetProjectBackupsPath(projName))
+	if err != nil {
+		return "", errors.Wrap(err, "os error")
+	}
+	archivePath := filepath.Join(backupsPath, backupName)
+	if !DoesPathExists(archivePath) {
+		return "", errors.New(fmt.Sprintf("the backup '%s' of project '%s' does not exists.", backupName, projName))
+	}
+
+	// the archive may be a link, which must not lead out of the folder
+	resolvedPath, err := filepath.EvalSymlinks(archivePath)
+	if err != nil {
+		return "", errors.Wrap(err, "os error")
+	}
+	if filepath.Dir(resolvedPath) != backupsPath {
+		return "", errors.New(fmt.Sprintf("the backup '%s' of project '%s' is outside its backups folder", backupName, projName))
+	}
+
+	return archivePath, nil
+}
+
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", errors.Wrap(err, "os error")
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", errors.Wrap(err, "os error")
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+func addFileToTar(tw *tar.Writer, srcPath, nameInArchive string) error {
+	stat, err := os.Stat(srcPath)
+	if err != nil {
+		return errors.Wrap(err, "os error")
+	}
+
+	header := &tar.Header{
+		Name:    nameInArchive,
+		Mode:    0644,
+		Size:    stat.Size(),
+		ModTime: stat.ModTime(),
+	}
+	err = tw.WriteHeader(header)
+	if err != nil {
+		return errors.Wrap(err, "tar error")
+	}
+
+	f, err := os.Open(srcPath)
+	if err != nil {
+		return errors.Wrap(err, "os error")
+	}
+	defer f.Close()
+
+	_, err = io.Copy(tw, f)
+	if err != nil {
+		return errors.Wrap(err, "tar error")
+	}
+	return nil
+}
+
+func addBytesToTar(tw *tar.Writer, data []byte, nameInArchive string) error {
+	err := tw.WriteHeader(&tar.Header{Name: nameInArchive, Mode: 0644, Size: int64(len(data)),
+		ModTime: time.Now()})
+	if err != nil {
+		return errors.Wrap(err, "tar error")
+	}
+	_, err = tw.Write(data)
+	if err != nil {
+		return errors.Wrap(err, "tar error")
+	}
+	return nil
+}
+
+// addTableFileToBackup adds a file of a table to a backup archive and its checksum to the manifest.
+func addTableFileToBackup(tw *tar.Writer, manifest *BackupManifest, projName, tableName, fileName string) error {
+	srcPath := filepath.Join(GetTablePath(projName, tableName), fileName)
+	nameInArchive := tableName + "/" + fileName
+	checksum, err := fileChecksum(srcPath)
+	if err != nil {
+		return err
+	}
+	manifest.Files[nameInArchive] = checksum
+
+	return addFileToTar(tw, srcPath, nameInArchive)
+}
+
+func addTableToBackup(tw *tar.Writer, manifest *BackupManifest, projName, tableName string) error {
+	dirFIs, err := os.ReadDir(GetTablePath(projName, tableName))
+	if err != nil {
+		return errors.Wrap(err, "directory read error")
+	}
+
+	for _, dirFI := range dirFIs {
+		if dirFI.IsDir() {
+			continue
+		}
+
+		err = addTableFileToBackup(tw, manifest, projName, tableName, dirFI.Name())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func newBackupManifest(projName string, tables []string) BackupManifest {
+	manifest := BackupManifest{
+		Project: projName,
+		Created: time.Now().Format(time.RFC3339),
+		Tables:  tables,
+		Files:   make(map[string]string),
+		Seqs:    make(map[string]int64),
+	}
+	for _, tableName := range tables {
+		manifest.Seqs[tableName] = GetLastSeq(projName, tableName)
+	}
+
+	return manifest
+}
+
+// writeBackupArchive creates a backup archive at outPath, lets addFn write the tables into it and
+// closes it with the manifest.
+func writeBackupArchive(outPath string, manifest *BackupManifest, addFn func(tw *tar.Writer) error) error {
+	outHandle, err := os.Create(outPath)
+	if err != nil {
+		return errors.Wrap(err, "os error")
+	}
+	defer outHandle.Close()
+
+	gw := gzip.NewWriter(outHandle)
+	tw := tar.NewWriter(gw)
+
+	err = addFn(tw)
+	if err != nil {
+		return err
+	}
+
+	manifestBytes, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "json error")
+	}
+	err = addBytesToTar(tw, manifestBytes, "manifest.json")
+	if err != nil {
+		return err
+	}
+
+	if err = tw.Close(); err != nil {
+		return errors.Wrap(err, "tar error")
+	}
+	if err = gw.Close(); err != nil {
+		return errors.Wrap(err, "gzip error")
+	}
+
+	return nil
+}
+
+// WriteBackupArchive writes every file of the given tables of a project into a gzipped tar archive
+// at outPath. The caller must make sure the tables are not written to while this runs.
+func WriteBackupArchive(projName string, tables []string, outPath string) (BackupManifest, error) {
+	manifest := newBackupManifest(projName, tables)
+
+	err := writeBackupArchive(outPath, &manifest, func(tw *tar.Writer) error {
+		for _, tableName := range tables {
+			err := addTableToBackup(tw, &manifest, projName, tableName)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	return manifest, err
+}
+
+// WriteIncrementalBackupArchive writes the rows of the given tables which changed after the backup
+// described by parent into a gzipped tar archive at outPath. Tables which are not in parent or whose
+// change log was restarted are copied whole. The caller must make sure the tables are not written
+// to while this runs.
+func WriteIncrementalBackupArchive(projName string, tables []string, parentName string, parent BackupManifest,
+	outPath string) (BackupManifest, error) {
+
+	manifest := newBackupManifest(projName, tables)
+	manifest.Incremental = true
+	manifest.Parent = parentName
+	manifest.FullTables = make([]string, 0)
+
+	err := writeBackupArchive(outPath, &manifest, func(tw *tar.Writer) error {
+		for _, tableName := range tables {
+			parentSeq, ok := parent.Seqs[tableName]
+			// the table is copied whole when its changes since the parent were removed from its log
+			if !ok || manifest.Seqs[tableName] < parentSeq || parentSeq < GetTrimmedSeq(projName, tableName) {
+				manifest.FullTables = append(manifest.FullTables, tableName)
+				err := addTableToBackup(tw, &manifest, projName, tableName)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			dirFIs, err := os.ReadDir(GetTablePath(projName, tableName))
+			if err != nil {
+				return errors.Wrap(err, "directory read error")
+			}
+			for _, dirFI := range dirFIs {
+				name := dirFI.Name()
+				isStructure := strings.HasPrefix(name, "structure") && strings.HasSuffix(name, ".txt")
+				if isStructure || slices.Contains(incrementalCopiedFiles, name) {
+					err = addTableFileToBackup(tw, &manifest, projName, tableName, name)
+					if err != nil {
+						return err
+					}
+				}
+			}
+
+			changes, err := ReadChangesSince(projName, tableName, parentSeq)
+			if err != nil {
+				return err
+			}
+
+			// only the last change of every row is kept
+			tableChanges := IncrementalTableChanges{make(map[string]map[string]string), make([]string, 0)}
+			for _, change := range changes {
+				// purges only change the trash
+				if change.Op == CHANGE_PURGE_TRASH {
+					continue
+				}
+				if change.Op == HISTORY_DELETE {
+					delete(tableChanges.Changed, change.Id)
+					if !slices.Contains(tableChanges.Deleted, change.Id) {
+						tableChanges.Deleted = append(tableChanges.Deleted, change.Id)
+					}
+				} else {
+					tableChanges.Deleted = slices.DeleteFunc(tableChanges.Deleted, func(id string) bool {
+						return id == change.Id
+					})
+					tableChanges.Changed[change.Id] = change.Row
+				}
+			}
+
+			changesBytes, err := json.Marshal(tableChanges)
+			if err != nil {
+				return errors.Wrap(err, "json error")
+			}
+			nameInArchive := tableName + "/changes.json"
+			manifest.Files[nameInArchive] = fmt.Sprintf("%x", sha256.Sum256(changesBytes))
+			err = addBytesToTar(tw, changesBytes, nameInArchive)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	return manifest, err
+}
+
+// ExtractBackupArchive extracts a backup archive into destPath and verifies the checksums of the
+// extracted files against the archive's manifest.
+func ExtractBackupArchive(archivePath, destPath string) (BackupManifest, error) {
+	var manifest BackupManifest
+
+	archiveHandle, err := os.Open(archivePath)
+	if err != nil {
+		return manifest, errors.Wrap(err, "os error")
+	}
+	defer archiveHandle.Close()
+
+	gr, err := gzip.NewReader(archiveHandle)
+	if err != nil {
+		return manifest, errors.Wrap(err, "gzip error")
+	}
+	defer gr.Close()
+
+	err = os.MkdirAll(destPath, 0777)
+	if err != nil {
+		return manifest, errors.Wrap(err, "os error")
+	}
+
+	tr := tar.NewReader(gr)
+	extracted := make([]string, 0)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return manifest, errors.Wrap(err, "tar error")
+		}
+
+		cleanName := filepath.Clean(header.Name)
+		if strings.HasPrefix(cleanName, "..") || filepath.IsAbs(cleanName) {
+			return manifest, errors.New(fmt.Sprintf("invalid path '%s' in backup archive", header.Name))
+		}
+
+		if cleanName == "manifest.json" {
+			raw, err := io.ReadAll(tr)
+			if err != nil {
+				return manifest, errors.Wrap(err, "tar error")
+			}
+			err = json.Unmarshal(raw, &manifest)
+			if err != nil {
+				return manifest, errors.Wrap(err, "json error")
+			}
+			continue
+		}
+
+		outPath := filepath.Join(destPath, cleanName)
+		os.MkdirAll(filepath.Dir(outPath), 0777)
+		outHandle, err := os.Create(outPath)
+		if err != nil {
+			return manifest, errors.Wrap(err, "os error")
+		}
+		_, err = io.Copy(outHandle, tr)
+		outHandle.Close()
+		if err != nil {
+			return manifest, errors.Wrap(err, "tar error")
+		}
+		extracted = append(extracted, header.Name)
+	}
+
+	if manifest.Project == "" {
+		return manifest, errors.New("the backup archive has no manifest")
+	}
+
+	if len(extracted) != len(manifest.Files) {
+		return manifest, errors.New("the backup archive does not contain the files listed in its manifest")
+	}
+	for _, name := range extracted {
+		expected, ok := manifest.Files[name]
+		if !ok {
+			return manifest, errors.New(fmt.Sprintf("the file '%s' is not in the manifest", name))
+		}
+		checksum, err := fileChecksum(filepath.Join(destPath, filepath.Clean(name)))
+		if err != nil {
+			return manifest, err
+		}
+		if checksum != expected {
+			return manifest, errors.New(fmt.Sprintf("checksum mismatch for '%s'", name))
+		}
+	}
+
+	return manifest, nil
+}
+
+// ReadBackupManifest returns the manifest of a backup archive without extracting it.
+func ReadBackupManifest(archivePath string) (BackupManifest, error) {
+	var manifest BackupManifest
+
+	archiveHandle, err := os.Open(archivePath)
+	if err != nil {
+		return manifest, errors.Wrap(err, "os error")
+	}
+	defer archiveHandle.Close()
+
+	gr, err := gzip.NewReader(archiveHandle)
+	if err != nil {
+		return manifest, errors.Wrap(err, "gzip error")
+	}
+	defer gr.Close()
+
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return manifest, errors.Wrap(err, "tar error")
+		}
+
+		if header.Name == "manifest.json" {
+			raw, err := io.ReadAll(tr)
+			if err != nil {
+				return manifest, errors.Wrap(err, "tar error")
+			}
+			err = json.Unmarshal(raw, &manifest)
+			if err != nil {
+				return manifest, errors.Wrap(err, "json error")
+			}
+			return manifest, nil
+		}
+	}
+
+	return manifest, errors.New("the backup archive has no manifest")
+}
+
+// FindLatestBackup returns the path of the newest backup archive of a project in the backups folder.
+// It returns an empty string if the project has no backups.
+func FindLatestBackup(projName string) (string, error) {
+	dirFIs, err := os.ReadDir(GetProjectBackupsPath(projName))
+	if err != nil {
+		return "", errors.Wrap(err, "directory read error")
+	}
+
+	nameRegex := regexp.MustCompile(`^` + regexp.QuoteMeta(projName) + `-\d{8}T\d{6}(-incr)?\.tar\.gz$`)
+	latest := ""
+	for _, dirFI := range dirFIs {
+		// the timestamps in the names sort in time order
+		if nameRegex.MatchString(dirFI.Name()) && dirFI.Name() > latest {
+			latest = dirFI.Name()
+		}
+	}
+
+	if latest == "" {
+		return "", nil
+	}
+	return filepath.Join(GetProjectBackupsPath(projName), latest), nil
+}
+
+// GetBackupChain returns the archives needed to restore archivePath: a full backup followed by the
+// incremental backups built on it, ending with archivePath.
+func GetBackupChain(archivePath string) ([]string, error) {
+	chain := make([]string, 0)
+	currentPath := archivePath
+	for {
+		if slices.Contains(chain, currentPath) {
+			return nil, errors.New(fmt.Sprintf("the backup '%s' is its own ancestor", currentPath))
+		}
+		chain = append([]string{currentPath}, chain...)
+
+		manifest, err := ReadBackupManifest(currentPath)
+		if err != nil {
+			return nil, errors.Wrap(err, currentPath)
+		}
+		if !manifest.Incremental {
+			return chain, nil
+		}
+		if manifest.Parent != filepath.Base(manifest.Parent) {
+			return nil, errors.New(fmt.Sprintf("the parent '%s' of the backup '%s' is not in its folder", manifest.Parent, currentPath))
+		}
+
+		currentPath = filepath.Join(filepath.Dir(currentPath), manifest.Parent)
+		if !DoesPathExists(currentPath) {
+			return nil, errors.New(fmt.Sprintf("the parent backup '%s' does not exists", currentPath))
+		}
+	}
+}
+
+// ApplyIncrementalBackup replays an extracted incremental backup on top of a restored project.
+// The caller must make sure the project's tables are not used while this runs.
+func ApplyIncrementalBackup(projName, extractedPath string, manifest BackupManifest) error {
+	currentTables, err := ListTables(projName)
+	if err != nil {
+		return err
+	}
+	for _, tableName := range currentTables {
+		if !slices.Contains(manifest.Tables, tableName) {
+			os.RemoveAll(GetTablePath(projName, tableName))
+		}
+	}
+
+	for _, tableName := range manifest.Tables {
+		tablePath := GetTablePath(projName, tableName)
+		extractedTablePath := filepath.Join(extractedPath, tableName)
+
+		if slices.Contains(manifest.FullTables, tableName) {
+			os.RemoveAll(tablePath)
+			err = os.Rename(extractedTablePath, tablePath)
+			if err != nil {
+				return errors.Wrap(err, "rename failed.")
+			}
+			continue
+		}
+
+		if !DoesPathExists(tablePath) {
+			return errors.New(fmt.Sprintf("the table '%s' is missing from the earlier backups", tableName))
+		}
+
+		// the copied files missing from the backup were removed, like the trash files of an emptied trash
+		for _, name := range incrementalCopiedFiles {
+			if !DoesPathExists(filepath.Join(extractedTablePath, name)) {
+				os.Remove(filepath.Join(tablePath, name))
+			}
+		}
+
+		dirFIs, err := os.ReadDir(extractedTablePath)
+		if err != nil {
+			return errors.Wrap(err, "directory read error")
+		}
+		for _, dirFI := range dirFIs {
+			if dirFI.Name() == "changes.json" {
+				continue
+			}
+			raw, err := os.ReadFile(filepath.Join(extractedTablePath, dirFI.Name()))
+			if err != nil {
+				return errors.Wrap(err, "file read error")
+			}
+			err = os.WriteFile(filepath.Join(tablePath, dirFI.Name()), raw, 0777)
+			if err != nil {
+				return errors.Wrap(err, "os error")
+			}
+		}
+
+		raw, err := os.ReadFile(filepath.Join(extractedTablePath, "changes.json"))
+		if err != nil {
+			return errors.Wrap(err, "file read error")
+		}
+		var tableChanges IncrementalTableChanges
+		err = json.Unmarshal(raw, &tableChanges)
+		if err != nil {
+			return errors.Wrap(err, "json error")
+		}
+
+		if len(tableChanges.Changed) == 0 && len(tableChanges.Deleted) == 0 {
+			continue
+		}
+
+		err = ApplyRowChanges(projName, tableName, tableChanges.Changed, tableChanges.Deleted)
+		if err != nil {
+			return err
+		}
+
+		if DoesPathExists(filepath.Join(tablePath, "data.flaa2")) {
+			err = ReindexTable(projName, tableName)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,295 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// CHANGE_PURGE_TRASH is the op of the changes which purge rows from the trash of a table. The Id of
+// such a change is that of the purged row, or empty when the whole trash was purged.
+const CHANGE_PURGE_TRASH = "purge_trash"
+
+// ChangeEntry is an entry in the change log of a table. Op is one of HISTORY_INSERT, HISTORY_UPDATE,
+// HISTORY_DELETE and CHANGE_PURGE_TRASH. Row holds the data of the row after the operation; it is
+// empty for deletes and purges.
+type ChangeEntry struct {
+	Seq   int64             `json:"seq"`
+	Time  time.Time         `json:"time"`
+	Table string            `json:"table"`
+	Op    string            `json:"op"`
+	Id    string            `json:"id"`
+	Row   map[string]string `json:"row,omitempty"`
+}
+
+// ChangeLogFiles are the files of the change log of a table.
+var ChangeLogFiles = []string{"changes.flaa1", "changes.flaa2", "lastSeq.txt", "trimmedSeq.txt"}
+
+// GetLastSeq returns the sequence number of the last change of a table. It is 0 for tables
+// without changes.
+func GetLastSeq(projName, tableName string) int64 {
+	raw, err := os.ReadFile(filepath.Join(GetTablePath(projName, tableName), "lastSeq.txt"))
+	if err != nil {
+		return 0
+	}
+	lastSeq, _ := strconv.ParseInt(strings.TrimSpace(string(raw)), 10, 64)
+	return lastSeq
+}
+
+// RecordChange appends a change to the change log of a table and gives it the next sequence number.
+// The change log is kept in 'changes.flaa1' and 'changes.flaa2' with the sequence numbers as keys.
+// The caller must hold the table's write lock.
+func RecordChange(projName, tableName, op, rowId string, row map[string]string) (ChangeEntry, error) {
+	entry := ChangeEntry{
+		Seq:   GetLastSeq(projName, tableName) + 1,
+		Time:  time.Now(),
+		Table: tableName,
+		Op:    op,
+		Id:    rowId,
+		Row:   row,
+	}
+
+	return entry, AppendChange(projName, entry)
+}
+
+// AppendChange writes a change with its sequence number to the change log of a table. It is used
+// directly by replicas to keep the sequence numbers of their primary.
+func AppendChange(projName string, entry ChangeEntry) error {
+	tableName := entry.Table
+	toWrite := make(map[string]string)
+	for k, v := range entry.Row {
+		if k == "id" {
+			continue
+		}
+		toWrite[k] = v
+	}
+	toWrite["_change_op"] = entry.Op
+	toWrite["_change_id"] = entry.Id
+	toWrite["_change_time"] = strconv.FormatInt(entry.Time.UnixNano(), 10)
+
+	seqStr := strconv.FormatInt(entry.Seq, 10)
+	err := AppendRowData(projName, tableName, "changes", seqStr, toWrite)
+	if err != nil {
+		return err
+	}
+
+	lastSeqPath := filepath.Join(GetTablePath(projName, tableName), "lastSeq.txt")
+	err = os.WriteFile(lastSeqPath, []byte(seqStr), 0777)
+	if err != nil {
+		return errors.Wrap(err, "os error")
+	}
+
+	return nil
+}
+
+// ReadChangesSince returns the changes of a table with sequence numbers greater than afterSeq,
+// oldest first.
+func ReadChangesSince(projName, tableName string, afterSeq int64) ([]ChangeEntry, error) {
+	ret := make([]ChangeEntry, 0)
+	changesF1Path := filepath.Join(GetTablePath(projName, tableName), "changes.flaa1")
+	if !DoesPathExists(changesF1Path) {
+		return ret, nil
+	}
+
+	elemsMap, err := ParseDataF1File(changesF1Path)
+	if err != nil {
+		return ret, err
+	}
+
+	for key, elem := range elemsMap {
+		seq, err := strconv.ParseInt(key, 10, 64)
+		if err != nil || seq <= afterSeq {
+			continue
+		}
+
+		rawRowData, err := ReadPortionF2File(projName, tableName, "changes", elem.DataBegin, elem.DataEnd)
+		if err != nil {
+			return ret, err
+		}
+		rowMap, err := ParseEncodedRowData(rawRowData)
+		if err != nil {
+			return ret, err
+		}
+
+		entry := ChangeEntry{Seq: seq, Table: tableName, Op: rowMap["_change_op"], Id: rowMap["_change_id"]}
+		nanos, _ := strconv.ParseInt(rowMap["_change_time"], 10, 64)
+		entry.Time = time.Unix(0, nanos)
+		delete(rowMap, "_change_op")
+		delete(rowMap, "_change_id")
+		delete(rowMap, "_change_time")
+		if entry.Op != HISTORY_DELETE && entry.Op != CHANGE_PURGE_TRASH {
+			rowMap["id"] = entry.Id
+			entry.Row = rowMap
+		}
+
+		ret = append(ret, entry)
+	}
+
+	slices.SortFunc(ret, func(a, b ChangeEntry) int {
+		return int(a.Seq - b.Seq)
+	})
+
+	return ret, nil
+}
+
+// GetTrimmedSeq returns the sequence number of the last change CompactChangeLog removed from the
+// change log of a table. Every change after it is still in the log. It is 0 for tables whose log
+// was never compacted.
+func GetTrimmedSeq(projName, tableName string) int64 {
+	raw, err := os.ReadFile(filepath.Join(GetTablePath(projName, tableName), "trimmedSeq.txt"))
+	if err != nil {
+		return 0
+	}
+	trimmedSeq, _ := strconv.ParseInt(strings.TrimSpace(string(raw)), 10, 64)
+	return trimmedSeq
+}
+
+// ChangeLogCutoff returns the time before which changes are removed from the change logs, from the
+// 'change_log_retention' setting in days. It returns false when the setting is 0, which keeps every
+// change.
+func ChangeLogCutoff() (time.Time, bool) {
+	days, err := strconv.Atoi(GetSetting("change_log_retention"))
+	if err != nil || days < 0 {
+		days = 7
+	}
+	if days == 0 {
+		return time.Time{}, false
+	}
+
+	return time.Now().Add(-time.Duration(days) * 24 * time.Hour), true
+}
+
+// CompactChangeLog removes the changes recorded before cutoff from the change log of a table and
+// returns how many it removed. The caller must hold the table's write lock.
+func CompactChangeLog(projName, tableName string, cutoff time.Time) (int, error) {
+	tablePath := GetTablePath(projName, tableName)
+	changesF1Path := filepath.Join(tablePath, "changes.flaa1")
+	if !DoesPathExists(changesF1Path) {
+		return 0, nil
+	}
+
+	elemsMap, err := ParseDataF1File(changesF1Path)
+	if err != nil {
+		return 0, err
+	}
+	elems := make([]DataF1Elem, 0, len(elemsMap))
+	for _, elem := range elemsMap {
+		elems = append(elems, elem)
+	}
+	seqOf := func(elem DataF1Elem) int64 {
+		seq, _ := strconv.ParseInt(elem.DataKey, 10, 64)
+		return seq
+	}
+	slices.SortFunc(elems, func(a, b DataF1Elem) int {
+		return int(seqOf(a) - seqOf(b))
+	})
+
+	// the changes are in the order they were recorded, so the expired ones come first
+	removed := 0
+	for _, elem := range elems {
+		raw, err := ReadPortionF2File(projName, tableName, "changes", elem.DataBegin, elem.DataEnd)
+		if err != nil {
+			return 0, err
+		}
+		rowMap, err := ParseEncodedRowData(raw)
+		if err != nil {
+			return 0, err
+		}
+		nanos, _ := strconv.ParseInt(rowMap["_change_time"], 10, 64)
+		if !time.Unix(0, nanos).Before(cutoff) {
+			break
+		}
+		removed += 1
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+
+	newF2 := make([]byte, 0)
+	newElems := make(map[string]DataF1Elem)
+	for _, elem := range elems[removed:] {
+		raw, err := ReadPortionF2File(projName, tableName, "changes", elem.DataBegin, elem.DataEnd)
+		if err != nil {
+			return 0, err
+		}
+		begin := int64(len(newF2))
+		newF2 = append(newF2, raw...)
+		newElems[elem.DataKey] = DataF1Elem{DataKey: elem.DataKey, DataBegin: begin, DataEnd: int64(len(newF2))}
+	}
+
+	trimmedSeq := seqOf(elems[removed-1])
+	err = os.WriteFile(filepath.Join(tablePath, "trimmedSeq.txt"), []byte(strconv.FormatInt(trimmedSeq, 10)), 0777)
+	if err != nil {
+		return 0, errors.Wrap(err, "os error")
+	}
+
+	tmpF2Path := filepath.Join(tablePath, "changes.flaa2.tmp")
+	err = os.WriteFile(tmpF2Path, newF2, 0777)
+	if err != nil {
+		return 0, errors.Wrap(err, "os error")
+	}
+	err = os.Rename(tmpF2Path, filepath.Join(tablePath, "changes.flaa2"))
+	if err != nil {
+		return 0, errors.Wrap(err, "os error")
+	}
+
+	return removed, RewriteF1File(projName, tableName, "changes", newElems)
+}
+
+// ApplyRowChanges writes the changed rows into the data files of a table and removes the deleted
+// ones. It does not update the indexes; the table must be reindexed afterwards.
+func ApplyRowChanges(projName, tableName string, changed map[string]map[string]string, deleted []string) error {
+	tablePath := GetTablePath(projName, tableName)
+	dataF1Path := filepath.Join(tablePath, "data.flaa1")
+
+	elemsMap := make(map[string]DataF1Elem)
+	if DoesPathExists(dataF1Path) {
+		var err error
+		elemsMap, err = ParseDataF1File(dataF1Path)
+		if err != nil {
+			return err
+		}
+	}
+
+	toRemove := slices.Clone(deleted)
+	for id := range changed {
+		toRemove = append(toRemove, id)
+	}
+
+	dataLumpPath := filepath.Join(tablePath, "data.flaa2")
+	if DoesPathExists(dataLumpPath) {
+		dataLumpHandle, err := os.OpenFile(dataLumpPath, os.O_WRONLY, 0777)
+		if err != nil {
+			return errors.Wrap(err, "os error")
+		}
+		defer dataLumpHandle.Close()
+
+		for _, id := range toRemove {
+			elem, ok := elemsMap[id]
+			if !ok {
+				continue
+			}
+			dataLumpHandle.WriteAt(make([]byte, elem.DataEnd-elem.DataBegin), elem.DataBegin)
+			delete(elemsMap, id)
+		}
+	}
+
+	err := RewriteF1File(projName, tableName, "data", elemsMap)
+	if err != nil {
+		return err
+	}
+
+	for id, row := range changed {
+		err = SaveRowData(projName, tableName, id, row)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
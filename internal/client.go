@@ -0,0 +1,140 @@
+package internal
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gookit/color"
+	"github.com/pkg/errors"
+)
+
+// localHttpClient returns the client used for requests to the server on this machine. When client_auth
+// is on, it presents the certificate of the local client created by 'flaarum.prod genca'.
+func localHttpClient() (*http.Client, error) {
+	config := &tls.Config{InsecureSkipVerify: true}
+	if GetSetting("client_auth") == "true" {
+		rootPath, _ := GetRootPath()
+		cert, err := tls.LoadX509KeyPair(filepath.Join(rootPath, "flaarum-client-local.crt"),
+			filepath.Join(rootPath, "flaarum-client-local.key"))
+		if err != nil {
+			return nil, errors.Wrap(err, "tls error")
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	tr := &http.Transport{TLSClientConfig: config}
+	return &http.Client{Transport: tr}, nil
+}
+
+func getLocalKeyStr() string {
+	inProd := GetSetting("in_production")
+	if inProd == "" {
+		color.Red.Println("unexpected error. Have you installed  and launched flaarum?")
+		os.Exit(1)
+	}
+	if inProd == "true" {
+		keyStrPath := GetKeyStrPath()
+		raw, err := os.ReadFile(keyStrPath)
+		if err != nil {
+			color.Red.Println(err)
+			os.Exit(1)
+		}
+		return string(raw)
+	}
+
+	return "not-yet-set"
+}
+
+// LocalRequest sends a request to the flaarum server running on this machine. It is used for
+// the endpoints which are not exposed by flaarumlib.
+func LocalRequest(path string, values url.Values) ([]byte, error) {
+	port := GetSetting("port")
+	if port == "" {
+		return nil, errors.New("unexpected error. Have you installed  and launched flaarum?")
+	}
+
+	if values == nil {
+		values = url.Values{}
+	}
+	values.Set("key-str", getLocalKeyStr())
+
+	httpCl, err := localHttpClient()
+	if err != nil {
+		return nil, err
+	}
+
+	urlPrefix := fmt.Sprintf("https://127.0.0.1:%s/", port)
+	resp, err := httpCl.PostForm(urlPrefix+strings.TrimPrefix(path, "/"), values)
+	if err != nil {
+		return nil, errors.Wrap(err, "http error")
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "http error")
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, errors.New(string(body))
+	}
+
+	return body, nil
+}
+
+// primaryHttpClient returns the client used by a replica for requests to its primary. If the replica
+// has the client certificate 'flaarum-client-replica.crt' (created on the primary with
+// 'flaarum.prod gencc replica'), it is presented.
+func primaryHttpClient() (*http.Client, error) {
+	config := &tls.Config{InsecureSkipVerify: true}
+	rootPath, _ := GetRootPath()
+	crtPath := filepath.Join(rootPath, "flaarum-client-replica.crt")
+	if DoesPathExists(crtPath) {
+		cert, err := tls.LoadX509KeyPair(crtPath, filepath.Join(rootPath, "flaarum-client-replica.key"))
+		if err != nil {
+			return nil, errors.Wrap(err, "tls error")
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	tr := &http.Transport{TLSClientConfig: config}
+	return &http.Client{Transport: tr}, nil
+}
+
+// PrimaryRequest sends a request to the primary set in the 'replica_of' setting with the key in the
+// 'replica_key_str' setting. The caller must close the body of the response, whose status is 200.
+func PrimaryRequest(path string, values url.Values) (*http.Response, error) {
+	primaryAddr := GetSetting("replica_of")
+	if primaryAddr == "" {
+		return nil, errors.New("this store is not a replica")
+	}
+
+	if values == nil {
+		values = url.Values{}
+	}
+	values.Set("key-str", GetSetting("replica_key_str"))
+
+	httpCl, err := primaryHttpClient()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := httpCl.PostForm(fmt.Sprintf("https://%s/%s", primaryAddr, strings.TrimPrefix(path, "/")), values)
+	if err != nil {
+		return nil, errors.Wrap(err, "http error")
+	}
+
+	if resp.StatusCode != 200 {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return nil, errors.New(fmt.Sprintf("primary responded with status %d: %s", resp.StatusCode, body))
+	}
+
+	return resp, nil
+}
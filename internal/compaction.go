@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// FileSpace describes the space used by a 'flaa2' file. LiveBytes are the bytes its 'flaa1' file
+// points to; the rest are left by updates and deletes and are only reclaimed by a trim.
+type FileSpace struct {
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`
+	LiveBytes int64  `json:"live_bytes"`
+	DeadBytes int64  `json:"dead_bytes"`
+}
+
+// GetFileSpace returns the space used by the file pair 'name' of a table.
+func GetFileSpace(projName, tableName, name string) (FileSpace, error) {
+	tablePath := GetTablePath(projName, tableName)
+	space := FileSpace{Name: name}
+
+	stat, err := os.Stat(filepath.Join(tablePath, name+".flaa2"))
+	if os.IsNotExist(err) {
+		return space, nil
+	} else if err != nil {
+		return space, errors.Wrap(err, "os error")
+	}
+	space.Size = stat.Size()
+
+	f1Path := filepath.Join(tablePath, name+".flaa1")
+	if DoesPathExists(f1Path) {
+		elemsMap, err := ParseDataF1File(f1Path)
+		if err != nil {
+			return space, err
+		}
+		for _, elem := range elemsMap {
+			space.LiveBytes += elem.DataEnd - elem.DataBegin
+		}
+	}
+	space.DeadBytes = max(space.Size-space.LiveBytes, 0)
+
+	return space, nil
+}
+
+// GetTableSpace returns the space used by the data file and the indexes files of a table, which
+// are the files a trim rewrites.
+func GetTableSpace(projName, tableName string) ([]FileSpace, error) {
+	dirFIs, err := os.ReadDir(GetTablePath(projName, tableName))
+	if err != nil {
+		return nil, errors.Wrap(err, "directory read error")
+	}
+
+	names := []string{"data"}
+	for _, dirFI := range dirFIs {
+		if strings.HasSuffix(dirFI.Name(), "_indexes.flaa2") {
+			names = append(names, strings.TrimSuffix(dirFI.Name(), ".flaa2"))
+		}
+	}
+
+	ret := make([]FileSpace, 0, len(names))
+	for _, name := range names {
+		space, err := GetFileSpace(projName, tableName, name)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, space)
+	}
+
+	return ret, nil
+}
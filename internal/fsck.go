@@ -0,0 +1,310 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// kinds of the problems found by CheckTable
+const (
+	FSCK_BAD_OFFSET       = "bad_offset"
+	FSCK_BAD_ROW          = "bad_row"
+	FSCK_BAD_INDEX_OFFSET = "bad_index_offset"
+	FSCK_STALE_INDEX      = "stale_index"
+	FSCK_MISSING_INDEX    = "missing_index"
+	FSCK_BROKEN_FKEY      = "broken_foreign_key"
+	FSCK_DUPLICATE_UNIQUE = "duplicate_unique"
+)
+
+// FsckProblem is a problem found in the files of a table.
+type FsckProblem struct {
+	Table  string `json:"table"`
+	Kind   string `json:"kind"`
+	Detail string `json:"detail"`
+	RowId  string `json:"row_id,omitempty"`
+}
+
+// Repairable tells if RepairTable fixes the problem. Broken foreign keys and duplicate unique
+// values are in the rows themselves and must be fixed by hand.
+func (p FsckProblem) Repairable() bool {
+	return p.Kind != FSCK_BROKEN_FKEY && p.Kind != FSCK_DUPLICATE_UNIQUE
+}
+
+func readTableIds(projName, tableName string) (map[string]bool, error) {
+	ids := make(map[string]bool)
+	dataF1Path := filepath.Join(GetTablePath(projName, tableName), "data.flaa1")
+	if !DoesPathExists(dataF1Path) {
+		return ids, nil
+	}
+	elemsMap, err := ParseDataF1File(dataF1Path)
+	if err != nil {
+		return ids, err
+	}
+	for id := range elemsMap {
+		ids[id] = true
+	}
+	return ids, nil
+}
+
+// CheckTable validates the offsets of the data and indexes files of a table, parses every row,
+// cross-checks the indexes against the rows and checks the foreign keys and unique fields of the
+// current structure. The table must not be written to while this runs.
+func CheckTable(projName, tableName string) ([]FsckProblem, error) {
+	tablePath := GetTablePath(projName, tableName)
+	problems := make([]FsckProblem, 0)
+	report := func(kind, rowId, format string, args ...any) {
+		problems = append(problems, FsckProblem{tableName, kind, fmt.Sprintf(format, args...), rowId})
+	}
+
+	tableStruct, err := GetCurrentTableStructureParsed(projName, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	// rows
+	rows := make(map[string]map[string]string)
+	dataF1Path := filepath.Join(tablePath, "data.flaa1")
+	if DoesPathExists(dataF1Path) {
+		elemsMap, err := ParseDataF1File(dataF1Path)
+		if err != nil {
+			return nil, err
+		}
+		var dataSize int64
+		if stat, err := os.Stat(filepath.Join(tablePath, "data.flaa2")); err == nil {
+			dataSize = stat.Size()
+		}
+
+		for id, elem := range elemsMap {
+			if elem.DataBegin < 0 || elem.DataEnd < elem.DataBegin || elem.DataEnd > dataSize {
+				report(FSCK_BAD_OFFSET, id, "row '%s' points to bytes %d-%d of data.flaa2 which has %d bytes",
+					id, elem.DataBegin, elem.DataEnd, dataSize)
+				continue
+			}
+
+			rawRowData, err := ReadPortionF2File(projName, tableName, "data", elem.DataBegin, elem.DataEnd)
+			if err != nil {
+				return nil, err
+			}
+			rowMap, err := ParseEncodedRowData(rawRowData)
+			if err != nil {
+				report(FSCK_BAD_ROW, id, "row '%s' could not be parsed: %s", id, err)
+				continue
+			}
+			rows[id] = rowMap
+		}
+	}
+
+	// indexes
+	notIndexed := make(map[string]bool)
+	isIndexed := func(fieldName string) bool {
+		if _, ok := notIndexed[fieldName]; !ok {
+			notIndexed[fieldName] = IsNotIndexedField(projName, tableName, fieldName)
+		}
+		return fieldName != "id" && !notIndexed[fieldName]
+	}
+
+	indexed := make(map[string]map[string][]string)
+	dirFIs, err := os.ReadDir(tablePath)
+	if err != nil {
+		return nil, errors.Wrap(err, "directory read error")
+	}
+	for _, dirFI := range dirFIs {
+		if !strings.HasSuffix(dirFI.Name(), "_indexes.flaa1") {
+			continue
+		}
+		fieldName := strings.TrimSuffix(dirFI.Name(), "_indexes.flaa1")
+		indexed[fieldName] = make(map[string][]string)
+
+		idxElemsMap, err := ParseDataF1File(filepath.Join(tablePath, dirFI.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var idxSize int64
+		if stat, err := os.Stat(filepath.Join(tablePath, fieldName+"_indexes.flaa2")); err == nil {
+			idxSize = stat.Size()
+		}
+
+		for value, idxElem := range idxElemsMap {
+			if idxElem.DataBegin < 0 || idxElem.DataEnd < idxElem.DataBegin || idxElem.DataEnd > idxSize {
+				report(FSCK_BAD_INDEX_OFFSET, "", "the index of '%s' = '%s' points to bytes %d-%d of %s_indexes.flaa2 which has %d bytes",
+					fieldName, value, idxElem.DataBegin, idxElem.DataEnd, fieldName, idxSize)
+				continue
+			}
+
+			raw, err := ReadPortionF2File(projName, tableName, fieldName+"_indexes", idxElem.DataBegin, idxElem.DataEnd)
+			if err != nil {
+				return nil, err
+			}
+			for _, id := range strings.Split(string(raw), ",") {
+				if id == "" {
+					continue
+				}
+				indexed[fieldName][value] = append(indexed[fieldName][value], id)
+
+				row, ok := rows[id]
+				if !ok {
+					report(FSCK_STALE_INDEX, id, "the index of '%s' = '%s' refers to row '%s' which does not exist",
+						fieldName, value, id)
+				} else if row[fieldName] != value {
+					report(FSCK_STALE_INDEX, id, "the index of '%s' = '%s' refers to row '%s' whose value is '%s'",
+						fieldName, value, id, row[fieldName])
+				}
+			}
+		}
+	}
+
+	for id, row := range rows {
+		for fieldName, value := range row {
+			if !isIndexed(fieldName) {
+				continue
+			}
+			if !slices.Contains(indexed[fieldName][value], id) {
+				report(FSCK_MISSING_INDEX, id, "row '%s' is not in the index of '%s' = '%s'", id, fieldName, value)
+			}
+		}
+	}
+
+	// foreign keys
+	for _, fkd := range tableStruct.ForeignKeys {
+		pointedIds, err := readTableIds(projName, fkd.PointedTable)
+		if err != nil {
+			return nil, err
+		}
+		for id, row := range rows {
+			value, ok := row[fkd.FieldName]
+			if ok && value != "" && !pointedIds[value] {
+				report(FSCK_BROKEN_FKEY, id, "row '%s' has '%s' = '%s' which does not exist in table '%s'",
+					id, fkd.FieldName, value, fkd.PointedTable)
+			}
+		}
+	}
+
+	// unique fields
+	for _, fd := range tableStruct.Fields {
+		if !fd.Unique {
+			continue
+		}
+		seen := make(map[string]string)
+		for id, row := range rows {
+			value, ok := row[fd.FieldName]
+			if !ok || value == "" {
+				continue
+			}
+			if otherId, ok := seen[value]; ok {
+				report(FSCK_DUPLICATE_UNIQUE, id, "rows '%s' and '%s' have the same value '%s' for the unique field '%s'",
+					otherId, id, value, fd.FieldName)
+				continue
+			}
+			seen[value] = id
+		}
+	}
+
+	return problems, nil
+}
+
+// GetLostPath returns the folder where RepairTable keeps the bytes of the rows it drops from a table.
+func GetLostPath(projName, tableName string) string {
+	rootPath, _ := GetRootPath()
+	return filepath.Join(rootPath, "flaarum_lost", projName, tableName)
+}
+
+// saveLostRows copies the bytes of rows which cannot be read to a new folder in GetLostPath, one file
+// per row named after its id.
+func saveLostRows(projName, tableName string, elemsMap map[string]DataF1Elem, ids []string) error {
+	lostPath := filepath.Join(GetLostPath(projName, tableName), time.Now().Format("20060102T150405"))
+	err := os.MkdirAll(lostPath, 0777)
+	if err != nil {
+		return errors.Wrap(err, "os error")
+	}
+
+	var dataSize int64
+	if stat, err := os.Stat(filepath.Join(GetTablePath(projName, tableName), "data.flaa2")); err == nil {
+		dataSize = stat.Size()
+	}
+
+	for _, id := range ids {
+		elem, ok := elemsMap[id]
+		if !ok {
+			continue
+		}
+		// bad offsets are cut to the part of the file they cover
+		begin := min(max(elem.DataBegin, 0), dataSize)
+		end := min(max(elem.DataEnd, begin), dataSize)
+		raw := []byte{}
+		if end > begin {
+			raw, err = ReadPortionF2File(projName, tableName, "data", begin, end)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = os.WriteFile(filepath.Join(lostPath, id+".flaa2"), raw, 0777)
+		if err != nil {
+			return errors.Wrap(err, "os error")
+		}
+	}
+
+	return nil
+}
+
+// RepairTable fixes the repairable problems found by CheckTable. Rows which cannot be read are
+// copied to GetLostPath, recorded as deleted in the change log and dropped, and the table is
+// trimmed; the indexes are then rebuilt from the rows. The table must not be used while this runs.
+func RepairTable(projName, tableName string, problems []FsckProblem) error {
+	badRows := make([]string, 0)
+	needsReindex := false
+	for _, problem := range problems {
+		switch problem.Kind {
+		case FSCK_BAD_OFFSET, FSCK_BAD_ROW:
+			if !slices.Contains(badRows, problem.RowId) {
+				badRows = append(badRows, problem.RowId)
+			}
+			needsReindex = true
+		case FSCK_BAD_INDEX_OFFSET, FSCK_STALE_INDEX, FSCK_MISSING_INDEX:
+			needsReindex = true
+		}
+	}
+
+	if len(badRows) > 0 {
+		elemsMap, err := ParseDataF1File(filepath.Join(GetTablePath(projName, tableName), "data.flaa1"))
+		if err != nil {
+			return err
+		}
+		err = saveLostRows(projName, tableName, elemsMap, badRows)
+		if err != nil {
+			return err
+		}
+		for _, id := range badRows {
+			delete(elemsMap, id)
+		}
+		err = RewriteF1File(projName, tableName, "data", elemsMap)
+		if err != nil {
+			return err
+		}
+
+		// replicas and the followers of the change log must drop the rows too
+		for _, id := range badRows {
+			_, err = RecordChange(projName, tableName, HISTORY_DELETE, id, nil)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = TrimTable(projName, tableName)
+		if err != nil {
+			return err
+		}
+	}
+
+	if needsReindex {
+		return ReindexTable(projName, tableName)
+	}
+
+	return nil
+}
@@ -0,0 +1,99 @@
+package internal
+
+import (
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// operations recorded in the history of a row
+const (
+	HISTORY_INSERT = "insert"
+	HISTORY_UPDATE = "update"
+	HISTORY_DELETE = "delete"
+)
+
+// RowVersion is an entry in the history of a row. Row holds the data of the row before the
+// operation happened; it is empty for inserts.
+type RowVersion struct {
+	Time time.Time         `json:"time"`
+	Op   string            `json:"op"`
+	Row  map[string]string `json:"row"`
+}
+
+func IsHistoryKept(projName, tableName string) bool {
+	return GetTableOption(projName, tableName, "history") == "true"
+}
+
+// RecordRowHistory keeps oldRow in the history files of a table if the table has the 'history' option on.
+// The history files are 'history.flaa1' and 'history.flaa2' and their keys are of the form 'id/unixnano'.
+func RecordRowHistory(projName, tableName, rowId, op string, oldRow map[string]string) error {
+	if !IsHistoryKept(projName, tableName) {
+		return nil
+	}
+
+	toWrite := make(map[string]string)
+	for k, v := range oldRow {
+		if k == "id" || strings.Contains(k, ".") {
+			continue
+		}
+		toWrite[k] = v
+	}
+	toWrite["_history_op"] = op
+
+	historyKey := rowId + "/" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	return AppendRowData(projName, tableName, "history", historyKey, toWrite)
+}
+
+// ReadTableHistory returns the histories of all the rows of a table, keyed by row id. Each history
+// is sorted from oldest to newest.
+func ReadTableHistory(projName, tableName string) (map[string][]RowVersion, error) {
+	ret := make(map[string][]RowVersion)
+	historyF1Path := filepath.Join(GetTablePath(projName, tableName), "history.flaa1")
+	if !DoesPathExists(historyF1Path) {
+		return ret, nil
+	}
+
+	elemsMap, err := ParseDataF1File(historyF1Path)
+	if err != nil {
+		return ret, err
+	}
+
+	for key, elem := range elemsMap {
+		parts := strings.Split(key, "/")
+		if len(parts) != 2 {
+			continue
+		}
+		nanos, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		rawRowData, err := ReadPortionF2File(projName, tableName, "history", elem.DataBegin, elem.DataEnd)
+		if err != nil {
+			return ret, err
+		}
+		rowMap, err := ParseEncodedRowData(rawRowData)
+		if err != nil {
+			return ret, err
+		}
+
+		op := rowMap["_history_op"]
+		delete(rowMap, "_history_op")
+		if op != HISTORY_INSERT {
+			rowMap["id"] = parts[0]
+		}
+
+		ret[parts[0]] = append(ret[parts[0]], RowVersion{time.Unix(0, nanos), op, rowMap})
+	}
+
+	for rowId := range ret {
+		slices.SortFunc(ret[rowId], func(a, b RowVersion) int {
+			return a.Time.Compare(b.Time)
+		})
+	}
+
+	return ret, nil
+}
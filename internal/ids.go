@@ -0,0 +1,280 @@
+package internal
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// strategies of the 'id_strategy' table option
+const (
+	ID_SEQUENTIAL = "sequential"
+	ID_UUIDV7     = "uuidv7"
+	ID_ULID       = "ulid"
+	ID_SNOWFLAKE  = "snowflake"
+)
+
+// GetIdStrategy returns the id strategy of a table. Tables without the option are sequential.
+func GetIdStrategy(projName, tableName string) string {
+	strategy := GetTableOption(projName, tableName, "id_strategy")
+	if strategy == "" {
+		return ID_SEQUENTIAL
+	}
+	return strategy
+}
+
+// IdTypeOfStrategy returns the field type of the ids made by an id strategy. It is the type a
+// foreign key pointing to the table must have.
+func IdTypeOfStrategy(strategy string) string {
+	if strategy == ID_UUIDV7 || strategy == ID_ULID {
+		return "string"
+	}
+	return "int"
+}
+
+// GetIdFieldType returns the field type of the 'id' field of a table.
+func GetIdFieldType(projName, tableName string) string {
+	return IdTypeOfStrategy(GetIdStrategy(projName, tableName))
+}
+
+// ValidateId checks that an id supplied by a client has the format of the table's id strategy.
+func ValidateId(projName, tableName, id string) error {
+	strategy := GetIdStrategy(projName, tableName)
+	var valid bool
+	switch strategy {
+	case ID_SEQUENTIAL, ID_SNOWFLAKE:
+		idInt, err := strconv.ParseInt(id, 10, 64)
+		valid = err == nil && idInt > 0
+	case ID_UUIDV7:
+		valid = isUUID(id)
+	case ID_ULID:
+		valid = isULID(id)
+	}
+
+	if !valid {
+		return errors.New(fmt.Sprintf("The id '%s' is not a valid '%s' id", id, strategy))
+	}
+	return nil
+}
+
+// NextId makes the id of a new row of a table. The largest integer id is kept in lastId.txt whatever
+// the strategy, so that a table switched to sequential ids does not reuse them. The caller must hold
+// the table's write lock.
+func NextId(projName, tableName string) (string, error) {
+	var id string
+	var err error
+	switch GetIdStrategy(projName, tableName) {
+	case ID_UUIDV7:
+		return newUUIDv7()
+	case ID_ULID:
+		return newULID()
+	case ID_SNOWFLAKE:
+		id, err = newSnowflake()
+	default:
+		var lastId int64
+		lastId, err = readLastId(projName, tableName)
+		id = strconv.FormatInt(lastId+1, 10)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return id, NoteClientId(projName, tableName, id)
+}
+
+// NoteClientId records an id supplied by a client, so that the sequential ids made later do not
+// collide with it. Ids which are not integers are not recorded. The caller must hold the table's
+// write lock.
+func NoteClientId(projName, tableName, id string) error {
+	idInt, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil
+	}
+
+	lastId, err := readLastId(projName, tableName)
+	if err != nil {
+		return err
+	}
+	if idInt > lastId {
+		err = os.WriteFile(filepath.Join(GetTablePath(projName, tableName), "lastId.txt"), []byte(id), 0777)
+		if err != nil {
+			return errors.Wrap(err, "os error")
+		}
+	}
+	return nil
+}
+
+// GetTableIds returns the ids of the rows of a table and of the rows in its trash.
+func GetTableIds(projName, tableName string) ([]string, error) {
+	ids := make([]string, 0)
+	for _, name := range []string{"data.flaa1", "trash.flaa1"} {
+		f1Path := filepath.Join(GetTablePath(projName, tableName), name)
+		if !DoesPathExists(f1Path) {
+			continue
+		}
+		elemsMap, err := ParseDataF1File(f1Path)
+		if err != nil {
+			return nil, err
+		}
+		for id := range elemsMap {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// RaiseLastId sets lastId.txt to the largest integer id of the rows and trashed rows of a table if
+// it is below it. Tables which used another strategy before the ids were recorded may have such
+// rows. The caller must hold the table's write lock.
+func RaiseLastId(projName, tableName string) error {
+	ids, err := GetTableIds(projName, tableName)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		err = NoteClientId(projName, tableName, id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readLastId(projName, tableName string) (int64, error) {
+	lastIdPath := filepath.Join(GetTablePath(projName, tableName), "lastId.txt")
+	if !DoesPathExists(lastIdPath) {
+		return 0, nil
+	}
+
+	raw, err := os.ReadFile(lastIdPath)
+	if err != nil {
+		return 0, errors.Wrap(err, "os error")
+	}
+	lastId, _ := strconv.ParseInt(strings.TrimSpace(string(raw)), 10, 64)
+	return lastId, nil
+}
+
+// newUUIDv7 makes a UUID of version 7 (RFC 9562): 48 bits of unix milliseconds followed by random bits.
+func newUUIDv7() (string, error) {
+	var b [16]byte
+	_, err := crand.Read(b[6:])
+	if err != nil {
+		return "", errors.Wrap(err, "random error")
+	}
+	ms := uint64(time.Now().UnixMilli())
+	b[0] = byte(ms >> 40)
+	b[1] = byte(ms >> 32)
+	b[2] = byte(ms >> 24)
+	b[3] = byte(ms >> 16)
+	b[4] = byte(ms >> 8)
+	b[5] = byte(ms)
+	b[6] = (b[6] & 0x0f) | 0x70
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	h := hex.EncodeToString(b[:])
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:], nil
+}
+
+func isUUID(id string) bool {
+	if len(id) != 36 {
+		return false
+	}
+	for i, c := range id {
+		if i == 8 || i == 13 || i == 18 || i == 23 {
+			if c != '-' {
+				return false
+			}
+		} else if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+	return true
+}
+
+const crockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// newULID makes a ULID: 48 bits of unix milliseconds followed by 80 random bits, written in
+// Crockford's base32.
+func newULID() (string, error) {
+	var b [16]byte
+	_, err := crand.Read(b[6:])
+	if err != nil {
+		return "", errors.Wrap(err, "random error")
+	}
+	ms := uint64(time.Now().UnixMilli())
+	binary.BigEndian.PutUint16(b[0:2], uint16(ms>>32))
+	binary.BigEndian.PutUint32(b[2:6], uint32(ms))
+
+	// 128 bits make 26 characters of 5 bits, the first of which has only 3 bits.
+	hi := binary.BigEndian.Uint64(b[0:8])
+	lo := binary.BigEndian.Uint64(b[8:16])
+	out := make([]byte, 26)
+	for i := 25; i >= 0; i-- {
+		out[i] = crockfordAlphabet[lo&0x1f]
+		lo = (lo >> 5) | (hi << 59)
+		hi >>= 5
+	}
+	return string(out), nil
+}
+
+func isULID(id string) bool {
+	if len(id) != 26 || id[0] > '7' {
+		return false
+	}
+	for _, c := range id {
+		if !strings.ContainsRune(crockfordAlphabet, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// snowflakeEpoch is the start of the timestamps of snowflake ids (2024-01-01 UTC in milliseconds).
+const snowflakeEpoch = 1704067200000
+
+var (
+	snowflakeMutex    sync.Mutex
+	snowflakeLastMs   int64
+	snowflakeSequence int64
+)
+
+// newSnowflake makes a snowflake id: 41 bits of milliseconds since snowflakeEpoch, the 10 bits of
+// the 'snowflake_node' setting and a 12 bits sequence for the ids made in the same millisecond.
+func newSnowflake() (string, error) {
+	node, _ := strconv.ParseInt(GetSetting("snowflake_node"), 10, 64)
+	if node < 0 || node > 1023 {
+		return "", errors.New(fmt.Sprintf("the snowflake_node setting '%d' is not between 0 and 1023", node))
+	}
+
+	snowflakeMutex.Lock()
+	defer snowflakeMutex.Unlock()
+
+	ms := time.Now().UnixMilli() - snowflakeEpoch
+	if ms < snowflakeLastMs {
+		// the clock went back; keep counting from the last millisecond used
+		ms = snowflakeLastMs
+	}
+	if ms == snowflakeLastMs {
+		snowflakeSequence = (snowflakeSequence + 1) & 0xfff
+		if snowflakeSequence == 0 {
+			for ms <= snowflakeLastMs {
+				time.Sleep(100 * time.Microsecond)
+				ms = time.Now().UnixMilli() - snowflakeEpoch
+			}
+		}
+	} else {
+		snowflakeSequence = 0
+	}
+	snowflakeLastMs = ms
+
+	return strconv.FormatInt(ms<<22|node<<12|snowflakeSequence, 10), nil
+}
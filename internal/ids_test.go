@@ -0,0 +1,132 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/saenuma/zazabul"
+)
+
+// newTestRoot makes a data folder with the default config and the table 'proj/tbl' with the given
+// id strategy.
+func newTestRoot(t *testing.T, strategy string) {
+	t.Helper()
+
+	rootPath := t.TempDir()
+	t.Setenv("SNAP_COMMON", rootPath)
+	conf, err := zazabul.ParseConfig(RootConfigTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = conf.Write(filepath.Join(rootPath, "flaarum.zconf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.MkdirAll(GetTablePath("proj", "tbl"), 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = UpdateTableOptions("proj", "tbl", map[string]string{"id_strategy": strategy})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNextIdOfStrategies(t *testing.T) {
+	for _, strategy := range []string{ID_SEQUENTIAL, ID_UUIDV7, ID_ULID, ID_SNOWFLAKE} {
+		t.Run(strategy, func(t *testing.T) {
+			newTestRoot(t, strategy)
+
+			seen := make(map[string]bool)
+			last := ""
+			for i := 0; i < 1000; i++ {
+				id, err := NextId("proj", "tbl")
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = ValidateId("proj", "tbl", id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if seen[id] {
+					t.Fatalf("the id '%s' was made twice", id)
+				}
+				seen[id] = true
+
+				// the integer ids are made in order
+				if IdTypeOfStrategy(strategy) == "int" {
+					idInt, _ := strconv.ParseInt(id, 10, 64)
+					lastInt, _ := strconv.ParseInt(last, 10, 64)
+					if idInt <= lastInt {
+						t.Fatalf("the id '%s' came after '%s'", id, last)
+					}
+				}
+				last = id
+			}
+		})
+	}
+}
+
+func TestValidateId(t *testing.T) {
+	cases := []struct {
+		strategy string
+		id       string
+		valid    bool
+	}{
+		{ID_SEQUENTIAL, "12", true},
+		{ID_SEQUENTIAL, "0", false},
+		{ID_SEQUENTIAL, "-3", false},
+		{ID_SEQUENTIAL, "abc", false},
+		{ID_SNOWFLAKE, "1234567890123", true},
+		{ID_SNOWFLAKE, "12.5", false},
+		{ID_UUIDV7, "01890a5d-ac96-774b-bcce-b302099a8057", true},
+		{ID_UUIDV7, "01890A5D-AC96-774B-BCCE-B302099A8057", false},
+		{ID_UUIDV7, "01890a5dac96774bbcceb302099a8057", false},
+		{ID_ULID, "01ARZ3NDEKTSV4RRFFQ69G5FAV", true},
+		{ID_ULID, "81ARZ3NDEKTSV4RRFFQ69G5FAV", false},
+		{ID_ULID, "01ARZ3NDEKTSV4RRFFQ69G5FAU", false},
+		{ID_ULID, "12", false},
+	}
+
+	for _, c := range cases {
+		newTestRoot(t, c.strategy)
+		err := ValidateId("proj", "tbl", c.id)
+		if (err == nil) != c.valid {
+			t.Errorf("ValidateId of '%s' with '%s' ids: %v", c.id, c.strategy, err)
+		}
+	}
+}
+
+func TestLastIdFollowsEveryStrategy(t *testing.T) {
+	newTestRoot(t, ID_SNOWFLAKE)
+
+	id, err := NextId("proj", "tbl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lastId, err := readLastId("proj", "tbl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strconv.FormatInt(lastId, 10) != id {
+		t.Errorf("lastId.txt has %d after the snowflake id '%s'", lastId, id)
+	}
+
+	err = NoteClientId("proj", "tbl", "999999999999999999")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = UpdateTableOptions("proj", "tbl", map[string]string{"id_strategy": ID_SEQUENTIAL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err = NextId("proj", "tbl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "1000000000000000000" {
+		t.Errorf("the first sequential id is '%s', expected the one after the client's id", id)
+	}
+}
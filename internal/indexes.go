@@ -153,8 +153,7 @@ func DeleteIndex(projName, tableName, fieldName, data, rowId, version string) er
 	readBytes, err := ReadPortionF2File(projName, tableName, fieldName+"_indexes",
 		elem.DataBegin, elem.DataEnd)
 	if err != nil {
-		fmt.Println("Bad indexes file")
-		fmt.Printf("%+v\n", err)
+		LogError("bad indexes file", err)
 	}
 	similarIds := strings.Split(string(readBytes), ",")
 	toWriteIds := make([]string, 0)
@@ -0,0 +1,143 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/pkg/errors"
+)
+
+// roles a key can have. Each role includes the operations of the roles before it.
+const (
+	ROLE_READ  = "read"
+	ROLE_WRITE = "write"
+	ROLE_ADMIN = "admin"
+)
+
+var rolesOrder = []string{ROLE_READ, ROLE_WRITE, ROLE_ADMIN}
+
+// APIKey is a named key created with 'flaarum.prod ck'. Only the hash of the key string is stored.
+type APIKey struct {
+	Name     string   `json:"name"`
+	Hash     string   `json:"hash"`
+	Role     string   `json:"role"`
+	Projects []string `json:"projects"` // a project of "*" means all projects
+	Created  string   `json:"created"`
+}
+
+func IsValidRole(role string) bool {
+	return slices.Contains(rolesOrder, role)
+}
+
+// RoleIncludes reports whether a key of role can perform operations needing neededRole.
+func RoleIncludes(role, neededRole string) bool {
+	roleIndex := slices.Index(rolesOrder, role)
+	neededIndex := slices.Index(rolesOrder, neededRole)
+	if roleIndex == -1 || neededIndex == -1 {
+		return false
+	}
+	return roleIndex >= neededIndex
+}
+
+// Allows reports whether the key can perform an operation needing neededRole on the project projName.
+// An empty projName is used for operations which are not tied to a project; they can see every
+// project, so only the keys of all projects are allowed them.
+func (key APIKey) Allows(projName, neededRole string) bool {
+	if !RoleIncludes(key.Role, neededRole) {
+		return false
+	}
+
+	if slices.Contains(key.Projects, "*") {
+		return true
+	}
+
+	return projName != "" && slices.Contains(key.Projects, projName)
+}
+
+func HashKeyStr(keyStr string) string {
+	h := sha256.Sum256([]byte(keyStr))
+	return fmt.Sprintf("%x", h)
+}
+
+func GetKeysPath() string {
+	rootPath, err := GetRootPath()
+	if err != nil {
+		panic(err)
+	}
+	return filepath.Join(rootPath, "flaarum.keys")
+}
+
+func LoadAPIKeys() ([]APIKey, error) {
+	keys := make([]APIKey, 0)
+	keysPath := GetKeysPath()
+	if !DoesPathExists(keysPath) {
+		return keys, nil
+	}
+
+	raw, err := os.ReadFile(keysPath)
+	if err != nil {
+		return keys, errors.Wrap(err, "os error")
+	}
+
+	err = json.Unmarshal(raw, &keys)
+	if err != nil {
+		return keys, errors.Wrap(err, "json error")
+	}
+
+	return keys, nil
+}
+
+func SaveAPIKeys(keys []APIKey) error {
+	jsonBytes, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "json error")
+	}
+
+	err = os.WriteFile(GetKeysPath(), jsonBytes, 0600)
+	if err != nil {
+		return errors.Wrap(err, "os error")
+	}
+
+	return nil
+}
+
+// FindAPIKey returns the key whose hash matches that of keyStr.
+func FindAPIKey(keyStr string) (APIKey, bool) {
+	keys, err := LoadAPIKeys()
+	if err != nil {
+		LogError("keys error", err)
+		return APIKey{}, false
+	}
+
+	hash := HashKeyStr(keyStr)
+	for _, key := range keys {
+		if subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hash)) == 1 {
+			return key, true
+		}
+	}
+
+	return APIKey{}, false
+}
+
+// FindAPIKeyByName returns the key with the given name. It is used to map the common name of
+// a client certificate to a key.
+func FindAPIKeyByName(name string) (APIKey, bool) {
+	keys, err := LoadAPIKeys()
+	if err != nil {
+		LogError("keys error", err)
+		return APIKey{}, false
+	}
+
+	for _, key := range keys {
+		if key.Name == name {
+			return key, true
+		}
+	}
+
+	return APIKey{}, false
+}
@@ -4,6 +4,7 @@ package internal
 
 import (
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -34,8 +35,63 @@ in_production: false
 // changing the port can be used to hide your database during production
 port: 22318
 
+// client_auth can be set to either false or true.
+// when set to true, clients on other machines must present a certificate signed by the
+// certificate authority created with 'flaarum.prod genca'
+client_auth: false
+
+// mode can be set to normal, read_only or maintenance.
+// in read_only mode writes are refused and in maintenance mode every request is refused with
+// the status 503. It can also be switched with 'flaarum.prod mode' while the store runs.
+mode: normal
+
+// replica_of makes this store a read-only replica of the store at the given address eg. '10.0.0.2:22318'.
+// Leave it empty for a primary. 'flaarum.prod promote' empties it to promote a replica.
+replica_of:
+
+// replica_key_str is the key a replica uses on its primary. It needs the read role on all projects.
+replica_key_str:
+
+// compaction_interval is the number of minutes between the checks of the background compactor,
+// which trims the tables whose data and indexes files are mostly dead bytes. 0 turns it off.
+compaction_interval: 60
+
+// compaction_threshold is the ratio of dead bytes to file size above which a table is compacted.
+compaction_threshold: 0.5
+
+// change_log_retention is the number of days the changes to the rows of the tables are kept for
+// '/changes', replicas and incremental backups. Older changes are removed by the background
+// compactor; replicas and backups which need them copy whole tables instead. 0 keeps every change.
+change_log_retention: 7
+
+// log_level is one of debug, info, warn and error. Every request is logged at the info level.
+log_level: info
+
+// log_file is where the store writes its logs as JSON lines. Leave it empty to log to the
+// standard output. A relative path is taken from the data folder.
+log_file:
+
+// slow_query_ms is the number of milliseconds above which a statement is logged as a slow query
+// together with the rows it read. 0 turns the slow query log off.
+slow_query_ms: 500
+
+// snowflake_node is the number between 0 and 1023 put in the ids of the tables with the 'snowflake'
+// id strategy. Stores writing to tables which are later merged must have different numbers.
+snowflake_node: 0
+
 `
 
+// NameValidate checks the name of a project, a table or anything else which becomes part of a path.
+func NameValidate(name string) error {
+	if strings.Contains(name, ".") || strings.Contains(name, " ") || strings.Contains(name, "\t") ||
+		strings.Contains(name, "\n") || strings.Contains(name, ":") || strings.Contains(name, "/") ||
+		strings.Contains(name, "~") {
+		return errors.New("object name must not contain space, '.', ':', '/', ~ ")
+	}
+
+	return nil
+}
+
 func DoesPathExists(p string) bool {
 	if _, err := os.Stat(p); os.IsNotExist(err) {
 		return false
@@ -84,13 +140,13 @@ func GetRootPath() (string, error) {
 func GetSetting(settingName string) string {
 	confPath, err := GetConfigPath()
 	if err != nil {
-		fmt.Printf("%+v\n", err)
+		LogError("config error", err)
 		return ""
 	}
 
 	conf, err := zazabul.LoadConfigFile(confPath)
 	if err != nil {
-		fmt.Printf("%+v\n", err)
+		LogError("config error", err)
 	}
 
 	return conf.Get(settingName)
@@ -140,6 +196,9 @@ func FindIn(container []string, elem string) int {
 }
 
 func DoesTableExists(projName, tableName string) bool {
+	if IsRebuildTableName(tableName) {
+		return false
+	}
 	dataPath, _ := GetRootPath()
 	if _, err := os.Stat(filepath.Join(dataPath, projName, tableName)); os.IsNotExist(err) {
 		return false
@@ -192,9 +251,12 @@ func GetFieldType(projName, tableName, fieldName string) string {
 
 	fieldNamesToFieldTypes := make(map[string]string)
 
-	if fieldName == "_version" || fieldName == "id" {
+	if fieldName == "_version" {
 		return "int"
 	}
+	if fieldName == "id" {
+		return GetIdFieldType(projName, tableName)
+	}
 
 	for _, fieldStruct := range tableStruct.Fields {
 		fieldNamesToFieldTypes[fieldStruct.FieldName] = fieldStruct.FieldType
@@ -252,7 +314,7 @@ func GetFieldType(projName, tableName, fieldName string) string {
 }
 
 func PrintError(w http.ResponseWriter, err error) {
-	fmt.Printf("%+v\n", err)
+	LogResponseError(w, slog.LevelError, "internal error", err)
 	debug := GetSetting("debug")
 	if debug == "true" {
 		http.Error(w, fmt.Sprintf("%+v", err), http.StatusInternalServerError)
@@ -297,7 +359,7 @@ func ListTables(projName string) ([]string, error) {
 
 	tables := make([]string, 0)
 	for _, tfi := range tablesFIs {
-		if tfi.IsDir() {
+		if tfi.IsDir() && !IsRebuildTableName(tfi.Name()) {
 			tables = append(tables, tfi.Name())
 		}
 	}
@@ -305,6 +367,51 @@ func ListTables(projName string) ([]string, error) {
 	return tables, nil
 }
 
+// rebuildSuffixes end the names of the folders written by trims and reindexes next to the tables.
+var rebuildSuffixes = []string{"_rebuild_src", "_rebuild_tmp", "_trim_tmp", "_ridx_tmp"}
+
+// IsRebuildTableName tells the folders of trims and reindexes in progress from tables. They are
+// left out of ListTables.
+func IsRebuildTableName(tableName string) bool {
+	for _, suffix := range rebuildSuffixes {
+		if strings.HasSuffix(tableName, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveRebuildFolders removes the folders of trims and reindexes left by a store which stopped
+// during them. It must be called while no rebuild runs.
+func RemoveRebuildFolders() error {
+	dataPath, err := GetRootPath()
+	if err != nil {
+		return err
+	}
+	projFIs, err := os.ReadDir(dataPath)
+	if err != nil {
+		return errors.Wrap(err, "read directory failed.")
+	}
+	for _, projFI := range projFIs {
+		if !projFI.IsDir() {
+			continue
+		}
+		tablesFIs, err := os.ReadDir(filepath.Join(dataPath, projFI.Name()))
+		if err != nil {
+			return errors.Wrap(err, "read directory failed.")
+		}
+		for _, tfi := range tablesFIs {
+			if tfi.IsDir() && IsRebuildTableName(tfi.Name()) {
+				err = os.RemoveAll(filepath.Join(dataPath, projFI.Name(), tfi.Name()))
+				if err != nil {
+					return errors.Wrap(err, "os error")
+				}
+			}
+		}
+	}
+	return nil
+}
+
 func ConfirmFieldType(projName, tableName, fieldName, fieldType, version string) bool {
 	versionInt, _ := strconv.Atoi(version)
 	tableStruct, err := GetTableStructureParsed(projName, tableName, versionInt)
@@ -312,8 +419,8 @@ func ConfirmFieldType(projName, tableName, fieldName, fieldType, version string)
 		return false
 	}
 
-	if fieldName == "id" && fieldType == "int" {
-		return true
+	if fieldName == "id" {
+		return fieldType == GetIdFieldType(projName, tableName)
 	}
 
 	for _, fd := range tableStruct.Fields {
@@ -324,44 +431,16 @@ func ConfirmFieldType(projName, tableName, fieldName, fieldType, version string)
 	return false
 }
 
-func GetLocalFlaarumClient(project string) flaarumlib.Client {
-	var keyStr string
-	inProd := GetSetting("in_production")
-	if inProd == "" {
-		color.Red.Println("unexpected error. Have you installed  and launched flaarum?")
-		os.Exit(1)
-	}
-	if inProd == "true" {
-		keyStrPath := GetKeyStrPath()
-		raw, err := os.ReadFile(keyStrPath)
-		if err != nil {
-			color.Red.Println(err)
-			os.Exit(1)
-		}
-		keyStr = string(raw)
-	} else {
-		keyStr = "not-yet-set"
-	}
-	port := GetSetting("port")
-	if port == "" {
+// GetLocalFlaarumClient returns a client of the store on this machine and exits if the store does
+// not respond.
+func GetLocalFlaarumClient(project string) *LocalClient {
+	if GetSetting("port") == "" {
 		color.Red.Println("unexpected error. Have you installed  and launched flaarum?")
 		os.Exit(1)
 	}
-	var cl flaarumlib.Client
-
-	portInt, err := strconv.Atoi(port)
-	if err != nil {
-		color.Red.Println("Invalid port setting.")
-		os.Exit(1)
-	}
-
-	if portInt != PORT {
-		cl = flaarumlib.NewClientCustomPort("127.0.0.1", keyStr, project, portInt)
-	} else {
-		cl = flaarumlib.NewClient("127.0.0.1", keyStr, project)
-	}
 
-	err = cl.Ping()
+	cl := &LocalClient{ProjName: project}
+	err := cl.Ping()
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
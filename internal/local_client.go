@@ -0,0 +1,277 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/saenuma/flaarumlib"
+)
+
+// LocalClient is the client 'flaarum.cli' and 'flaarum.prod' use for the store on this machine. It
+// has the methods of the flaarumlib client they use, but sends its requests with LocalRequest so
+// that the local client certificate is presented when client_auth is on.
+type LocalClient struct {
+	ProjName string
+}
+
+func (cl *LocalClient) Ping() error {
+	body, err := LocalRequest("is-flaarum", nil)
+	if err != nil {
+		return err
+	}
+	if string(body) != "yeah-flaarum" {
+		return errors.New("Unexpected Error in confirming that the server is a flaarum store.")
+	}
+	return nil
+}
+
+func (cl *LocalClient) ListProjects() ([]string, error) {
+	body, err := LocalRequest("list-projects", nil)
+	if err != nil {
+		return nil, err
+	}
+	projs := make([]string, 0)
+	err = json.Unmarshal(body, &projs)
+	if err != nil {
+		return nil, errors.Wrap(err, "json error")
+	}
+	return projs, nil
+}
+
+func (cl *LocalClient) CreateProject(projName string) error {
+	_, err := LocalRequest("create-project/"+projName, nil)
+	return err
+}
+
+func (cl *LocalClient) RenameProject(projName, newProjName string) error {
+	_, err := LocalRequest(fmt.Sprintf("rename-project/%s/%s", projName, newProjName), nil)
+	return err
+}
+
+func (cl *LocalClient) DeleteProject(projName string) error {
+	_, err := LocalRequest("delete-project/"+projName, nil)
+	return err
+}
+
+func (cl *LocalClient) ListTables() ([]string, error) {
+	body, err := LocalRequest("list-tables/"+cl.ProjName, nil)
+	if err != nil {
+		return nil, err
+	}
+	tables := make([]string, 0)
+	err = json.Unmarshal(body, &tables)
+	if err != nil {
+		return nil, errors.Wrap(err, "json error")
+	}
+	return tables, nil
+}
+
+func (cl *LocalClient) CreateTable(stmt string) error {
+	_, err := LocalRequest("create-table/"+cl.ProjName, url.Values{"stmt": {stmt}})
+	return err
+}
+
+func (cl *LocalClient) UpdateTableStructure(stmt string) error {
+	_, err := LocalRequest("update-table-structure/"+cl.ProjName, url.Values{"stmt": {stmt}})
+	return err
+}
+
+func (cl *LocalClient) DeleteTable(tableName string) error {
+	_, err := LocalRequest(fmt.Sprintf("delete-table/%s/%s", cl.ProjName, tableName), nil)
+	return err
+}
+
+func (cl *LocalClient) GetCurrentTableVersionNum(tableName string) (int64, error) {
+	body, err := LocalRequest(fmt.Sprintf("get-current-version-num/%s/%s", cl.ProjName, tableName), nil)
+	if err != nil {
+		return 0, err
+	}
+	vnum, err := strconv.ParseInt(string(body), 10, 64)
+	if err != nil {
+		return 0, errors.Wrap(err, "strconv error")
+	}
+	return vnum, nil
+}
+
+func (cl *LocalClient) GetTableStructure(tableName string, versionNum int64) (string, error) {
+	body, err := LocalRequest(fmt.Sprintf("get-table-structure/%s/%s/%d", cl.ProjName, tableName, versionNum), nil)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+func (cl *LocalClient) GetTableStructureParsed(tableName string, versionNum int64) (flaarumlib.TableStruct, error) {
+	stmt, err := cl.GetTableStructure(tableName, versionNum)
+	if err != nil {
+		return flaarumlib.TableStruct{}, err
+	}
+	return flaarumlib.ParseTableStructureStmt(stmt)
+}
+
+func (cl *LocalClient) AllRowsCount(tableName string) (int64, error) {
+	body, err := LocalRequest(fmt.Sprintf("all-rows-count/%s/%s", cl.ProjName, tableName), nil)
+	if err != nil {
+		return 0, err
+	}
+	count, err := strconv.ParseInt(string(body), 10, 64)
+	if err != nil {
+		return 0, errors.Wrap(err, "strconv error")
+	}
+	return count, nil
+}
+
+func (cl *LocalClient) CountRows(stmt string) (int64, error) {
+	body, err := LocalRequest("count-rows/"+cl.ProjName, url.Values{"stmt": {stmt}})
+	if err != nil {
+		return 0, err
+	}
+	count, err := strconv.ParseInt(string(body), 10, 64)
+	if err != nil {
+		return 0, errors.Wrap(err, "strconv error")
+	}
+	return count, nil
+}
+
+// InsertRowStr inserts a row and returns its id.
+func (cl *LocalClient) InsertRowStr(tableName string, toInsert map[string]string) (string, error) {
+	values := url.Values{}
+	for k, v := range toInsert {
+		values.Set(k, v)
+	}
+	body, err := LocalRequest(fmt.Sprintf("insert-row/%s/%s", cl.ProjName, tableName), values)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+func (cl *LocalClient) UpdateRowsStr(stmt string, updateData map[string]string) error {
+	values := url.Values{"stmt": {stmt}}
+	i := 1
+	for k, v := range updateData {
+		values.Set(fmt.Sprintf("set%d_k", i), k)
+		values.Set(fmt.Sprintf("set%d_v", i), v)
+		i += 1
+	}
+	_, err := LocalRequest("update-rows/"+cl.ProjName, values)
+	return err
+}
+
+func (cl *LocalClient) DeleteRows(stmt string) error {
+	_, err := LocalRequest("delete-rows/"+cl.ProjName, url.Values{"stmt": {stmt}})
+	return err
+}
+
+// Search runs a search statement. The values of the int and float fields are returned as int64 and
+// float64 and the others as strings.
+func (cl *LocalClient) Search(stmt string) (*[]map[string]any, error) {
+	stmtStruct, err := flaarumlib.ParseSearchStmt(stmt)
+	if err != nil {
+		return nil, err
+	}
+	body, err := LocalRequest("search-table/"+cl.ProjName, url.Values{"stmt": {stmt}})
+	if err != nil {
+		return nil, err
+	}
+	rows := make([]map[string]string, 0)
+	err = json.Unmarshal(body, &rows)
+	if err != nil {
+		return nil, errors.Wrap(err, "json error")
+	}
+
+	ret := make([]map[string]any, 0, len(rows))
+	for _, row := range rows {
+		ret = append(ret, cl.typeRow(stmtStruct.TableName, row))
+	}
+	return &ret, nil
+}
+
+func (cl *LocalClient) SearchForOne(stmt string) (*map[string]any, error) {
+	stmtStruct, err := flaarumlib.ParseSearchStmt(stmt)
+	if err != nil {
+		return nil, err
+	}
+	body, err := LocalRequest("search-table/"+cl.ProjName, url.Values{"stmt": {stmt}, "query-one": {"t"}})
+	if err != nil {
+		return nil, err
+	}
+	row := make(map[string]string)
+	err = json.Unmarshal(body, &row)
+	if err != nil {
+		return nil, errors.Wrap(err, "json error")
+	}
+
+	ret := cl.typeRow(stmtStruct.TableName, row)
+	return &ret, nil
+}
+
+func (cl *LocalClient) typeRow(tableName string, row map[string]string) map[string]any {
+	ret := make(map[string]any)
+	for k, v := range row {
+		switch GetFieldType(cl.ProjName, tableName, k) {
+		case "int":
+			if vInt, err := strconv.ParseInt(v, 10, 64); err == nil {
+				ret[k] = vInt
+				continue
+			}
+		case "float":
+			if vFloat, err := strconv.ParseFloat(v, 64); err == nil {
+				ret[k] = vFloat
+				continue
+			}
+		}
+		ret[k] = v
+	}
+	return ret
+}
+
+// ConvertInterfaceMapToStringMap converts a row read from JSON, like the rows of 'flaarum.prod etj',
+// to the strings stored by the store.
+func (cl *LocalClient) ConvertInterfaceMapToStringMap(tableName string, oldMap map[string]any) (map[string]string, error) {
+	newMap := make(map[string]string)
+	for k, v := range oldMap {
+		fieldType := GetFieldType(cl.ProjName, tableName, k)
+		switch vInType := v.(type) {
+		case nil:
+			continue
+		case string:
+			// dates written by older exports are in RFC3339
+			if fieldType == "date" || fieldType == "datetime" {
+				if t, err := time.Parse(time.RFC3339, vInType); err == nil {
+					newMap[k] = formatTimeOfField(fieldType, t)
+					continue
+				}
+			}
+			newMap[k] = vInType
+		case float64:
+			if fieldType == "int" {
+				newMap[k] = strconv.FormatInt(int64(vInType), 10)
+			} else {
+				newMap[k] = strconv.FormatFloat(vInType, 'f', -1, 64)
+			}
+		case int64:
+			newMap[k] = strconv.FormatInt(vInType, 10)
+		case int:
+			newMap[k] = strconv.Itoa(vInType)
+		case bool:
+			newMap[k] = strconv.FormatBool(vInType)
+		case time.Time:
+			newMap[k] = formatTimeOfField(fieldType, vInType)
+		default:
+			return nil, errors.New(fmt.Sprintf("the value of field '%s' has the unsupported type '%T'", k, v))
+		}
+	}
+	return newMap, nil
+}
+
+func formatTimeOfField(fieldType string, t time.Time) string {
+	if fieldType == "date" {
+		return t.Format(flaarumlib.DATE_FORMAT)
+	}
+	return t.Format(flaarumlib.DATETIME_FORMAT)
+}
@@ -0,0 +1,65 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// REQUEST_ID_HEADER carries the id the store gives every request. It is echoed in the responses
+// and logged with every line written while serving the request.
+const REQUEST_ID_HEADER = "X-Request-Id"
+
+// Logger writes JSON lines to the destination set by SetupLogger.
+var Logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+// SetupLogger makes Logger use the 'log_level' and 'log_file' settings. The level is one of
+// 'debug', 'info', 'warn' and 'error'. An empty 'log_file' logs to the standard output and a
+// relative path is taken from the data root.
+func SetupLogger() error {
+	var level slog.Level
+	levelStr := GetSetting("log_level")
+	if levelStr != "" {
+		err := level.UnmarshalText([]byte(levelStr))
+		if err != nil {
+			return errors.New(fmt.Sprintf("'%s' is not a valid log_level", levelStr))
+		}
+	}
+
+	out := os.Stdout
+	logFile := GetSetting("log_file")
+	if logFile != "" {
+		if !filepath.IsAbs(logFile) {
+			rootPath, err := GetRootPath()
+			if err != nil {
+				return err
+			}
+			logFile = filepath.Join(rootPath, logFile)
+		}
+
+		var err error
+		out, err = os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return errors.Wrap(err, "os error")
+		}
+	}
+
+	Logger = slog.New(slog.NewJSONHandler(out, &slog.HandlerOptions{Level: level}))
+	return nil
+}
+
+// LogError logs an error with its stack trace.
+func LogError(msg string, err error, args ...any) {
+	Logger.Error(msg, append([]any{"error", fmt.Sprintf("%+v", err)}, args...)...)
+}
+
+// LogResponseError logs an error sent to a client together with the id of the request.
+func LogResponseError(w http.ResponseWriter, level slog.Level, msg string, err error) {
+	Logger.Log(context.Background(), level, msg, "error", fmt.Sprintf("%+v", err),
+		"request_id", w.Header().Get(REQUEST_ID_HEADER))
+}
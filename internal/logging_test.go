@@ -0,0 +1,128 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// captureLogs makes Logger write to the returned buffer until the end of the test.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	oldLogger := Logger
+	Logger = slog.New(slog.NewJSONHandler(&buf, nil))
+	t.Cleanup(func() {
+		Logger = oldLogger
+	})
+	return &buf
+}
+
+// expectLogged fails the test if no error line with the message msg was logged.
+func expectLogged(t *testing.T, buf *bytes.Buffer, msg string) {
+	t.Helper()
+
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]any
+		if json.Unmarshal([]byte(line), &entry) != nil {
+			continue
+		}
+		if entry["level"] == "ERROR" && entry["msg"] == msg && entry["error"] != "" {
+			return
+		}
+	}
+	t.Errorf("no '%s' was logged; the logs are:\n%s", msg, buf.String())
+}
+
+func TestSettingErrorsAreLogged(t *testing.T) {
+	t.Setenv("SNAP_COMMON", t.TempDir())
+	buf := captureLogs(t)
+
+	if port := GetSetting("port"); port != "" {
+		t.Errorf("a missing config has the port '%s'", port)
+	}
+	expectLogged(t, buf, "config error")
+}
+
+func TestTableOptionErrorsAreLogged(t *testing.T) {
+	newTestRoot(t, ID_SEQUENTIAL)
+	buf := captureLogs(t)
+
+	// an options file which cannot be read
+	optionsPath := getTableOptionsPath("proj", "tbl")
+	os.Remove(optionsPath)
+	err := os.Mkdir(optionsPath, 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strategy := GetTableOption("proj", "tbl", "id_strategy"); strategy != "" {
+		t.Errorf("an unreadable options file has the id strategy '%s'", strategy)
+	}
+	expectLogged(t, buf, "table options error")
+}
+
+func TestKeyErrorsAreLogged(t *testing.T) {
+	newTestRoot(t, ID_SEQUENTIAL)
+	buf := captureLogs(t)
+
+	err := os.WriteFile(GetKeysPath(), []byte("not json"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := FindAPIKey("any"); ok {
+		t.Error("a key was found in a broken keys file")
+	}
+	expectLogged(t, buf, "keys error")
+}
+
+func TestTrimErrorsAreLogged(t *testing.T) {
+	newTestRoot(t, ID_SEQUENTIAL)
+	buf := captureLogs(t)
+
+	// a row whose bytes are past the end of the data file
+	tablePath := GetTablePath("proj", "tbl")
+	err := os.WriteFile(filepath.Join(tablePath, "data.flaa1"), []byte("data_key: 1\ndata_begin: 0\ndata_end: 40\n\n"), 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(tablePath, "data.flaa2"), []byte("title: short\n"), 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	TrimTableFiles("proj", "tbl", "tbl_trim_tmp")
+	expectLogged(t, buf, "trim error")
+}
+
+func TestReindexErrorsAreLogged(t *testing.T) {
+	newTestRoot(t, ID_SEQUENTIAL)
+	buf := captureLogs(t)
+
+	// the index file of a field named like a path cannot be created
+	row := "id: 1\nmissing/title: a\n"
+	tablePath := GetTablePath("proj", "tbl")
+	err := os.WriteFile(filepath.Join(tablePath, "data.flaa1"),
+		[]byte("data_key: 1\ndata_begin: 0\ndata_end: "+strconv.Itoa(len(row))+"\n\n"), 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(tablePath, "data.flaa2"), []byte(row), 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(tablePath, "structure1.txt"), []byte("table: tbl\nfields:\n  title string\n::\n"), 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ReindexTableFiles("proj", "tbl", "tbl_ridx_tmp")
+	expectLogged(t, buf, "reindex error")
+}
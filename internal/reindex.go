@@ -0,0 +1,226 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ReindexTable rebuilds the indexes of a table from its data in a temporary table folder and swaps
+// it in place of the table. The table must not be used while this runs.
+func ReindexTable(projName, tableName string) error {
+	tmpTableName := tableName + "_ridx_tmp"
+	err := ReindexTableFiles(projName, tableName, tmpTableName)
+	if err != nil {
+		return err
+	}
+
+	return SwapTableFolder(projName, tableName, tmpTableName)
+}
+
+// ReindexTableFiles writes the data of a table and indexes rebuilt from it to the table folder
+// tmpTableName.
+func ReindexTableFiles(projName, tableName, tmpTableName string) error {
+	dataPath, _ := GetRootPath()
+	tablePath := filepath.Join(dataPath, projName, tableName)
+	workingTablePath := filepath.Join(dataPath, projName, tmpTableName)
+
+	if DoesPathExists(workingTablePath) {
+		os.RemoveAll(workingTablePath)
+	}
+
+	os.MkdirAll(workingTablePath, 0777)
+
+	// copy the data to the temporary table
+	rawDataFlaa1, err := os.ReadFile(filepath.Join(tablePath, "data.flaa1"))
+	if err != nil {
+		return errors.Wrap(err, "file read error")
+	}
+	workingF1Path := filepath.Join(workingTablePath, "data.flaa1")
+	os.WriteFile(workingF1Path, rawDataFlaa1, 0777)
+
+	rawDataFlaa2, err := os.ReadFile(filepath.Join(tablePath, "data.flaa2"))
+	if err != nil {
+		return errors.Wrap(err, "file read error")
+	}
+	workingF2Path := filepath.Join(workingTablePath, "data.flaa2")
+	os.WriteFile(workingF2Path, rawDataFlaa2, 0777)
+
+	raw, _ := os.ReadFile(filepath.Join(tablePath, "lastId.txt"))
+	os.WriteFile(filepath.Join(workingTablePath, "lastId.txt"), raw, 0777)
+
+	// copy the structures, options and history to the new table folder
+	dirFIs, err := os.ReadDir(tablePath)
+	if err != nil {
+		return errors.Wrap(err, "directory read error")
+	}
+	for _, dirFI := range dirFIs {
+		if IsCopiedTableFile(dirFI.Name()) {
+			oldStructPath := filepath.Join(tablePath, dirFI.Name())
+			raw, _ := os.ReadFile(oldStructPath)
+			newStructPath := filepath.Join(workingTablePath, dirFI.Name())
+			os.WriteFile(newStructPath, raw, 0777)
+		}
+	}
+
+	elemsMap, _ := ParseDataF1File(workingF1Path)
+
+	// get all the fields in the data
+	fields := make([]string, 0)
+	for _, elem := range elemsMap {
+
+		rawRowData, err := ReadPortionF2File(projName, tmpTableName, "data",
+			elem.DataBegin, elem.DataEnd)
+		if err != nil {
+			return err
+		}
+
+		rowMap, err := ParseEncodedRowData(rawRowData)
+		if err != nil {
+			LogError("reindex error", err, "project", projName, "table", tableName)
+			continue
+		}
+
+		for k := range rowMap {
+			if !slices.Contains(fields, k) {
+				fields = append(fields, k)
+			}
+		}
+
+	}
+
+	toIndex := make(map[string]map[string][]string)
+	var wg sync.WaitGroup
+	for _, field := range fields {
+		if field == "id" {
+			continue
+		}
+
+		// each goroutine fills its own map; toIndex is not touched while they run
+		fieldIndex := make(map[string][]string)
+		toIndex[field] = fieldIndex
+
+		wg.Add(1)
+		go func(field string) {
+			defer wg.Done()
+
+			for _, elem := range elemsMap {
+				rawRowData, err := ReadPortionF2File(projName, tmpTableName, "data",
+					elem.DataBegin, elem.DataEnd)
+				if err != nil {
+					LogError("reindex error", err, "project", projName, "table", tableName)
+					continue
+				}
+
+				rowMap, err := ParseEncodedRowData(rawRowData)
+				if err != nil {
+					LogError("reindex error", err, "project", projName, "table", tableName)
+					continue
+				}
+
+				// if !IsNotIndexedField(projName, tmpTableName, field) {
+				// 	err := MakeIndex(projName, tmpTableName, field, rowMap[field], elem.DataKey)
+				// 	if err != nil {
+				// 		fmt.Println(err)
+				// 	}
+				// }
+
+				if !IsNotIndexedField(projName, tmpTableName, field) {
+					idsSlice, ok := fieldIndex[rowMap[field]]
+					if !ok {
+						fieldIndex[rowMap[field]] = []string{elem.DataKey}
+					} else {
+						idsSlice = append(idsSlice, elem.DataKey)
+						fieldIndex[rowMap[field]] = idsSlice
+					}
+				}
+			}
+
+		}(field)
+	}
+
+	wg.Wait()
+
+	for field, indexesMap := range toIndex {
+		// indexesF1Path := filepath.Join(dataPath, projName, tableName, field+"_indexes.flaa1")
+		tmpIndexesF2Path := filepath.Join(dataPath, projName, tmpTableName, field+"_indexes.flaa2")
+
+		for fieldValue, rowsSlice := range indexesMap {
+
+			tmpIndexesHandle, err := os.OpenFile(tmpIndexesF2Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0777)
+			if err != nil {
+				LogError("reindex error", err, "project", projName, "table", tableName)
+				continue
+			}
+			defer tmpIndexesHandle.Close()
+
+			stat, err := tmpIndexesHandle.Stat()
+			if err != nil {
+				LogError("reindex error", err, "project", projName, "table", tableName)
+				continue
+			}
+
+			size := stat.Size()
+			newDataToWrite := strings.Join(rowsSlice, ",") + ","
+			tmpIndexesHandle.Write([]byte(newDataToWrite))
+			begin := size
+			end := int64(len([]byte(newDataToWrite))) + size
+
+			elem := DataF1Elem{DataKey: fieldValue, DataBegin: begin, DataEnd: end}
+			err = AppendDataF1File(projName, tmpTableName, field+"_indexes", elem)
+			if err != nil {
+				LogError("reindex error", err, "project", projName, "table", tableName)
+				continue
+			}
+		}
+
+	}
+
+	return nil
+}
+
+// CopyTableFolder copies every file of a table to the table folder dstTableName.
+func CopyTableFolder(projName, tableName, dstTableName string) error {
+	tablePath := GetTablePath(projName, tableName)
+	dstPath := GetTablePath(projName, dstTableName)
+	os.RemoveAll(dstPath)
+	err := os.MkdirAll(dstPath, 0777)
+	if err != nil {
+		return errors.Wrap(err, "os error")
+	}
+
+	dirFIs, err := os.ReadDir(tablePath)
+	if err != nil {
+		return errors.Wrap(err, "directory read error")
+	}
+	for _, dirFI := range dirFIs {
+		if dirFI.IsDir() {
+			continue
+		}
+		raw, err := os.ReadFile(filepath.Join(tablePath, dirFI.Name()))
+		if err != nil {
+			return errors.Wrap(err, "file read error")
+		}
+		err = os.WriteFile(filepath.Join(dstPath, dirFI.Name()), raw, 0777)
+		if err != nil {
+			return errors.Wrap(err, "os error")
+		}
+	}
+
+	return nil
+}
+
+// SwapTableFolder deletes a table's folder and renames the table folder tmpTableName to it.
+func SwapTableFolder(projName, tableName, tmpTableName string) error {
+	tablePath := GetTablePath(projName, tableName)
+	os.RemoveAll(tablePath)
+	err := os.Rename(GetTablePath(projName, tmpTableName), tablePath)
+	if err != nil {
+		return errors.Wrap(err, "rename failed.")
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ReplicationTableState is what a replica compares with its own copy of a table to decide whether
+// to follow the table's change log or to copy the whole table from the primary.
+type ReplicationTableState struct {
+	Seq        int64             `json:"seq"`
+	TrimmedSeq int64             `json:"trimmed_seq"`
+	Version    int               `json:"version"`
+	Options    map[string]string `json:"options"`
+}
+
+// ReplicationState maps projects to their tables to the states of the tables.
+type ReplicationState map[string]map[string]ReplicationTableState
+
+func GetReplicationTableState(projName, tableName string) (ReplicationTableState, error) {
+	state := ReplicationTableState{Seq: GetLastSeq(projName, tableName), TrimmedSeq: GetTrimmedSeq(projName, tableName),
+		Options: make(map[string]string)}
+
+	version, err := GetCurrentVersionNum(projName, tableName)
+	if err != nil {
+		return state, err
+	}
+	state.Version = version
+
+	conf, err := GetTableOptions(projName, tableName)
+	if err != nil {
+		return state, err
+	}
+	for _, item := range conf.Items {
+		state.Options[item.Name] = item.Value
+	}
+
+	return state, nil
+}
+
+// ApplyChange applies a change read from the change log of the primary to a table of a replica. The
+// history and trash of the table are kept as the primary's handlers would and the change is added
+// to the table's change log with the primary's sequence number. The caller must hold the table's
+// write lock.
+func ApplyChange(projName string, entry ChangeEntry) error {
+	if entry.Op == CHANGE_PURGE_TRASH {
+		var err error
+		if entry.Id == "" {
+			err = EmptyTrash(projName, entry.Table)
+		} else {
+			err = RemoveFromTrash(projName, entry.Table, []string{entry.Id})
+		}
+		if err != nil {
+			return err
+		}
+		return AppendChange(projName, entry)
+	}
+
+	oldRow, err := ApplyChangeToRows(projName, entry)
+	if err != nil {
+		return err
+	}
+
+	if oldRow != nil {
+		err = RecordRowHistory(projName, entry.Table, entry.Id, entry.Op, oldRow)
+		if err != nil {
+			return err
+		}
+		if entry.Op == HISTORY_DELETE && IsSoftDeleteOn(projName, entry.Table) {
+			err = MoveRowToTrash(projName, entry.Table, oldRow)
+			if err != nil {
+				return err
+			}
+		}
+	} else if entry.Op == HISTORY_INSERT {
+		err = RecordRowHistory(projName, entry.Table, entry.Id, HISTORY_INSERT, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	return AppendChange(projName, entry)
+}
+
+// ApplyChangeToRows applies a change to the data and indexes files of the table entry.Table and
+// returns the row the change replaced, which is nil if there was none. Purges of the trash leave
+// the rows as they are.
+func ApplyChangeToRows(projName string, entry ChangeEntry) (map[string]string, error) {
+	if entry.Op == CHANGE_PURGE_TRASH {
+		return nil, nil
+	}
+
+	tableName := entry.Table
+	tablePath := GetTablePath(projName, tableName)
+	dataF1Path := filepath.Join(tablePath, "data.flaa1")
+
+	elemsMap := make(map[string]DataF1Elem)
+	if DoesPathExists(dataF1Path) {
+		var err error
+		elemsMap, err = ParseDataF1File(dataF1Path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var oldRow map[string]string
+	if elem, ok := elemsMap[entry.Id]; ok {
+		rawRowData, err := ReadPortionF2File(projName, tableName, "data", elem.DataBegin, elem.DataEnd)
+		if err != nil {
+			return nil, err
+		}
+		oldRow, err = ParseEncodedRowData(rawRowData)
+		if err != nil {
+			return nil, err
+		}
+		oldRow["id"] = entry.Id
+
+		for f, d := range oldRow {
+			if f != "id" && !IsNotIndexedField(projName, tableName, f) {
+				DeleteIndex(projName, tableName, f, d, entry.Id, oldRow["_version"])
+			}
+		}
+
+		dataLumpHandle, err := os.OpenFile(filepath.Join(tablePath, "data.flaa2"), os.O_WRONLY, 0777)
+		if err != nil {
+			return nil, errors.Wrap(err, "os error")
+		}
+		dataLumpHandle.WriteAt(make([]byte, elem.DataEnd-elem.DataBegin), elem.DataBegin)
+		dataLumpHandle.Close()
+
+		delete(elemsMap, entry.Id)
+		err = RewriteF1File(projName, tableName, "data", elemsMap)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if entry.Op != HISTORY_DELETE {
+		err := SaveRowData(projName, tableName, entry.Id, entry.Row)
+		if err != nil {
+			return nil, err
+		}
+
+		for k, v := range entry.Row {
+			if k != "id" && !IsNotIndexedField(projName, tableName, k) {
+				err = MakeIndex(projName, tableName, k, v, entry.Id)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		lastIdPath := filepath.Join(tablePath, "lastId.txt")
+		raw, _ := os.ReadFile(lastIdPath)
+		lastId, _ := strconv.ParseInt(strings.TrimSpace(string(raw)), 10, 64)
+		rowId, err := strconv.ParseInt(entry.Id, 10, 64)
+		if err == nil && rowId > lastId {
+			os.WriteFile(lastIdPath, []byte(entry.Id), 0777)
+		}
+	}
+
+	return oldRow, nil
+}
@@ -73,9 +73,14 @@ func EncodeRowData(projName, tableName string, toWrite map[string]string) string
 }
 
 func SaveRowData(projName, tableName, rowId string, toWrite map[string]string) error {
+	return AppendRowData(projName, tableName, "data", rowId, toWrite)
+}
+
+// AppendRowData encodes a row and appends it to the 'name' pair of flaa1 and flaa2 files of a table.
+func AppendRowData(projName, tableName, name, dataKey string, toWrite map[string]string) error {
 	tablePath := GetTablePath(projName, tableName)
 
-	dataLumpPath := filepath.Join(tablePath, "data.flaa2")
+	dataLumpPath := filepath.Join(tablePath, name+".flaa2")
 	dataForCurrentRow := EncodeRowData(projName, tableName, toWrite)
 	var begin int64
 	var end int64
@@ -105,8 +110,8 @@ func SaveRowData(projName, tableName, rowId string, toWrite map[string]string) e
 		end = int64(len([]byte(dataForCurrentRow)))
 	}
 
-	elem := DataF1Elem{rowId, begin, end}
-	err := AppendDataF1File(projName, tableName, "data", elem)
+	elem := DataF1Elem{dataKey, begin, end}
+	err := AppendDataF1File(projName, tableName, name, elem)
 	if err != nil {
 		return errors.Wrap(err, "os error")
 	}
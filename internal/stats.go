@@ -0,0 +1,99 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// IndexStats describes the indexes of a field. Keys is the number of distinct values indexed.
+type IndexStats struct {
+	Keys int `json:"keys"`
+	FileSpace
+}
+
+// TableStats describes the storage of a table. Versions counts the rows by the structure version
+// they were written with.
+type TableStats struct {
+	Rows      int                   `json:"rows"`
+	LastId    string                `json:"last_id"`
+	Data      FileSpace             `json:"data"`
+	Indexes   map[string]IndexStats `json:"indexes"`
+	Versions  map[string]int        `json:"versions"`
+	Files     map[string]int64      `json:"files"`
+	TotalSize int64                 `json:"total_size"`
+}
+
+// GetTableStats reads the storage statistics of a table. The caller must hold the table's read lock.
+func GetTableStats(projName, tableName string) (TableStats, error) {
+	tablePath := GetTablePath(projName, tableName)
+	stats := TableStats{
+		Indexes:  make(map[string]IndexStats),
+		Versions: make(map[string]int),
+		Files:    make(map[string]int64),
+	}
+
+	raw, _ := os.ReadFile(filepath.Join(tablePath, "lastId.txt"))
+	stats.LastId = strings.TrimSpace(string(raw))
+
+	dirFIs, err := os.ReadDir(tablePath)
+	if err != nil {
+		return stats, errors.Wrap(err, "directory read error")
+	}
+	for _, dirFI := range dirFIs {
+		if dirFI.IsDir() {
+			continue
+		}
+		info, err := dirFI.Info()
+		if err != nil {
+			return stats, errors.Wrap(err, "os error")
+		}
+		stats.Files[dirFI.Name()] = info.Size()
+		stats.TotalSize += info.Size()
+
+		if !strings.HasSuffix(dirFI.Name(), "_indexes.flaa1") {
+			continue
+		}
+		name := strings.TrimSuffix(dirFI.Name(), ".flaa1")
+		space, err := GetFileSpace(projName, tableName, name)
+		if err != nil {
+			return stats, err
+		}
+		elemsMap, err := ParseDataF1File(filepath.Join(tablePath, dirFI.Name()))
+		if err != nil {
+			return stats, err
+		}
+		stats.Indexes[strings.TrimSuffix(name, "_indexes")] = IndexStats{len(elemsMap), space}
+	}
+
+	stats.Data, err = GetFileSpace(projName, tableName, "data")
+	if err != nil {
+		return stats, err
+	}
+
+	dataF1Path := filepath.Join(tablePath, "data.flaa1")
+	if !DoesPathExists(dataF1Path) {
+		return stats, nil
+	}
+	elemsMap, err := ParseDataF1File(dataF1Path)
+	if err != nil {
+		return stats, err
+	}
+	stats.Rows = len(elemsMap)
+
+	for _, elem := range elemsMap {
+		rawRowData, err := ReadPortionF2File(projName, tableName, "data", elem.DataBegin, elem.DataEnd)
+		if err != nil {
+			return stats, err
+		}
+		rowMap, err := ParseEncodedRowData(rawRowData)
+		if err != nil {
+			return stats, err
+		}
+		stats.Versions[rowMap["_version"]] += 1
+	}
+
+	return stats, nil
+}
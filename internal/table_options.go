@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/saenuma/zazabul"
+)
+
+// TableOptionsTemplate holds the options of a table and their defaults. The options of a table
+// are stored in 'options.zconf' in the table's folder.
+var TableOptionsTemplate = `// history can be set to either false or true
+// when set to true, the previous versions of updated and deleted rows are kept
+// and can be read with 'as of' searches and the 'row-history' endpoint
+history: false
+
+// soft_delete can be set to either false or true
+// when set to true, deleted rows are moved to the table's trash from where they can be restored
+soft_delete: false
+
+// trash_retention_days is the number of days a deleted row is kept in the trash.
+// 'flaarum.prod trim' purges the rows which have been in the trash for longer.
+trash_retention_days: 30
+
+// id_strategy is how the ids of new rows are made. It is one of
+// sequential: 1, 2, 3 and so on.
+// uuidv7: time ordered UUIDs eg. '01890a5d-ac96-774b-bcce-b302099a8057'.
+// ulid: time ordered ULIDs eg. '01ARZ3NDEKTSV4RRFFQ69G5FAV'.
+// snowflake: time ordered int64 ids which include the 'snowflake_node' setting of the store.
+// foreign keys pointing to a uuidv7 or ulid table must be of type 'string' and the others of type 'int'.
+id_strategy: sequential
+
+`
+
+func getTableOptionsPath(projName, tableName string) string {
+	return filepath.Join(GetTablePath(projName, tableName), "options.zconf")
+}
+
+// GetTableOptions returns the options of a table. Options missing from the table's file take the
+// value from TableOptionsTemplate.
+func GetTableOptions(projName, tableName string) (zazabul.Config, error) {
+	conf, err := zazabul.ParseConfig(TableOptionsTemplate)
+	if err != nil {
+		return conf, err
+	}
+
+	optionsPath := getTableOptionsPath(projName, tableName)
+	if !DoesPathExists(optionsPath) {
+		return conf, nil
+	}
+
+	saved, err := zazabul.LoadConfigFile(optionsPath)
+	if err != nil {
+		return conf, err
+	}
+
+	toUpdate := make(map[string]string)
+	for _, item := range saved.Items {
+		if conf.Get(item.Name) != "" {
+			toUpdate[item.Name] = item.Value
+		}
+	}
+	conf.Update(toUpdate)
+
+	return conf, nil
+}
+
+func GetTableOption(projName, tableName, optionName string) string {
+	conf, err := GetTableOptions(projName, tableName)
+	if err != nil {
+		LogError("table options error", err, "project", projName, "table", tableName)
+		return ""
+	}
+
+	return conf.Get(optionName)
+}
+
+// UpdateTableOptions validates and writes the options of a table.
+func UpdateTableOptions(projName, tableName string, options map[string]string) error {
+	conf, err := GetTableOptions(projName, tableName)
+	if err != nil {
+		return err
+	}
+
+	for k, v := range options {
+		if conf.Get(k) == "" {
+			return errors.New(fmt.Sprintf("'%s' is not a table option", k))
+		}
+		err := validateTableOption(k, v)
+		if err != nil {
+			return err
+		}
+	}
+
+	conf.Update(options)
+	return conf.Write(getTableOptionsPath(projName, tableName))
+}
+
+func validateTableOption(optionName, value string) error {
+	switch optionName {
+	case "history", "soft_delete":
+		if value != "true" && value != "false" {
+			return errors.New(fmt.Sprintf("the table option '%s' must be either 'true' or 'false'", optionName))
+		}
+	case "trash_retention_days":
+		days, err := strconv.Atoi(value)
+		if err != nil || days < 0 {
+			return errors.New(fmt.Sprintf("the table option '%s' must be a number of days", optionName))
+		}
+	case "id_strategy":
+		if !slices.Contains([]string{ID_SEQUENTIAL, ID_UUIDV7, ID_ULID, ID_SNOWFLAKE}, value) {
+			return errors.New(fmt.Sprintf("the table option '%s' must be one of 'sequential', 'uuidv7', 'ulid' and 'snowflake'",
+				optionName))
+		}
+	}
+
+	return nil
+}
+
+// IsCopiedTableFile reports whether a file of a table is copied unchanged when the table's data and
+// indexes files are rebuilt by trim and reindex.
+func IsCopiedTableFile(name string) bool {
+	if strings.HasPrefix(name, "structure") && strings.HasSuffix(name, ".txt") {
+		return true
+	}
+
+	return slices.Contains([]string{"options.zconf", "history.flaa1", "history.flaa2", "trash.flaa1", "trash.flaa2",
+		"changes.flaa1", "changes.flaa2", "lastSeq.txt", "trimmedSeq.txt"}, name)
+}
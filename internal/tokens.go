@@ -0,0 +1,161 @@
+package internal
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TokenClaims are the claims of the access tokens minted by a flaarum server. The tokens are
+// JWTs signed with HMAC-SHA256.
+type TokenClaims struct {
+	Subject   string   `json:"sub"`
+	Role      string   `json:"role"`
+	Projects  []string `json:"projects"`
+	IssuedAt  int64    `json:"iat"`
+	ExpiresAt int64    `json:"exp"`
+}
+
+// TokenSecrets holds the signing secrets. Previous is kept after a rotation so that tokens
+// signed before the rotation stay valid until they expire.
+type TokenSecrets struct {
+	Current  string `json:"current"`
+	Previous string `json:"previous"`
+}
+
+const jwtHeader = `{"alg":"HS256","typ":"JWT"}`
+
+func GetTokenSecretsPath() string {
+	rootPath, err := GetRootPath()
+	if err != nil {
+		panic(err)
+	}
+	return filepath.Join(rootPath, "flaarum.tokensecrets")
+}
+
+// LoadTokenSecrets reads the signing secrets, creating them if they don't exist.
+func LoadTokenSecrets() (TokenSecrets, error) {
+	var secrets TokenSecrets
+	secretsPath := GetTokenSecretsPath()
+	if !DoesPathExists(secretsPath) {
+		secrets.Current = GenerateSecureRandomString(64)
+		return secrets, saveTokenSecrets(secrets)
+	}
+
+	raw, err := os.ReadFile(secretsPath)
+	if err != nil {
+		return secrets, errors.Wrap(err, "os error")
+	}
+
+	err = json.Unmarshal(raw, &secrets)
+	if err != nil {
+		return secrets, errors.Wrap(err, "json error")
+	}
+
+	return secrets, nil
+}
+
+func saveTokenSecrets(secrets TokenSecrets) error {
+	jsonBytes, err := json.Marshal(secrets)
+	if err != nil {
+		return errors.Wrap(err, "json error")
+	}
+
+	err = os.WriteFile(GetTokenSecretsPath(), jsonBytes, 0600)
+	if err != nil {
+		return errors.Wrap(err, "os error")
+	}
+	return nil
+}
+
+// RotateTokenSecrets makes a new signing secret. Tokens signed with the secret before it stay valid.
+func RotateTokenSecrets() error {
+	secrets, err := LoadTokenSecrets()
+	if err != nil {
+		return err
+	}
+
+	secrets.Previous = secrets.Current
+	secrets.Current = GenerateSecureRandomString(64)
+	return saveTokenSecrets(secrets)
+}
+
+func signTokenPayload(payload, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// MintToken returns a signed token with the given claims which expires after ttl.
+func MintToken(claims TokenClaims, ttl time.Duration) (string, error) {
+	secrets, err := LoadTokenSecrets()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims.IssuedAt = now.Unix()
+	claims.ExpiresAt = now.Add(ttl).Unix()
+
+	claimsBytes, err := json.Marshal(claims)
+	if err != nil {
+		return "", errors.Wrap(err, "json error")
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString([]byte(jwtHeader)) + "." +
+		base64.RawURLEncoding.EncodeToString(claimsBytes)
+
+	return payload + "." + signTokenPayload(payload, secrets.Current), nil
+}
+
+// VerifyToken checks the signature and the expiry of a token and returns its claims.
+func VerifyToken(token string) (TokenClaims, error) {
+	var claims TokenClaims
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, errors.New("malformed token")
+	}
+
+	secrets, err := LoadTokenSecrets()
+	if err != nil {
+		return claims, err
+	}
+
+	payload := parts[0] + "." + parts[1]
+	signatureOk := hmac.Equal([]byte(parts[2]), []byte(signTokenPayload(payload, secrets.Current)))
+	if !signatureOk && secrets.Previous != "" {
+		signatureOk = hmac.Equal([]byte(parts[2]), []byte(signTokenPayload(payload, secrets.Previous)))
+	}
+	if !signatureOk {
+		return claims, errors.New("invalid token signature")
+	}
+
+	claimsBytes, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return claims, errors.New("malformed token")
+	}
+
+	err = json.Unmarshal(claimsBytes, &claims)
+	if err != nil {
+		return claims, errors.New("malformed token")
+	}
+
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return claims, errors.New("expired token")
+	}
+
+	return claims, nil
+}
+
+// AsAPIKey returns the claims as a key so that the same permission checks apply to tokens and keys.
+func (claims TokenClaims) AsAPIKey() APIKey {
+	return APIKey{Name: claims.Subject, Role: claims.Role, Projects: claims.Projects}
+}
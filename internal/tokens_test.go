@@ -0,0 +1,101 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenRoundTrip(t *testing.T) {
+	newTestRoot(t, ID_SEQUENTIAL)
+
+	token, err := MintToken(TokenClaims{Subject: "reporter", Role: ROLE_READ, Projects: []string{"proj"}}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := VerifyToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "reporter" || claims.Role != ROLE_READ || len(claims.Projects) != 1 || claims.Projects[0] != "proj" {
+		t.Errorf("the token has the claims %+v", claims)
+	}
+	if claims.ExpiresAt-claims.IssuedAt != int64(time.Hour.Seconds()) {
+		t.Errorf("the token expires %d seconds after it was issued", claims.ExpiresAt-claims.IssuedAt)
+	}
+}
+
+func TestExpiredToken(t *testing.T) {
+	newTestRoot(t, ID_SEQUENTIAL)
+
+	token, err := MintToken(TokenClaims{Subject: "reporter", Role: ROLE_READ, Projects: []string{"proj"}}, -time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = VerifyToken(token)
+	if err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("an expired token was verified with the error %v", err)
+	}
+}
+
+func TestTokenWithBadSignature(t *testing.T) {
+	newTestRoot(t, ID_SEQUENTIAL)
+
+	token, err := MintToken(TokenClaims{Subject: "reporter", Role: ROLE_READ, Projects: []string{"proj"}}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := MintToken(TokenClaims{Subject: "reporter", Role: ROLE_ADMIN, Projects: []string{"*"}}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := strings.Split(token, ".")
+	otherParts := strings.Split(other, ".")
+	for i, forged := range []string{
+		// the claims of a token with the signature of another
+		parts[0] + "." + otherParts[1] + "." + parts[2],
+		parts[0] + "." + parts[1] + ".",
+		parts[0] + "." + parts[1],
+		"",
+	} {
+		_, err = VerifyToken(forged)
+		if err == nil {
+			t.Errorf("case %d: the forged token '%s' was verified", i, forged)
+		}
+	}
+
+	// a token signed by another store
+	newTestRoot(t, ID_SEQUENTIAL)
+	_, err = VerifyToken(token)
+	if err == nil {
+		t.Error("a token signed with another secret was verified")
+	}
+}
+
+func TestTokenSecretRotation(t *testing.T) {
+	newTestRoot(t, ID_SEQUENTIAL)
+
+	token, err := MintToken(TokenClaims{Subject: "reporter", Role: ROLE_READ, Projects: []string{"proj"}}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = RotateTokenSecrets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = VerifyToken(token)
+	if err != nil {
+		t.Errorf("a token signed with the previous secret was refused: %s", err)
+	}
+
+	err = RotateTokenSecrets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = VerifyToken(token)
+	if err == nil {
+		t.Error("a token signed two rotations ago was verified")
+	}
+}
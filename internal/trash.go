@@ -0,0 +1,140 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func IsSoftDeleteOn(projName, tableName string) bool {
+	return GetTableOption(projName, tableName, "soft_delete") == "true"
+}
+
+// MoveRowToTrash keeps a deleted row in the trash files of a table ('trash.flaa1' and 'trash.flaa2').
+// The time of deletion is stored in the row's '_deleted_at' field.
+func MoveRowToTrash(projName, tableName string, row map[string]string) error {
+	toWrite := make(map[string]string)
+	for k, v := range row {
+		if k == "id" {
+			continue
+		}
+		toWrite[k] = v
+	}
+	toWrite["_deleted_at"] = strconv.FormatInt(time.Now().Unix(), 10)
+
+	return AppendRowData(projName, tableName, "trash", row["id"], toWrite)
+}
+
+// ReadTrash returns the rows in the trash of a table keyed by their ids.
+func ReadTrash(projName, tableName string) (map[string]map[string]string, error) {
+	ret := make(map[string]map[string]string)
+	trashF1Path := filepath.Join(GetTablePath(projName, tableName), "trash.flaa1")
+	if !DoesPathExists(trashF1Path) {
+		return ret, nil
+	}
+
+	elemsMap, err := ParseDataF1File(trashF1Path)
+	if err != nil {
+		return ret, err
+	}
+
+	for id, elem := range elemsMap {
+		rawRowData, err := ReadPortionF2File(projName, tableName, "trash", elem.DataBegin, elem.DataEnd)
+		if err != nil {
+			return ret, err
+		}
+		rowMap, err := ParseEncodedRowData(rawRowData)
+		if err != nil {
+			return ret, err
+		}
+		rowMap["id"] = id
+		ret[id] = rowMap
+	}
+
+	return ret, nil
+}
+
+// RemoveFromTrash zeroes the trashed rows with the given ids and removes them from 'trash.flaa1'.
+func RemoveFromTrash(projName, tableName string, ids []string) error {
+	tablePath := GetTablePath(projName, tableName)
+	trashF1Path := filepath.Join(tablePath, "trash.flaa1")
+	if !DoesPathExists(trashF1Path) {
+		return nil
+	}
+
+	elemsMap, err := ParseDataF1File(trashF1Path)
+	if err != nil {
+		return err
+	}
+
+	trashHandle, err := os.OpenFile(filepath.Join(tablePath, "trash.flaa2"), os.O_WRONLY, 0777)
+	if err != nil {
+		return errors.Wrap(err, "os error")
+	}
+	defer trashHandle.Close()
+
+	for _, id := range ids {
+		elem, ok := elemsMap[id]
+		if !ok {
+			continue
+		}
+		trashHandle.WriteAt(make([]byte, elem.DataEnd-elem.DataBegin), elem.DataBegin)
+		delete(elemsMap, id)
+	}
+
+	return RewriteF1File(projName, tableName, "trash", elemsMap)
+}
+
+// EmptyTrash removes all the rows in the trash of a table.
+func EmptyTrash(projName, tableName string) error {
+	tablePath := GetTablePath(projName, tableName)
+	for _, name := range []string{"trash.flaa1", "trash.flaa2"} {
+		err := os.Remove(filepath.Join(tablePath, name))
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "os error")
+		}
+	}
+
+	return nil
+}
+
+// CompactTrash rewrites the trash files of a table leaving out the rows deleted before cutoff.
+func CompactTrash(projName, tableName string, cutoff time.Time) error {
+	trashedRows, err := ReadTrash(projName, tableName)
+	if err != nil {
+		return err
+	}
+
+	err = EmptyTrash(projName, tableName)
+	if err != nil {
+		return err
+	}
+
+	for id, row := range trashedRows {
+		deletedAt, _ := strconv.ParseInt(row["_deleted_at"], 10, 64)
+		if time.Unix(deletedAt, 0).Before(cutoff) {
+			continue
+		}
+
+		delete(row, "id")
+		err = AppendRowData(projName, tableName, "trash", id, row)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// TrashCutoff returns the time before which trashed rows of a table have expired.
+func TrashCutoff(projName, tableName string) time.Time {
+	days, err := strconv.Atoi(GetTableOption(projName, tableName, "trash_retention_days"))
+	if err != nil {
+		days = 30
+	}
+
+	return time.Now().Add(-time.Duration(days) * 24 * time.Hour)
+}
@@ -0,0 +1,180 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// TrimTable rewrites the data and indexes files of a table without the space left by updates and
+// deletes, and purges the rows which have been in the trash longer than the retention period.
+// The table must not be used while this runs.
+func TrimTable(projName, tableName string) error {
+	tmpTableName := tableName + "_trim_tmp"
+	err := TrimTableFiles(projName, tableName, tmpTableName)
+	if err != nil {
+		return err
+	}
+
+	return SwapTableFolder(projName, tableName, tmpTableName)
+}
+
+// TrimTableFiles writes the trimmed files of a table to the table folder tmpTableName.
+func TrimTableFiles(projName, tableName, tmpTableName string) error {
+	dataPath, _ := GetRootPath()
+	tablePath := filepath.Join(dataPath, projName, tableName)
+	workingTablePath := filepath.Join(dataPath, projName, tmpTableName)
+
+	if DoesPathExists(workingTablePath) {
+		os.RemoveAll(workingTablePath)
+	}
+
+	os.MkdirAll(workingTablePath, 0777)
+
+	// copy the structures, options and history to the new table folder
+	dirFIs, err := os.ReadDir(tablePath)
+	if err != nil {
+		return errors.Wrap(err, "directory read error")
+	}
+	for _, dirFI := range dirFIs {
+		if IsCopiedTableFile(dirFI.Name()) {
+			oldStructPath := filepath.Join(tablePath, dirFI.Name())
+			raw, _ := os.ReadFile(oldStructPath)
+			newStructPath := filepath.Join(workingTablePath, dirFI.Name())
+			os.WriteFile(newStructPath, raw, 0777)
+		}
+	}
+
+	raw, _ := os.ReadFile(filepath.Join(tablePath, "lastId.txt"))
+	os.WriteFile(filepath.Join(workingTablePath, "lastId.txt"), raw, 0777)
+
+	// purge the rows which have been in the trash longer than the retention period
+	err = CompactTrash(projName, tmpTableName, TrashCutoff(projName, tableName))
+	if err != nil {
+		return err
+	}
+
+	refF1Path := filepath.Join(tablePath, "data.flaa1")
+	tmpF2Path := filepath.Join(dataPath, projName, tmpTableName, "data.flaa2")
+	elemsMap, _ := ParseDataF1File(refF1Path)
+
+	// trim the data files
+	for _, elem := range elemsMap {
+		rawRowData, err := ReadPortionF2File(projName, tableName, "data",
+			elem.DataBegin, elem.DataEnd)
+		if err != nil {
+			LogError("trim error", errors.Wrap(err, "read error"), "project", projName, "table", tableName)
+			continue
+		}
+
+		tmpIndexesHandle, err := os.OpenFile(tmpF2Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0777)
+		if err != nil {
+			LogError("trim error", err, "project", projName, "table", tableName)
+			continue
+		}
+		defer tmpIndexesHandle.Close()
+
+		stat, err := tmpIndexesHandle.Stat()
+
+		if err != nil {
+			LogError("trim error", errors.Wrap(err, "stats error"), "project", projName, "table", tableName)
+			continue
+		}
+
+		size := stat.Size()
+		tmpIndexesHandle.Write(rawRowData)
+		begin := size
+		end := int64(len(rawRowData)) + size
+
+		newDataElem := DataF1Elem{DataKey: elem.DataKey, DataBegin: begin, DataEnd: end}
+		err = AppendDataF1File(projName, tmpTableName, "data", newDataElem)
+		if err != nil {
+			LogError("trim error", err, "project", projName, "table", tableName)
+			continue
+		}
+
+	}
+
+	// get all the fields in the data
+	fields := make([]string, 0)
+	for _, elem := range elemsMap {
+
+		rawRowData, err := ReadPortionF2File(projName, tableName, "data",
+			elem.DataBegin, elem.DataEnd)
+		if err != nil {
+			return err
+		}
+
+		rowMap, err := ParseEncodedRowData(rawRowData)
+		if err != nil {
+			LogError("trim error", err, "project", projName, "table", tableName)
+			continue
+		}
+
+		for k := range rowMap {
+			if !slices.Contains(fields, k) {
+				fields = append(fields, k)
+			}
+		}
+
+	}
+
+	// do triming
+	var wg sync.WaitGroup
+	for _, fieldName := range fields {
+		if fieldName == "id" {
+			continue
+		}
+
+		wg.Add(1)
+		go func(fieldName string) {
+			defer wg.Done()
+
+			indexesF1Path := filepath.Join(dataPath, projName, tableName, fieldName+"_indexes.flaa1")
+			tmpIndexesF2Path := filepath.Join(dataPath, projName, tmpTableName, fieldName+"_indexes.flaa2")
+
+			indexesF1ElemsMap, _ := ParseDataF1File(indexesF1Path)
+
+			for _, idxElem := range indexesF1ElemsMap {
+				idxElemDataFromF2, err := ReadPortionF2File(projName, tableName, fieldName+"_indexes",
+					idxElem.DataBegin, idxElem.DataEnd)
+				if err != nil {
+					LogError("trim error", err, "project", projName, "table", tableName)
+					continue
+				}
+
+				tmpIndexesHandle, err := os.OpenFile(tmpIndexesF2Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0777)
+				if err != nil {
+					LogError("trim error", err, "project", projName, "table", tableName)
+					continue
+				}
+				defer tmpIndexesHandle.Close()
+
+				stat, err := tmpIndexesHandle.Stat()
+				if err != nil {
+					LogError("trim error", err, "project", projName, "table", tableName)
+					continue
+				}
+
+				size := stat.Size()
+				tmpIndexesHandle.Write(idxElemDataFromF2)
+				begin := size
+				end := int64(len(idxElemDataFromF2)) + size
+
+				newIdxElem := DataF1Elem{DataKey: idxElem.DataKey, DataBegin: begin, DataEnd: end}
+				err = AppendDataF1File(projName, tmpTableName, fieldName+"_indexes", newIdxElem)
+				if err != nil {
+					LogError("trim error", err, "project", projName, "table", tableName)
+					continue
+				}
+			}
+		}(fieldName)
+	}
+
+	wg.Wait()
+
+	return nil
+}
@@ -0,0 +1,81 @@
+package internal
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// WEBHOOK_SIGNATURE_HEADER holds the hex encoded HMAC-SHA256 of a webhook's body, keyed with the
+// webhook's secret.
+const WEBHOOK_SIGNATURE_HEADER = "X-Flaarum-Signature"
+
+// Webhook is an HTTP callback the store calls after successful inserts, updates and deletes on
+// a table of a project. A table of "*" means all the tables of the project.
+type Webhook struct {
+	Id      string `json:"id"`
+	Project string `json:"project"`
+	Table   string `json:"table"`
+	URL     string `json:"url"`
+	Secret  string `json:"secret"`
+	Created string `json:"created"`
+}
+
+// Matches reports whether the webhook is called for changes to a table of a project.
+func (hook Webhook) Matches(projName, tableName string) bool {
+	return hook.Project == projName && (hook.Table == "*" || hook.Table == tableName)
+}
+
+func GetWebhooksPath() string {
+	rootPath, err := GetRootPath()
+	if err != nil {
+		panic(err)
+	}
+	return filepath.Join(rootPath, "flaarum.webhooks")
+}
+
+func LoadWebhooks() ([]Webhook, error) {
+	hooks := make([]Webhook, 0)
+	hooksPath := GetWebhooksPath()
+	if !DoesPathExists(hooksPath) {
+		return hooks, nil
+	}
+
+	raw, err := os.ReadFile(hooksPath)
+	if err != nil {
+		return hooks, errors.Wrap(err, "os error")
+	}
+
+	err = json.Unmarshal(raw, &hooks)
+	if err != nil {
+		return hooks, errors.Wrap(err, "json error")
+	}
+
+	return hooks, nil
+}
+
+func SaveWebhooks(hooks []Webhook) error {
+	jsonBytes, err := json.MarshalIndent(hooks, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "json error")
+	}
+
+	err = os.WriteFile(GetWebhooksPath(), jsonBytes, 0600)
+	if err != nil {
+		return errors.Wrap(err, "os error")
+	}
+
+	return nil
+}
+
+// SignWebhookBody returns the value of the WEBHOOK_SIGNATURE_HEADER for a body.
+func SignWebhookBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return fmt.Sprintf("sha256=%x", mac.Sum(nil))
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/saenuma/flaarum/internal"
+)
+
+var auditTimeFormats = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"}
+
+func parseAuditTime(value string) (time.Time, error) {
+	for _, format := range auditTimeFormats {
+		t, err := time.ParseInLocation(format, value, time.Local)
+		if err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, errors.New(fmt.Sprintf("'%s' is not a valid time eg. '2025-01-31' or '2025-01-31T15:04'", value))
+}
+
+// queryAuditLog prints the entries of the audit log written between from and to. filter is
+// empty, a project or a project/table combo.
+func queryAuditLog(fromStr, toStr, filter string) error {
+	from, err := parseAuditTime(fromStr)
+	if err != nil {
+		return err
+	}
+	to, err := parseAuditTime(toStr)
+	if err != nil {
+		return err
+	}
+
+	var project, table string
+	if filter != "" {
+		parts := strings.Split(filter, "/")
+		project = parts[0]
+		if len(parts) > 1 {
+			table = parts[1]
+		}
+	}
+
+	paths, err := internal.ListAuditLogFiles()
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		entries, err := internal.ReadAuditEntries(path)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			entryTime, err := time.Parse(time.RFC3339, entry.Time)
+			if err != nil || entryTime.Before(from) || entryTime.After(to) {
+				continue
+			}
+			if project != "" && entry.Project != project {
+				continue
+			}
+			if table != "" && entry.Table != table {
+				continue
+			}
+
+			out := fmt.Sprintf("%s  %s  key: %s  from: %s  %s", entry.Time, entry.Action, entry.Key,
+				entry.ClientAddr, entry.Project)
+			if entry.Table != "" {
+				out += "/" + entry.Table
+			}
+			if len(entry.Ids) != 0 {
+				out += "  ids: " + strings.Join(entry.Ids, ",")
+			}
+			fmt.Println(out)
+			if entry.Statement != "" {
+				fmt.Println("    " + strings.ReplaceAll(strings.TrimSpace(entry.Statement), "\n", "\n    "))
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/saenuma/flaarum/internal"
+)
+
+func backupProject(projName string, incremental bool) (string, error) {
+	values := url.Values{}
+	if incremental {
+		values.Set("incremental", "t")
+	}
+
+	out, err := internal.LocalRequest("backup-project/"+projName, values)
+	if err != nil {
+		return "", err
+	}
+
+	return string(out), nil
+}
+
+// restoreProject restores a backup archive, which must be in the backups folder of the project it was
+// taken from. If newProjName is empty, the project is restored under the name it was backed up from.
+func restoreProject(archivePath, newProjName string) error {
+	if !filepath.IsAbs(archivePath) {
+		inputPath, err := internal.GetFlaarumPath(archivePath)
+		if err != nil {
+			return err
+		}
+		archivePath = inputPath
+	}
+
+	manifest, err := internal.ReadBackupManifest(archivePath)
+	if err != nil {
+		return err
+	}
+
+	if newProjName == "" {
+		newProjName = manifest.Project
+	}
+
+	// the store only restores the archives of its backups folders, given by name
+	backupsPath := internal.GetProjectBackupsPath(manifest.Project)
+	if filepath.Clean(filepath.Dir(archivePath)) != filepath.Clean(backupsPath) {
+		return errors.New(fmt.Sprintf("the archive must be in '%s'. Copy it there with the backups it is based on.", backupsPath))
+	}
+
+	_, err = internal.LocalRequest("restore-project/"+newProjName, url.Values{
+		"backup":       {filepath.Base(archivePath)},
+		"from-project": {manifest.Project},
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Restored %d tables of project '%s' backed up on %s to project '%s'\n", len(manifest.Tables),
+		manifest.Project, manifest.Created, newProjName)
+	return nil
+}
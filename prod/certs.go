@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/saenuma/flaarum/internal"
+)
+
+// generateCA creates the certificate authority used to verify clients when client_auth is on.
+// It also creates the certificate of the local client used by 'flaarum.cli' and 'flaarum.prod'.
+func generateCA() error {
+	rootPath, _ := internal.GetRootPath()
+	keyPath := filepath.Join(rootPath, "flaarum-ca.key")
+	crtPath := filepath.Join(rootPath, "flaarum-ca.crt")
+
+	out, err := exec.Command("openssl", "req", "-x509", "-newkey", "rsa:4096", "-keyout", keyPath,
+		"-out", crtPath, "-sha256", "-days", "3650", "-nodes", "-subj", "/CN=flaarum-ca").CombinedOutput()
+	if err != nil {
+		return errors.Wrap(err, string(out))
+	}
+	os.Chmod(keyPath, 0600)
+
+	return generateClientCert("local")
+}
+
+// generateClientCert creates a client certificate signed by the certificate authority. The name is
+// used as the common name of the certificate. If it is the name of a key created with 'ck', requests
+// with the certificate get the permissions of that key.
+func generateClientCert(name string) error {
+	// the name is part of the paths of the certificate files and of its subject
+	if name == "" {
+		return errors.New("a client certificate needs a name")
+	}
+	if err := internal.NameValidate(name); err != nil {
+		return err
+	}
+
+	rootPath, _ := internal.GetRootPath()
+	caKeyPath := filepath.Join(rootPath, "flaarum-ca.key")
+	caCrtPath := filepath.Join(rootPath, "flaarum-ca.crt")
+	if !internal.DoesPathExists(caKeyPath) {
+		return errors.New("the certificate authority does not exist. Run 'flaarum.prod genca'")
+	}
+
+	keyPath := filepath.Join(rootPath, "flaarum-client-"+name+".key")
+	csrPath := filepath.Join(rootPath, "flaarum-client-"+name+".csr")
+	crtPath := filepath.Join(rootPath, "flaarum-client-"+name+".crt")
+
+	out, err := exec.Command("openssl", "req", "-newkey", "rsa:4096", "-keyout", keyPath, "-out", csrPath,
+		"-nodes", "-subj", "/CN="+name).CombinedOutput()
+	if err != nil {
+		return errors.Wrap(err, string(out))
+	}
+	defer os.Remove(csrPath)
+	os.Chmod(keyPath, 0600)
+
+	out, err = exec.Command("openssl", "x509", "-req", "-in", csrPath, "-CA", caCrtPath, "-CAkey", caKeyPath,
+		"-CAcreateserial", "-out", crtPath, "-days", "3650", "-sha256").CombinedOutput()
+	if err != nil {
+		return errors.Wrap(err, string(out))
+	}
+
+	return nil
+}
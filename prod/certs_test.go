@@ -0,0 +1,29 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerateClientCertRefusesPaths(t *testing.T) {
+	rootPath := t.TempDir()
+	t.Setenv("SNAP_COMMON", rootPath)
+	// only its presence is checked before the name is used
+	err := os.WriteFile(filepath.Join(rootPath, "flaarum-ca.key"), []byte("key"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"", "../ca", "a/b", "x.key", "a b", "/CN=admin"} {
+		err := generateClientCert(name)
+		if err == nil || !strings.Contains(err.Error(), "name") {
+			t.Errorf("the name '%s' was not refused: %v", name, err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(filepath.Dir(rootPath), "ca.key")); err == nil {
+		t.Error("a key was written outside the data folder")
+	}
+}
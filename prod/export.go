@@ -110,6 +110,8 @@ func exportTable(project, table, format string) {
 				case int64:
 					vInStr := strconv.FormatInt(vInType, 10)
 					stringOfV = vInStr
+				case float64:
+					stringOfV = strconv.FormatFloat(vInType, 'f', -1, 64)
 				case bool:
 					var vInStr string
 					if vInType {
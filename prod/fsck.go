@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/gookit/color"
+	"github.com/saenuma/flaarum/internal"
+)
+
+// fsckTables checks the tables of a project, or a single table if tableName is not empty, and
+// repairs what can be repaired if repair is set. While it runs, the store refuses writes to what is
+// checked, or every request to what is repaired. It returns the number of problems left.
+func fsckTables(projName, tableName string, repair bool) (int, error) {
+	tables := []string{tableName}
+	if tableName == "" {
+		var err error
+		tables, err = internal.ListTables(projName)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	mode := "read_only"
+	if repair {
+		mode = "maintenance"
+	}
+
+	left := 0
+	err := withMode(mode, projName, tableName, func() error {
+		for _, tableName := range tables {
+			problems, err := internal.CheckTable(projName, tableName)
+			if err != nil {
+				return err
+			}
+
+			for _, problem := range problems {
+				color.Yellow.Printf("%s/%s: %s: %s\n", projName, tableName, problem.Kind, problem.Detail)
+			}
+
+			if !repair || len(problems) == 0 {
+				left += len(problems)
+				continue
+			}
+
+			err = internal.RepairTable(projName, tableName, problems)
+			if err != nil {
+				return err
+			}
+			problems, err = internal.CheckTable(projName, tableName)
+			if err != nil {
+				return err
+			}
+			left += len(problems)
+			fmt.Printf("%s/%s: repaired. %d problems left.\n", projName, tableName, len(problems))
+			if internal.DoesPathExists(internal.GetLostPath(projName, tableName)) {
+				fmt.Printf("The bytes of the rows dropped from %s/%s are kept in '%s'.\n", projName, tableName,
+					internal.GetLostPath(projName, tableName))
+			}
+		}
+		return nil
+	})
+
+	return left, err
+}
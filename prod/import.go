@@ -11,39 +11,44 @@ import (
 	"slices"
 	"strings"
 
-	"github.com/gookit/color"
+	"github.com/pkg/errors"
 	"github.com/saenuma/flaarum/internal"
 )
 
-func importProject(project, format, path string) {
+func importProject(project, format, path string) error {
 	dirFIs, err := os.ReadDir(path)
 	if err != nil {
-		color.Red.Println(err.Error())
-		os.Exit(1)
+		return errors.Wrap(err, "os error")
 	}
 
 	for _, dirFI := range dirFIs {
 		if strings.HasSuffix(dirFI.Name(), "."+format) {
 			tableName := strings.ReplaceAll(dirFI.Name(), "."+format, "")
-			importTable(project, tableName, format, filepath.Join(path, dirFI.Name()))
+			err := importTable(project, tableName, format, filepath.Join(path, dirFI.Name()))
+			if err != nil {
+				return err
+			}
 		}
 	}
+	return nil
 }
 
-func importTable(project, table, format, srcPath string) {
-	cl := internal.GetLocalFlaarumClient(project)
-	existingTables, err := cl.ListTables()
+// importTable replaces the rows of a table with those of a json or csv export. The tables are read
+// from disk as the store refuses requests to what is imported.
+func importTable(project, table, format, srcPath string) error {
+	existingTables, err := internal.ListTables(project)
 	if err != nil {
-		color.Red.Println(err.Error())
-		os.Exit(1)
+		return err
 	}
 
 	if !slices.Contains(existingTables, table) {
-		color.Red.Printf("table %s does not exists\n", table)
-		os.Exit(1)
+		return errors.New(fmt.Sprintf("table %s does not exists", table))
 	}
 	tablePath := internal.GetTablePath(project, table)
 
+	// only converts the rows with the field types on disk; it sends no request.
+	cl := &internal.LocalClient{ProjName: project}
+
 	// use RAM to speed up import operation
 	var buffer bytes.Buffer
 	writer := io.Writer(&buffer)
@@ -53,15 +58,13 @@ func importTable(project, table, format, srcPath string) {
 	if format == "json" {
 		rawJSON, err := os.ReadFile(srcPath)
 		if err != nil {
-			color.Red.Println(err.Error())
-			os.Exit(1)
+			return errors.Wrap(err, "os error")
 		}
 
 		objs := make([]map[string]any, 0)
 		err = json.Unmarshal(rawJSON, &objs)
 		if err != nil {
-			color.Red.Println(err.Error())
-			os.Exit(1)
+			return errors.Wrap(err, "json error")
 		}
 
 		for _, obj := range objs {
@@ -83,16 +86,18 @@ func importTable(project, table, format, srcPath string) {
 
 		f, err := os.Open(srcPath)
 		if err != nil {
-			color.Red.Println(err.Error())
-			os.Exit(1)
+			return errors.Wrap(err, "os error")
 		}
 		defer f.Close()
 
 		csvReader := csv.NewReader(f)
 		records, err := csvReader.ReadAll()
 		if err != nil {
-			color.Red.Println(err.Error())
-			os.Exit(1)
+			return errors.Wrap(err, "csv error")
+		}
+
+		if len(records) == 0 {
+			return errors.New(fmt.Sprintf("'%s' has no header", srcPath))
 		}
 
 		for _, record := range records[1:] {
@@ -112,13 +117,23 @@ func importTable(project, table, format, srcPath string) {
 
 	}
 
+	if len(elemsSlice) == 0 {
+		return errors.New(fmt.Sprintf("'%s' has no rows to import", srcPath))
+	}
+
 	lastIdPath := filepath.Join(tablePath, "lastId.txt")
 
 	lastId := elemsSlice[len(elemsSlice)-1].DataKey
-	os.WriteFile(lastIdPath, []byte(lastId), 0777)
+	err = os.WriteFile(lastIdPath, []byte(lastId), 0777)
+	if err != nil {
+		return errors.Wrap(err, "os error")
+	}
 
 	dataLumpPath := filepath.Join(tablePath, "data.flaa2")
-	os.WriteFile(dataLumpPath, buffer.Bytes(), 0777)
+	err = os.WriteFile(dataLumpPath, buffer.Bytes(), 0777)
+	if err != nil {
+		return errors.Wrap(err, "os error")
+	}
 
 	var out string
 	for _, elem := range elemsSlice {
@@ -126,9 +141,11 @@ func importTable(project, table, format, srcPath string) {
 			elem.DataBegin, elem.DataEnd)
 	}
 	dataIndexPath := filepath.Join(tablePath, "data.flaa1")
-	os.WriteFile(dataIndexPath, []byte(out), 0777)
+	err = os.WriteFile(dataIndexPath, []byte(out), 0777)
+	if err != nil {
+		return errors.Wrap(err, "os error")
+	}
 
 	// do reindexing
-	reIndex(project, table)
-
+	return internal.ReindexTable(project, table)
 }
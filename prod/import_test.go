@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/saenuma/flaarum/internal"
+	"github.com/saenuma/zazabul"
+)
+
+// newFakeStore starts a server answering the requests of withMode like a running store: it keeps
+// the scoped modes set with '/set-mode' and refuses every other request to a project in
+// maintenance. It returns the modes.
+func newFakeStore(t *testing.T) (map[string]string, *sync.Mutex) {
+	t.Helper()
+
+	var mutex sync.Mutex
+	modes := make(map[string]string)
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		switch r.URL.Path {
+		case "/is-flaarum":
+			fmt.Fprintf(w, "yeah-flaarum")
+		case "/get-mode":
+			json.NewEncoder(w).Encode(map[string]any{"mode": "normal", "scoped": modes})
+		case "/set-mode":
+			scope := r.FormValue("project")
+			if r.FormValue("table") != "" {
+				scope += "/" + r.FormValue("table")
+			}
+			if r.FormValue("mode") == "normal" {
+				delete(modes, scope)
+			} else {
+				modes[scope] = r.FormValue("mode")
+			}
+			fmt.Fprintf(w, "ok")
+		default:
+			parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+			if len(parts) > 1 && modes[parts[1]] == "maintenance" {
+				http.Error(w, "Service Unavailable: the store is in maintenance.", http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprintf(w, "ok")
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	_, port, err := net.SplitHostPort(ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rootPath := t.TempDir()
+	t.Setenv("SNAP_COMMON", rootPath)
+	conf, err := zazabul.ParseConfig(internal.RootConfigTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.Update(map[string]string{"port": port})
+	err = conf.Write(filepath.Join(rootPath, "flaarum.zconf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return modes, &mutex
+}
+
+func TestImportProjectWhileStoreRuns(t *testing.T) {
+	modes, mutex := newFakeStore(t)
+
+	tablePath := internal.GetTablePath("proj", "users")
+	err := os.MkdirAll(tablePath, 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(tablePath, "structure1.txt"),
+		[]byte("table: users\nfields:\n  name string\n  age int\n::\n"), 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exportsPath := t.TempDir()
+	err = os.WriteFile(filepath.Join(exportsPath, "users.json"),
+		[]byte(`[{"id": 1, "name": "ada", "age": 36}, {"id": 2, "name": "alan", "age": 41}]`), 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var modeDuringImport string
+	err = withMaintenance("proj", "", func() error {
+		mutex.Lock()
+		modeDuringImport = modes["proj"]
+		mutex.Unlock()
+		return importProject("proj", "json", exportsPath)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if modeDuringImport != "maintenance" {
+		t.Errorf("the project was in mode '%s' during the import", modeDuringImport)
+	}
+	mutex.Lock()
+	if mode, ok := modes["proj"]; ok {
+		t.Errorf("the project was left in mode '%s'", mode)
+	}
+	mutex.Unlock()
+
+	elems, err := internal.ParseDataF1File(filepath.Join(tablePath, "data.flaa1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(elems) != 2 {
+		t.Errorf("%d rows were imported, expected 2", len(elems))
+	}
+	raw, err := os.ReadFile(filepath.Join(tablePath, "lastId.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != "2" {
+		t.Errorf("lastId.txt has '%s', expected '2'", raw)
+	}
+}
+
+func TestImportFailureRestoresMode(t *testing.T) {
+	modes, mutex := newFakeStore(t)
+
+	rootPath, _ := internal.GetRootPath()
+	err := os.MkdirAll(filepath.Join(rootPath, "proj"), 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = withMaintenance("proj", "", func() error {
+		return importTable("proj", "missing", "json", filepath.Join(t.TempDir(), "missing.json"))
+	})
+	if err == nil {
+		t.Fatal("importing a missing table succeeded")
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if mode, ok := modes["proj"]; ok {
+		t.Errorf("a failed import left the project in mode '%s'", mode)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/saenuma/flaarum/internal"
+)
+
+// createAPIKey creates a named key and returns the key string. The key string is not stored, only its hash.
+func createAPIKey(name, role string, projects []string) (string, error) {
+	if !internal.IsValidRole(role) {
+		return "", errors.New(fmt.Sprintf("role '%s' is not one of 'read', 'write' or 'admin'", role))
+	}
+	if name == "master" || name == "anonymous" {
+		return "", errors.New(fmt.Sprintf("key name '%s' is used internally", name))
+	}
+
+	keys, err := internal.LoadAPIKeys()
+	if err != nil {
+		return "", err
+	}
+
+	for _, key := range keys {
+		if key.Name == name {
+			return "", errors.New(fmt.Sprintf("a key with name '%s' already exists", name))
+		}
+	}
+
+	keyStr := internal.GenerateSecureRandomString(50)
+	keys = append(keys, internal.APIKey{
+		Name:     name,
+		Hash:     internal.HashKeyStr(keyStr),
+		Role:     role,
+		Projects: projects,
+		Created:  time.Now().Format(time.RFC3339),
+	})
+
+	err = internal.SaveAPIKeys(keys)
+	if err != nil {
+		return "", err
+	}
+
+	return keyStr, nil
+}
+
+func listAPIKeys() error {
+	keys, err := internal.LoadAPIKeys()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Keys List:")
+	for _, key := range keys {
+		fmt.Printf("  %s  role: %s  projects: %s  created: %s\n", key.Name, key.Role,
+			strings.Join(key.Projects, ","), key.Created)
+	}
+	fmt.Println()
+
+	return nil
+}
+
+func revokeAPIKey(name string) error {
+	keys, err := internal.LoadAPIKeys()
+	if err != nil {
+		return err
+	}
+
+	index := slices.IndexFunc(keys, func(key internal.APIKey) bool {
+		return key.Name == name
+	})
+	if index == -1 {
+		return errors.New(fmt.Sprintf("there is no key with name '%s'", name))
+	}
+
+	keys = slices.Delete(keys, index, index+1)
+	return internal.SaveAPIKeys(keys)
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/gookit/color"
 	"github.com/saenuma/flaarum/internal"
 	"github.com/saenuma/zazabul"
+	"github.com/tidwall/pretty"
 )
 
 func main() {
@@ -28,12 +29,32 @@ Supported Commands:
 
   genssl    Generates the ssl certificates for a flaarum installation
 
+  genca     Generates the certificate authority used to verify client certificates when 'client_auth'
+            is on. It also generates the certificate of the local client used by 'flaarum.cli'.
+
+  gencc     Generates a client certificate. It expects a name which becomes the common name of the
+            certificate. If the name is that of a key created with 'ck', the certificate gets the key's role.
+
   r         Read the current key string used
 
   c         Creates / Updates and prints a new key string
 
   mpr       Make production ready. It also creates a key string.
 
+  ck        Creates a named key. It expects a name, a role and one or more projects eg. 'ck reporter read proj1 proj2'
+            The role is one of 'read', 'write' or 'admin'. Use '*' as the project to give access to all projects.
+            The key string is printed once and only its hash is stored.
+
+  lk        Lists the named keys
+
+  rk        Revokes a named key. It expects the name of the key
+
+  mt        Mints an access token. It expects a name, a role, a duration and one or more projects
+            eg. 'mt reporter read 24h proj1 proj2'. Clients send the token in an 'Authorization: Bearer' header.
+
+  rts       Rotates the secret used to sign access tokens. Tokens signed before the rotation stay valid
+            until they expire but would not survive a second rotation.
+
   etj       Exports a table to json. It expects a project/table combo
 
   epj       Exports a project to json. It expects a project
@@ -58,11 +79,45 @@ Supported Commands:
             All files and folders must be placed in the path gotten from 'flaarum.cli pwd' during import
             Create the tables before importing.
 
+  backup    Takes a consistent snapshot of a project (structures, data, indexes, options and id counters)
+            into a single compressed archive in 'flaarum_backups/<project>' of the data folder. It
+            expects a project and prints the path of the archive.
+            With '--incremental' before the project, only the rows changed or deleted since the
+            project's latest backup are written.
+
+  restore   Restores a backup archive after verifying its checksums. It expects the path to the archive
+            and optionally a new project name. The project being restored to must not exist.
+            The archive must be in the backups folder of the project it was taken from, where
+            'backup' writes them. An incremental backup is restored together with the full backup
+            and the incremental backups before it, which must be in the same folder.
+
   ridx      Reindex a table. This is attimes needed if there has been changes to the table structure.
             It expects a project table combo eg. first_proj/users
 
   trim      Trim large flaarum files. This is needed after months of using the database.
-            It expects a project.
+            It expects a project. It also purges rows which have been in a table's trash for longer
+            than the table's 'trash_retention_days' option.
+
+            When the store is running, 'ridx' and 'trim' are done by the store. The tables stay in use and
+            are only locked to swap in the rebuilt files.
+
+  fsck      Checks the files of a project or of a project/table combo: the offsets of the data and indexes
+            files, the rows, the indexes against the rows, foreign keys and unique fields.
+            With '--repair' before the project, unreadable rows are dropped and the indexes are rebuilt.
+            Broken foreign keys and duplicate unique values are only reported.
+
+  rstat     Prints the replication status of the store: its role and, for a replica, its primary,
+            the number of changes it has not applied and its lag in seconds.
+
+  promote   Promotes the replica on this machine to a primary. It stops following its primary and
+            starts accepting writes. Point the clients to it afterwards.
+
+  mode      Switches the store to a mode. It expects a mode and optionally a project or a project/table combo.
+            The mode is one of 'normal', 'read_only' (writes are refused) and 'maintenance' (all requests
+            are refused). The import commands put what they work on in maintenance mode while they run.
+
+  qal       Query the audit log. It expects a start time, an end time and optionally a project or a
+            project/table combo eg. 'qal 2025-01-01 2025-01-31T18:00 first_proj/users'
 
       `)
 
@@ -126,6 +181,88 @@ Supported Commands:
 			panic(err)
 		}
 
+	case "rstat":
+		out, err := internal.LocalRequest("replication-status", nil)
+		if err != nil {
+			color.Red.Println("Error reading the replication status:\n" + err.Error())
+			os.Exit(1)
+		}
+		fmt.Println(string(pretty.Pretty(out)))
+
+	case "promote":
+		oldPrimary, err := promoteReplica()
+		if err != nil {
+			color.Red.Println("Error promoting the replica:\n" + err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("This store no longer follows '%s' and now accepts writes.\n", oldPrimary)
+
+	case "ck":
+		if len(os.Args) < 5 {
+			color.Red.Println(`'ck' command expects a name, a role and one or more projects`)
+			os.Exit(1)
+		}
+
+		keyStr, err := createAPIKey(os.Args[2], os.Args[3], os.Args[4:])
+		if err != nil {
+			color.Red.Println("Error creating key:\n" + err.Error())
+			os.Exit(1)
+		}
+		fmt.Println(keyStr)
+
+	case "lk":
+		err := listAPIKeys()
+		if err != nil {
+			color.Red.Println("Error listing keys:\n" + err.Error())
+			os.Exit(1)
+		}
+
+	case "rk":
+		if len(os.Args) != 3 {
+			color.Red.Println(`'rk' command expects the name of a key`)
+			os.Exit(1)
+		}
+
+		err := revokeAPIKey(os.Args[2])
+		if err != nil {
+			color.Red.Println("Error revoking key:\n" + err.Error())
+			os.Exit(1)
+		}
+		fmt.Println("ok")
+
+	case "mt":
+		if len(os.Args) < 6 {
+			color.Red.Println(`'mt' command expects a name, a role, a duration and one or more projects`)
+			os.Exit(1)
+		}
+
+		if !internal.IsValidRole(os.Args[3]) {
+			color.Red.Printf("role '%s' is not one of 'read', 'write' or 'admin'\n", os.Args[3])
+			os.Exit(1)
+		}
+
+		ttl, err := time.ParseDuration(os.Args[4])
+		if err != nil || ttl <= 0 {
+			color.Red.Printf("duration '%s' is not valid eg. '24h'\n", os.Args[4])
+			os.Exit(1)
+		}
+
+		token, err := internal.MintToken(internal.TokenClaims{Subject: os.Args[2], Role: os.Args[3],
+			Projects: os.Args[5:]}, ttl)
+		if err != nil {
+			color.Red.Println("Error minting token:\n" + err.Error())
+			os.Exit(1)
+		}
+		fmt.Println(token)
+
+	case "rts":
+		err := internal.RotateTokenSecrets()
+		if err != nil {
+			color.Red.Println("Error rotating token secret:\n" + err.Error())
+			os.Exit(1)
+		}
+		fmt.Println("ok")
+
 	case "genssl":
 		rootPath, _ := internal.GetRootPath()
 		keyPath := filepath.Join(rootPath, "https-server.key")
@@ -141,6 +278,27 @@ Supported Commands:
 		}
 		fmt.Println("ok")
 
+	case "genca":
+		err := generateCA()
+		if err != nil {
+			color.Red.Println("Error generating certificate authority:\n" + err.Error())
+			os.Exit(1)
+		}
+		fmt.Println("ok")
+
+	case "gencc":
+		if len(os.Args) != 3 {
+			color.Red.Println(`'gencc' command expects a name`)
+			os.Exit(1)
+		}
+
+		err := generateClientCert(os.Args[2])
+		if err != nil {
+			color.Red.Println("Error generating client certificate:\n" + err.Error())
+			os.Exit(1)
+		}
+		fmt.Println("ok")
+
 	case "etj":
 		if len(os.Args) != 3 {
 			color.Red.Println(`'etj' command expects a project/table combo`)
@@ -188,7 +346,13 @@ Supported Commands:
 			os.Exit(1)
 		}
 
-		importTable(parts[0], parts[1], "json", inputPath)
+		err = withMaintenance(parts[0], parts[1], func() error {
+			return importTable(parts[0], parts[1], "json", inputPath)
+		})
+		if err != nil {
+			color.Red.Println("Error importing:\n" + err.Error())
+			os.Exit(1)
+		}
 
 	case "itc":
 		if len(os.Args) != 4 {
@@ -203,7 +367,13 @@ Supported Commands:
 			os.Exit(1)
 		}
 
-		importTable(parts[0], parts[1], "csv", inputPath)
+		err = withMaintenance(parts[0], parts[1], func() error {
+			return importTable(parts[0], parts[1], "csv", inputPath)
+		})
+		if err != nil {
+			color.Red.Println("Error importing:\n" + err.Error())
+			os.Exit(1)
+		}
 
 	case "ipj":
 		if len(os.Args) != 4 {
@@ -217,7 +387,13 @@ Supported Commands:
 			os.Exit(1)
 		}
 
-		importProject(os.Args[2], "json", inputPath)
+		err = withMaintenance(os.Args[2], "", func() error {
+			return importProject(os.Args[2], "json", inputPath)
+		})
+		if err != nil {
+			color.Red.Println("Error importing:\n" + err.Error())
+			os.Exit(1)
+		}
 
 	case "ipc":
 		if len(os.Args) != 4 {
@@ -231,7 +407,44 @@ Supported Commands:
 			os.Exit(1)
 		}
 
-		importProject(os.Args[2], "csv", inputPath)
+		err = withMaintenance(os.Args[2], "", func() error {
+			return importProject(os.Args[2], "csv", inputPath)
+		})
+		if err != nil {
+			color.Red.Println("Error importing:\n" + err.Error())
+			os.Exit(1)
+		}
+
+	case "backup":
+		incremental := len(os.Args) == 4 && os.Args[2] == "--incremental"
+		if len(os.Args) != 3 && !incremental {
+			color.Red.Println(`'backup' command expects a project, optionally preceded by '--incremental'`)
+			os.Exit(1)
+		}
+
+		outPath, err := backupProject(os.Args[len(os.Args)-1], incremental)
+		if err != nil {
+			color.Red.Println("Error backing up:\n" + err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("Backed up to : %s\n", outPath)
+
+	case "restore":
+		if len(os.Args) != 3 && len(os.Args) != 4 {
+			color.Red.Println(`'restore' command expects a backup archive and optionally a new project name`)
+			os.Exit(1)
+		}
+
+		newProjName := ""
+		if len(os.Args) == 4 {
+			newProjName = os.Args[3]
+		}
+
+		err := restoreProject(os.Args[2], newProjName)
+		if err != nil {
+			color.Red.Println("Error restoring:\n" + err.Error())
+			os.Exit(1)
+		}
 
 	case "ridx":
 		if len(os.Args) != 3 {
@@ -240,7 +453,7 @@ Supported Commands:
 		}
 
 		parts := strings.Split(os.Args[2], "/")
-		err := reIndex(parts[0], parts[1])
+		err := reindexTable(parts[0], parts[1])
 		if err != nil {
 			color.Red.Println("Error reindexing:\n" + err.Error())
 			os.Exit(1)
@@ -262,6 +475,61 @@ Supported Commands:
 
 		fmt.Println("ok")
 
+	case "fsck":
+		repair := len(os.Args) == 4 && os.Args[2] == "--repair"
+		if len(os.Args) != 3 && !repair {
+			color.Red.Println(`'fsck' command expects a project or project/table combo and optionally '--repair' before it`)
+			os.Exit(1)
+		}
+
+		projName, tableName, _ := strings.Cut(os.Args[len(os.Args)-1], "/")
+		left, err := fsckTables(projName, tableName, repair)
+		if err != nil {
+			color.Red.Println("Error checking:\n" + err.Error())
+			os.Exit(1)
+		}
+
+		if left > 0 {
+			color.Red.Printf("%d problems found.\n", left)
+			os.Exit(1)
+		}
+		fmt.Println("ok")
+
+	case "mode":
+		if len(os.Args) != 3 && len(os.Args) != 4 {
+			color.Red.Println(`'mode' command expects a mode and optionally a project or project/table combo`)
+			os.Exit(1)
+		}
+
+		projName, tableName := "", ""
+		if len(os.Args) == 4 {
+			projName, tableName, _ = strings.Cut(os.Args[3], "/")
+		}
+
+		err := setStoreMode(os.Args[2], projName, tableName)
+		if err != nil {
+			color.Red.Println("Error setting the mode:\n" + err.Error())
+			os.Exit(1)
+		}
+		fmt.Println("ok")
+
+	case "qal":
+		if len(os.Args) != 4 && len(os.Args) != 5 {
+			color.Red.Println(`'qal' command expects a start time, an end time and optionally a project or project/table combo`)
+			os.Exit(1)
+		}
+
+		filter := ""
+		if len(os.Args) == 5 {
+			filter = os.Args[4]
+		}
+
+		err := queryAuditLog(os.Args[2], os.Args[3], filter)
+		if err != nil {
+			color.Red.Println("Error querying audit log:\n" + err.Error())
+			os.Exit(1)
+		}
+
 	default:
 		color.Red.Println("Unexpected command. Run the Flaarum's prod with --help to find out the supported commands.")
 		os.Exit(1)
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"github.com/pkg/errors"
+	"github.com/saenuma/flaarum/internal"
+)
+
+func setStoreMode(mode, projName, tableName string) error {
+	_, err := internal.LocalRequest("set-mode", url.Values{"mode": {mode}, "project": {projName},
+		"table": {tableName}})
+	return err
+}
+
+// withMaintenance runs fn with a project, or a table if tableName is not empty, in maintenance mode
+// so that the store does not serve it while its files are rewritten. If the store is not running,
+// fn is run all the same.
+func withMaintenance(projName, tableName string, fn func() error) error {
+	return withMode("maintenance", projName, tableName, fn)
+}
+
+// getScopedMode returns the mode set for a project, or a table if tableName is not empty. It is
+// normal mode if none was set.
+func getScopedMode(projName, tableName string) (string, error) {
+	body, err := internal.LocalRequest("get-mode", nil)
+	if err != nil {
+		return "", err
+	}
+	var modes struct {
+		Scoped map[string]string `json:"scoped"`
+	}
+	err = json.Unmarshal(body, &modes)
+	if err != nil {
+		return "", errors.Wrap(err, "json error")
+	}
+
+	scope := projName
+	if tableName != "" {
+		scope += "/" + tableName
+	}
+	if mode, ok := modes.Scoped[scope]; ok {
+		return mode, nil
+	}
+	return "normal", nil
+}
+
+// withMode runs fn with a project, or a table if tableName is not empty, in a mode and returns
+// it to the mode it was in afterwards.
+func withMode(mode, projName, tableName string, fn func() error) error {
+	previousMode, err := getScopedMode(projName, tableName)
+	if err == nil {
+		err = setStoreMode(mode, projName, tableName)
+	}
+	if err != nil {
+		fmt.Printf("Could not put the store in %s mode; continuing.\nError: %s\n", mode, err)
+		return fn()
+	}
+	defer func() {
+		scope := projName
+		if tableName != "" {
+			scope += "/" + tableName
+		}
+		err := setStoreMode(previousMode, projName, tableName)
+		if err != nil {
+			fmt.Printf("Could not return the store to %s mode. Run 'flaarum.prod mode %s %s'.\nError: %s\n",
+				previousMode, previousMode, scope, err)
+		}
+	}()
+
+	return fn()
+}
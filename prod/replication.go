@@ -0,0 +1,34 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+	"github.com/saenuma/flaarum/internal"
+	"github.com/saenuma/zazabul"
+)
+
+// promoteReplica turns the replica on this machine into a primary by emptying its 'replica_of'
+// setting. The running store stops following its old primary and starts accepting writes.
+func promoteReplica() (string, error) {
+	confPath, err := internal.GetConfigPath()
+	if err != nil {
+		return "", err
+	}
+
+	conf, err := zazabul.LoadConfigFile(confPath)
+	if err != nil {
+		return "", err
+	}
+
+	oldPrimary := conf.Get("replica_of")
+	if oldPrimary == "" {
+		return "", errors.New("this store is not a replica")
+	}
+
+	conf.Update(map[string]string{"replica_of": ""})
+	err = conf.Write(confPath)
+	if err != nil {
+		return "", err
+	}
+
+	return oldPrimary, nil
+}
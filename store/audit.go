@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/saenuma/flaarum/internal"
+)
+
+var auditMutex sync.Mutex
+
+// auditDetails are filled by the handlers while serving a mutation and written to the audit log
+// by keyEnforcementMiddleware if the mutation succeeds.
+type auditDetails struct {
+	table     string
+	statement string
+	ids       []string
+	skip      bool
+}
+
+const auditDetailsContextKey contextKey = "audit-details"
+
+func getAuditDetails(r *http.Request) *auditDetails {
+	details, ok := r.Context().Value(auditDetailsContextKey).(*auditDetails)
+	if !ok {
+		return &auditDetails{}
+	}
+	return details
+}
+
+// setAuditDetails records the table, statement and affected ids of a mutation.
+func setAuditDetails(r *http.Request, table, statement string, ids []string) {
+	details := getAuditDetails(r)
+	details.table = table
+	details.statement = statement
+	details.ids = append(details.ids, ids...)
+}
+
+// skipAudit is used by handlers of mutating routes which ended up not mutating anything.
+func skipAudit(r *http.Request) {
+	getAuditDetails(r).skip = true
+}
+
+func rowIds(rows *[]map[string]string) []string {
+	ids := make([]string, 0, len(*rows))
+	for _, row := range *rows {
+		ids = append(ids, row["id"])
+	}
+	return ids
+}
+
+func writeAuditEntry(r *http.Request, keyName string) {
+	details := getAuditDetails(r)
+	if details.skip {
+		return
+	}
+
+	action := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")[0]
+	entry := internal.AuditEntry{
+		Time:       time.Now().Format(time.RFC3339),
+		ClientAddr: r.RemoteAddr,
+		Key:        keyName,
+		Action:     action,
+		Project:    r.PathValue("proj"),
+		Table:      details.table,
+		Statement:  details.statement,
+		Ids:        details.ids,
+	}
+	if entry.Table == "" {
+		entry.Table = r.PathValue("tbl")
+	}
+
+	jsonBytes, err := json.Marshal(entry)
+	if err != nil {
+		internal.LogError("audit error", err)
+		return
+	}
+
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+
+	auditPath := internal.GetAuditLogPath()
+	if stat, err := os.Stat(auditPath); err == nil && stat.Size() >= internal.AUDIT_MAX_SIZE {
+		// the names sort in the order of rotation and are never reused
+		rotatedPath := auditPath + "." + time.Now().Format("20060102T150405.000000000")
+		for i := 1; internal.DoesPathExists(rotatedPath); i++ {
+			rotatedPath = fmt.Sprintf("%s.%s-%d", auditPath, time.Now().Format("20060102T150405.000000000"), i)
+		}
+		err = os.Rename(auditPath, rotatedPath)
+		if err != nil {
+			internal.LogError("audit error", err)
+		}
+	}
+
+	auditHandle, err := os.OpenFile(auditPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		internal.LogError("audit error", err)
+		return
+	}
+	defer auditHandle.Close()
+
+	auditHandle.Write(append(jsonBytes, '\n'))
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/saenuma/flaarum/internal"
+)
+
+// backupProject writes a consistent snapshot of a project into a backup archive. The tables are
+// read locked while the archive is written. With the 'incremental' form value set to 't', only the
+// rows changed since the project's latest backup are written.
+func backupProject(w http.ResponseWriter, r *http.Request) {
+	projName := r.PathValue("proj")
+	incremental := r.FormValue("incremental") == "t"
+
+	dataPath, _ := internal.GetRootPath()
+	if !internal.DoesPathExists(filepath.Join(dataPath, projName)) {
+		internal.PrintError(w, errors.New(fmt.Sprintf("the project '%s' does not exists.", projName)))
+		return
+	}
+
+	projsMutex.RLock()
+	defer projsMutex.RUnlock()
+
+	tables, err := internal.ListTables(projName)
+	if err != nil {
+		internal.PrintError(w, err)
+		return
+	}
+	unlock := tableLocks.RLockTables(projName, tables)
+	defer unlock()
+
+	timestamp := time.Now().Format("20060102T150405")
+	if incremental {
+		parentPath, err := internal.FindLatestBackup(projName)
+		if err != nil {
+			internal.PrintError(w, err)
+			return
+		}
+		if parentPath == "" {
+			printValError(w, errors.New(fmt.Sprintf("the project '%s' has no backup to base an incremental backup on.", projName)))
+			return
+		}
+		parent, err := internal.ReadBackupManifest(parentPath)
+		if err != nil {
+			internal.PrintError(w, err)
+			return
+		}
+
+		outPath := filepath.Join(internal.GetProjectBackupsPath(projName), fmt.Sprintf("%s-%s-incr.tar.gz", projName, timestamp))
+		_, err = internal.WriteIncrementalBackupArchive(projName, tables, filepath.Base(parentPath), parent, outPath)
+		if err != nil {
+			os.Remove(outPath)
+			internal.PrintError(w, err)
+			return
+		}
+
+		fmt.Fprint(w, outPath)
+		return
+	}
+
+	outPath := filepath.Join(internal.GetProjectBackupsPath(projName), fmt.Sprintf("%s-%s.tar.gz", projName, timestamp))
+	_, err = internal.WriteBackupArchive(projName, tables, outPath)
+	if err != nil {
+		os.Remove(outPath)
+		internal.PrintError(w, err)
+		return
+	}
+
+	fmt.Fprint(w, outPath)
+}
+
+// restoreProject restores a backup archive into the project in the path. The project must not exist.
+// The archive is given by its name in 'backup' and is looked for in the backups folder of the
+// project 'from-project', which defaults to the project in the path. An incremental backup is
+// restored by restoring the full backup it is based on and replaying every incremental backup after it.
+func restoreProject(w http.ResponseWriter, r *http.Request) {
+	projName := r.PathValue("proj")
+	fromProjName := r.FormValue("from-project")
+	if fromProjName == "" {
+		fromProjName = projName
+	}
+
+	if err := nameValidate(projName); err != nil {
+		printValError(w, err)
+		return
+	}
+
+	if isInternalProjectName(projName) {
+		printValError(w, errors.New(fmt.Sprintf("project name '%s' is used internally", projName)))
+		return
+	}
+
+	if err := nameValidate(fromProjName); err != nil {
+		printValError(w, err)
+		return
+	}
+	if !requestAllows(r, fromProjName, internal.ROLE_ADMIN) {
+		http.Error(w, "Forbidden: restoring the backups of a project needs the admin role on it", http.StatusForbidden)
+		return
+	}
+
+	archivePath, err := internal.ResolveBackupName(fromProjName, r.FormValue("backup"))
+	if err != nil {
+		printValError(w, err)
+		return
+	}
+
+	dataPath, _ := internal.GetRootPath()
+
+	projsMutex.Lock()
+	defer projsMutex.Unlock()
+
+	if internal.DoesPathExists(filepath.Join(dataPath, projName)) {
+		printValError(w, errors.New(fmt.Sprintf("the project '%s' already exists.", projName)))
+		return
+	}
+
+	chain, err := internal.GetBackupChain(archivePath)
+	if err != nil {
+		printValError(w, err)
+		return
+	}
+
+	workingPath := filepath.Join(internal.GetBackupsPath(), "restore_tmp_"+internal.UntestedRandomString(10))
+	defer os.RemoveAll(workingPath)
+
+	_, err = internal.ExtractBackupArchive(chain[0], workingPath)
+	if err != nil {
+		internal.PrintError(w, err)
+		return
+	}
+
+	projPath := filepath.Join(dataPath, projName)
+	err = os.Rename(workingPath, projPath)
+	if err != nil {
+		internal.PrintError(w, errors.Wrap(err, "rename failed."))
+		return
+	}
+
+	// replay the incremental backups. The tables are locked as the project is visible from now on.
+	tables, err := internal.ListTables(projName)
+	if err != nil {
+		os.RemoveAll(projPath)
+		internal.PrintError(w, err)
+		return
+	}
+	unlock := tableLocks.LockTables(projName, tables)
+	defer unlock()
+
+	for _, incrementalPath := range chain[1:] {
+		extractedPath := filepath.Join(internal.GetBackupsPath(), "restore_tmp_"+internal.UntestedRandomString(10))
+		manifest, err := internal.ExtractBackupArchive(incrementalPath, extractedPath)
+		if err == nil {
+			err = internal.ApplyIncrementalBackup(projName, extractedPath, manifest)
+		}
+		os.RemoveAll(extractedPath)
+		if err != nil {
+			os.RemoveAll(projPath)
+			internal.PrintError(w, errors.Wrap(err, incrementalPath))
+			return
+		}
+	}
+
+	setAuditDetails(r, "", "restore from "+archivePath, nil)
+	fmt.Fprintf(w, "ok")
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/saenuma/flaarum/internal"
+)
+
+func TestRestoreProjectByName(t *testing.T) {
+	ts := newTestStore(t)
+	mustPost(t, ts, "/create-table/first_proj", url.Values{"stmt": {"table: notes\nfields:\n  title string\n::\n"}})
+	mustPost(t, ts, "/insert-row/first_proj/notes", url.Values{"title": {"kept"}})
+
+	archivePath := mustPost(t, ts, "/backup-project/first_proj", nil)
+	if filepath.Dir(archivePath) != internal.GetProjectBackupsPath("first_proj") {
+		t.Fatalf("the backup was written to '%s'", archivePath)
+	}
+
+	mustPost(t, ts, "/restore-project/restored", url.Values{
+		"backup":       {filepath.Base(archivePath)},
+		"from-project": {"first_proj"},
+	})
+	body := mustPost(t, ts, "/search-table/restored", url.Values{"stmt": {"table: notes\nwhere:\n  title = kept"}})
+	if body == "[]" {
+		t.Error("the restored project has lost its row")
+	}
+}
+
+func TestRestoreProjectRefusesPaths(t *testing.T) {
+	ts := newTestStore(t)
+	archivePath := mustPost(t, ts, "/backup-project/first_proj", nil)
+
+	// a link in the backups folder to an archive elsewhere
+	outsidePath := filepath.Join(t.TempDir(), "outside.tar.gz")
+	raw, err := os.ReadFile(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(outsidePath, raw, 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Symlink(outsidePath, filepath.Join(internal.GetProjectBackupsPath("first_proj"), "link.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, values := range []url.Values{
+		{"backup": {archivePath}},
+		{"backup": {"../first_proj/" + filepath.Base(archivePath)}},
+		{"backup": {"link.tar.gz"}},
+		{"backup": {filepath.Base(archivePath)}, "from-project": {".."}},
+		{"backup": {"../../flaarum.zconf"}},
+	} {
+		status, body := post(t, ts, "/restore-project/restored", values)
+		if status == http.StatusOK {
+			t.Errorf("case %d: the restore of %v succeeded: %s", i, values, body)
+		}
+	}
+}
+
+func TestRestoreProjectNeedsAdminOfSource(t *testing.T) {
+	ts := newProdTestStore(t)
+	mustPost(t, ts, "/create-project/other", url.Values{"key-str": {testMasterKeyStr}})
+	archivePath := mustPost(t, ts, "/backup-project/other", url.Values{"key-str": {testMasterKeyStr}})
+
+	// the project key is an admin of 'mine' but not of 'other'
+	status, body := post(t, ts, "/restore-project/mine", url.Values{
+		"key-str":      {testProjectKeyStr},
+		"backup":       {filepath.Base(archivePath)},
+		"from-project": {"other"},
+	})
+	if status != http.StatusForbidden {
+		t.Errorf("status %d, expected %d: %s", status, http.StatusForbidden, body)
+	}
+}
+
+func TestIncrementalBackupAfterPurge(t *testing.T) {
+	ts := newTestStore(t)
+	mustPost(t, ts, "/create-table/first_proj", url.Values{"stmt": {"table: notes\nfields:\n  title string\n::\n"}})
+	mustPost(t, ts, "/set-table-options/first_proj/notes", url.Values{"soft_delete": {"true"}})
+	keptId := mustPost(t, ts, "/insert-row/first_proj/notes", url.Values{"title": {"kept"}})
+	purgedId := mustPost(t, ts, "/insert-row/first_proj/notes", url.Values{"title": {"purged"}})
+	emptiedId := mustPost(t, ts, "/insert-row/first_proj/notes", url.Values{"title": {"emptied"}})
+
+	mustPost(t, ts, "/backup-project/first_proj", nil)
+
+	mustPost(t, ts, "/delete-rows/first_proj", url.Values{"stmt": {"table: notes\nwhere:\n  id = " + purgedId}})
+	mustPost(t, ts, "/purge-trash/first_proj/notes", url.Values{"ids": {purgedId}})
+	mustPost(t, ts, "/delete-rows/first_proj", url.Values{"stmt": {"table: notes\nwhere:\n  id = " + emptiedId}})
+	mustPost(t, ts, "/purge-trash/first_proj/notes", url.Values{"all": {"t"}})
+
+	archivePath := mustPost(t, ts, "/backup-project/first_proj", url.Values{"incremental": {"t"}})
+	mustPost(t, ts, "/restore-project/restored", url.Values{
+		"backup":       {filepath.Base(archivePath)},
+		"from-project": {"first_proj"},
+	})
+
+	rows := make([]map[string]string, 0)
+	body := mustPost(t, ts, "/search-table/restored", url.Values{"stmt": {"table: notes"}})
+	err := json.Unmarshal([]byte(body), &rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0]["id"] != keptId {
+		t.Errorf("the restored table has the rows %v, expected only '%s'", rows, keptId)
+	}
+}
+
+func TestIncrementalBackupKeepsTrashAndHistory(t *testing.T) {
+	ts := newTestStore(t)
+	mustPost(t, ts, "/create-table/first_proj", url.Values{"stmt": {"table: notes\nfields:\n  title string\n::\n"}})
+	mustPost(t, ts, "/set-table-options/first_proj/notes", url.Values{"soft_delete": {"true"}, "history": {"true"}})
+	id := mustPost(t, ts, "/insert-row/first_proj/notes", url.Values{"title": {"first"}})
+
+	mustPost(t, ts, "/backup-project/first_proj", nil)
+
+	mustPost(t, ts, "/update-rows/first_proj", url.Values{
+		"stmt":   {"table: notes\nwhere:\n  id = " + id},
+		"set1_k": {"title"},
+		"set1_v": {"second"},
+	})
+	mustPost(t, ts, "/delete-rows/first_proj", url.Values{"stmt": {"table: notes\nwhere:\n  id = " + id}})
+
+	archivePath := mustPost(t, ts, "/backup-project/first_proj", url.Values{"incremental": {"t"}})
+	mustPost(t, ts, "/restore-project/restored", url.Values{
+		"backup":       {filepath.Base(archivePath)},
+		"from-project": {"first_proj"},
+	})
+
+	trash := make([]map[string]string, 0)
+	err := json.Unmarshal([]byte(mustPost(t, ts, "/list-trash/restored/notes", nil)), &trash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trash) != 1 {
+		t.Errorf("the restored trash has %v, expected the deleted row", trash)
+	}
+
+	expected := mustPost(t, ts, "/row-history/first_proj/notes/"+id, nil)
+	if history := mustPost(t, ts, "/row-history/restored/notes/"+id, nil); history != expected {
+		t.Errorf("the restored history is %s, expected %s", history, expected)
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/saenuma/flaarum/internal"
+)
+
+// changeEvent is a line of the '/changes' stream.
+type changeEvent struct {
+	Seq     int64             `json:"seq"`
+	Time    time.Time         `json:"time"`
+	Table   string            `json:"table"`
+	Op      string            `json:"op"`
+	Id      string            `json:"id"`
+	Version string            `json:"version,omitempty"`
+	Row     map[string]string `json:"row,omitempty"`
+}
+
+func newChangeEvent(entry internal.ChangeEntry, withRows bool) changeEvent {
+	event := changeEvent{Seq: entry.Seq, Time: entry.Time, Table: entry.Table, Op: entry.Op, Id: entry.Id}
+	if entry.Row != nil {
+		event.Version = entry.Row["_version"]
+		if withRows {
+			event.Row = entry.Row
+		}
+	}
+	return event
+}
+
+// changeBroker passes the changes recorded by the handlers to the subscribed '/changes' streams.
+type changeBroker struct {
+	mutex       sync.Mutex
+	subscribers map[chan internal.ChangeEntry]string // channel to project
+}
+
+// a subscriber which falls this far behind is dropped; its client can resume from the last sequence
+// numbers it got.
+const changeSubscriberBuffer = 1000
+
+var changesBroker = &changeBroker{subscribers: make(map[chan internal.ChangeEntry]string)}
+
+func (b *changeBroker) subscribe(projName string) chan internal.ChangeEntry {
+	ch := make(chan internal.ChangeEntry, changeSubscriberBuffer)
+	b.mutex.Lock()
+	b.subscribers[ch] = projName
+	b.mutex.Unlock()
+	return ch
+}
+
+func (b *changeBroker) unsubscribe(ch chan internal.ChangeEntry) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if _, ok := b.subscribers[ch]; ok {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+func (b *changeBroker) publish(projName string, entry internal.ChangeEntry) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for ch, subProjName := range b.subscribers {
+		if subProjName != projName {
+			continue
+		}
+		select {
+		case ch <- entry:
+		default:
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// rowChange is a change to a row which is recorded once the write making it has succeeded.
+type rowChange struct {
+	op  string
+	id  string
+	row map[string]string
+}
+
+// recordChange adds a row change to the change log of a table and passes it to the subscribed
+// streams and the webhooks of the table. The caller must hold the table's write lock and call it
+// after the change is written.
+func recordChange(projName, tableName, op, rowId string, row map[string]string) error {
+	return recordChanges(projName, tableName, []rowChange{{op, rowId, row}})
+}
+
+// recordChanges is recordChange for the changes of a write to many rows. Nothing is published
+// before all of them are in the change log.
+func recordChanges(projName, tableName string, changes []rowChange) error {
+	entries := make([]internal.ChangeEntry, 0, len(changes))
+	for _, change := range changes {
+		entry, err := internal.RecordChange(projName, tableName, change.op, change.id, change.row)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+	}
+
+	for _, entry := range entries {
+		changesBroker.publish(projName, entry)
+		queueWebhooks(projName, entry)
+	}
+	return nil
+}
+
+// parseSinceForm parses the 'since' form value of '/changes'. It is either a sequence number for
+// all the tables or a list of 'table:seq' pairs separated by commas.
+func parseSinceForm(since string, tables []string) (map[string]int64, error) {
+	ret := make(map[string]int64)
+	for _, tableName := range tables {
+		ret[tableName] = 0
+	}
+
+	if strings.TrimSpace(since) == "" {
+		return ret, nil
+	}
+
+	if seq, err := strconv.ParseInt(strings.TrimSpace(since), 10, 64); err == nil {
+		for _, tableName := range tables {
+			ret[tableName] = seq
+		}
+		return ret, nil
+	}
+
+	for _, part := range strings.Split(since, ",") {
+		tableName, seqStr, found := strings.Cut(strings.TrimSpace(part), ":")
+		seq, err := strconv.ParseInt(seqStr, 10, 64)
+		if !found || err != nil {
+			return nil, errors.New(fmt.Sprintf("'%s' in 'since' is not of the form 'table:seq'", part))
+		}
+		if _, ok := ret[tableName]; ok {
+			ret[tableName] = seq
+		}
+	}
+
+	return ret, nil
+}
+
+// streamChanges writes the changes to the rows of a project as newline delimited JSON until the
+// client disconnects. It starts with the recorded changes after the sequence numbers in 'since'
+// and continues with new changes. Without 'since' only new changes are sent. Set 'tables' to a comma separated list to
+// follow only some tables and 'with-rows' to 't' to get the data of inserted and updated rows.
+// Blank lines are sent as keep-alives. With 'follow' set to 'f', the stream ends after the recorded
+// changes.
+func streamChanges(w http.ResponseWriter, r *http.Request) {
+	projName := r.PathValue("proj")
+	withRows := r.FormValue("with-rows") == "t"
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		internal.PrintError(w, errors.New("streaming is not supported"))
+		return
+	}
+
+	tables, err := internal.ListTables(projName)
+	if err != nil {
+		internal.PrintError(w, err)
+		return
+	}
+	followAll := r.FormValue("tables") == ""
+	if !followAll {
+		wanted := strings.Split(r.FormValue("tables"), ",")
+		for _, tableName := range wanted {
+			if !slices.Contains(tables, tableName) {
+				printValError(w, errors.New(fmt.Sprintf("Table '%s' of Project '%s' does not exists.", tableName, projName)))
+				return
+			}
+		}
+		tables = wanted
+	}
+
+	lastSeqs, err := parseSinceForm(r.FormValue("since"), tables)
+	if err != nil {
+		printValError(w, err)
+		return
+	}
+	if r.FormValue("since") != "" {
+		for _, tableName := range tables {
+			if trimmedSeq := internal.GetTrimmedSeq(projName, tableName); lastSeqs[tableName] < trimmedSeq {
+				printValError(w, errors.New(fmt.Sprintf("the changes of table '%s' up to %d were removed from its change log",
+					tableName, trimmedSeq)))
+				return
+			}
+		}
+	}
+
+	// subscribe before reading the recorded changes so that nothing is missed in between.
+	ch := changesBroker.subscribe(projName)
+	defer changesBroker.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	writeEntry := func(entry internal.ChangeEntry) bool {
+		lastSeq, followed := lastSeqs[entry.Table]
+		if (!followed && !followAll) || entry.Seq <= lastSeq {
+			return true
+		}
+
+		jsonBytes, err := json.Marshal(newChangeEvent(entry, withRows))
+		if err != nil {
+			return false
+		}
+		_, err = w.Write(append(jsonBytes, '\n'))
+		if err != nil {
+			return false
+		}
+		lastSeqs[entry.Table] = entry.Seq
+		return true
+	}
+
+	fromNow := r.FormValue("since") == ""
+	for _, tableName := range tables {
+		tableLocks.RLock(projName, tableName)
+		var changes []internal.ChangeEntry
+		if fromNow {
+			lastSeqs[tableName] = internal.GetLastSeq(projName, tableName)
+		} else {
+			changes, err = internal.ReadChangesSince(projName, tableName, lastSeqs[tableName])
+		}
+		tableLocks.RUnlock(projName, tableName)
+		if err != nil {
+			internal.LogError("change stream error", err, "request_id", w.Header().Get(internal.REQUEST_ID_HEADER))
+			return
+		}
+
+		for _, entry := range changes {
+			if !writeEntry(entry) {
+				return
+			}
+		}
+	}
+	flusher.Flush()
+
+	if r.FormValue("follow") == "f" {
+		return
+	}
+
+	keepAlive := time.NewTicker(30 * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-shutdownCh:
+			return
+		case entry, ok := <-ch:
+			if !ok {
+				// the stream fell behind and was dropped by the broker
+				return
+			}
+			if !writeEntry(entry) {
+				return
+			}
+			flusher.Flush()
+		case <-keepAlive.C:
+			if _, err := w.Write([]byte("\n")); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
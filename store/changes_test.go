@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readChangeEvents parses the events of a change stream, skipping the keep-alives.
+func readChangeEvents(t *testing.T, body string) []changeEvent {
+	t.Helper()
+
+	var events []changeEvent
+	for _, line := range strings.Split(body, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var event changeEvent
+		err := json.Unmarshal([]byte(line), &event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestChangesResume(t *testing.T) {
+	ts := newTestStore(t)
+	for _, tableName := range []string{"notes", "tags"} {
+		mustPost(t, ts, "/create-table/first_proj", url.Values{"stmt": {"table: " + tableName + "\nfields:\n  title string\n::\n"}})
+	}
+	noteIds := []string{}
+	for _, title := range []string{"a", "b", "c"} {
+		noteIds = append(noteIds, mustPost(t, ts, "/insert-row/first_proj/notes", url.Values{"title": {title}}))
+	}
+	mustPost(t, ts, "/insert-row/first_proj/tags", url.Values{"title": {"go"}})
+	mustPost(t, ts, "/delete-rows/first_proj", url.Values{"stmt": {"table: notes\nwhere:\n  id = " + noteIds[0]}})
+
+	// a sequence number for all the tables
+	events := readChangeEvents(t, mustPost(t, ts, "/changes/first_proj", url.Values{"since": {"1"}, "follow": {"f"}}))
+	got := []string{}
+	for _, event := range events {
+		got = append(got, event.Table+":"+event.Op+":"+event.Id)
+	}
+	expected := []string{"notes:insert:" + noteIds[1], "notes:insert:" + noteIds[2], "notes:delete:" + noteIds[0]}
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("the stream sent %v, expected %v", got, expected)
+	}
+
+	// a sequence number for each table, with the rows
+	body := mustPost(t, ts, "/changes/first_proj", url.Values{"since": {"notes:3,tags:0"}, "follow": {"f"}, "with-rows": {"t"}})
+	byTable := make(map[string]changeEvent)
+	for _, event := range readChangeEvents(t, body) {
+		byTable[event.Table] = event
+	}
+	if len(byTable) != 2 || byTable["tags"].Row["title"] != "go" || byTable["notes"].Op != "delete" ||
+		byTable["notes"].Seq != 4 {
+		t.Errorf("the stream sent %q", body)
+	}
+
+	// only some tables
+	events = readChangeEvents(t, mustPost(t, ts, "/changes/first_proj", url.Values{"since": {"0"}, "follow": {"f"}, "tables": {"tags"}}))
+	if len(events) != 1 || events[0].Table != "tags" || events[0].Row != nil {
+		t.Errorf("the stream of 'tags' sent %v", events)
+	}
+
+	for _, since := range []string{"notes", "notes:x"} {
+		status, _ := post(t, ts, "/changes/first_proj", url.Values{"since": {since}, "follow": {"f"}})
+		if status != http.StatusBadRequest {
+			t.Errorf("'since' of '%s' got status %d, expected %d", since, status, http.StatusBadRequest)
+		}
+	}
+	status, _ := post(t, ts, "/changes/first_proj", url.Values{"tables": {"missing"}, "follow": {"f"}})
+	if status != http.StatusBadRequest {
+		t.Errorf("a stream of a missing table got status %d", status)
+	}
+}
+
+func TestChangesFollow(t *testing.T) {
+	ts := newTestStore(t)
+	mustPost(t, ts, "/create-table/first_proj", url.Values{"stmt": {"table: notes\nfields:\n  title string\n::\n"}})
+	mustPost(t, ts, "/insert-row/first_proj/notes", url.Values{"title": {"before"}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ts.URL+"/changes/first_proj",
+		strings.NewReader(url.Values{"since": {"0"}}.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	lines := make(chan string, 10)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if scanner.Text() != "" {
+				lines <- scanner.Text()
+			}
+		}
+		close(lines)
+	}()
+
+	nextEvent := func() changeEvent {
+		t.Helper()
+		select {
+		case line := <-lines:
+			return readChangeEvents(t, line)[0]
+		case <-time.After(5 * time.Second):
+			t.Fatal("no change was streamed")
+		}
+		return changeEvent{}
+	}
+
+	if event := nextEvent(); event.Seq != 1 {
+		t.Errorf("the recorded change has seq %d, expected 1", event.Seq)
+	}
+	id := mustPost(t, ts, "/insert-row/first_proj/notes", url.Values{"title": {"after"}})
+	if event := nextEvent(); event.Seq != 2 || event.Id != id || event.Op != "insert" {
+		t.Errorf("the new change is %+v", event)
+	}
+}
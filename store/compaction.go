@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/saenuma/flaarum/internal"
+)
+
+const (
+	defaultCompactionInterval  = 60 // minutes
+	defaultCompactionThreshold = 0.5
+	compactionMinDeadBytes     = 1024 * 1024 // tables with less dead space are not worth a trim
+)
+
+// compactionTableStats is what the compactor knows of a table since the store started.
+type compactionTableStats struct {
+	LastCheck      time.Time            `json:"last_check"`
+	LastCompaction time.Time            `json:"last_compaction,omitempty"`
+	Compactions    int                  `json:"compactions"`
+	ReclaimedBytes int64                `json:"reclaimed_bytes"`
+	DeadBytes      int64                `json:"dead_bytes"`
+	DeadRatio      float64              `json:"dead_ratio"`
+	RemovedChanges int                  `json:"removed_changes"`
+	Files          []internal.FileSpace `json:"files"`
+	LastError      string               `json:"last_error,omitempty"`
+}
+
+type compactionStats struct {
+	Interval  int                              `json:"interval"`
+	Threshold float64                          `json:"threshold"`
+	Running   bool                             `json:"running"`
+	Runs      int                              `json:"runs"`
+	LastRun   time.Time                        `json:"last_run,omitempty"`
+	Tables    map[string]*compactionTableStats `json:"tables"`
+}
+
+var (
+	compactorMutex sync.Mutex
+	compactor      = compactionStats{Tables: make(map[string]*compactionTableStats)}
+)
+
+// getCompactionInterval returns the 'compaction_interval' setting in minutes. 0 turns the
+// compactor off.
+func getCompactionInterval() int {
+	interval, err := strconv.Atoi(internal.GetSetting("compaction_interval"))
+	if err != nil || interval < 0 {
+		return defaultCompactionInterval
+	}
+	return interval
+}
+
+// getCompactionThreshold returns the 'compaction_threshold' setting, the dead bytes to file size
+// ratio above which a table is compacted.
+func getCompactionThreshold() float64 {
+	threshold, err := strconv.ParseFloat(internal.GetSetting("compaction_threshold"), 64)
+	if err != nil || threshold <= 0 || threshold >= 1 {
+		return defaultCompactionThreshold
+	}
+	return threshold
+}
+
+// startCompactor starts the goroutine which compacts the tables on the schedule set by
+// 'compaction_interval'.
+func startCompactor() {
+	go func() {
+		for {
+			interval := getCompactionInterval()
+			if interval == 0 {
+				time.Sleep(time.Minute)
+				continue
+			}
+
+			time.Sleep(time.Duration(interval) * time.Minute)
+			runCompaction()
+		}
+	}()
+}
+
+// runCompaction removes the expired changes from the change logs and trims every table whose dead
+// bytes are above the threshold. Tables are trimmed one after the other with rebuildTableLive, so
+// they stay in use.
+func runCompaction() {
+	if getGlobalMode() != MODE_NORMAL {
+		return
+	}
+
+	threshold := getCompactionThreshold()
+	compactorMutex.Lock()
+	if compactor.Running {
+		compactorMutex.Unlock()
+		return
+	}
+	compactor.Running = true
+	compactor.Threshold = threshold
+	compactorMutex.Unlock()
+
+	defer func() {
+		compactorMutex.Lock()
+		compactor.Running = false
+		compactor.Runs += 1
+		compactor.LastRun = time.Now()
+		compactorMutex.Unlock()
+	}()
+
+	projsMutex.RLock()
+	projs, err := listReplicatedProjects()
+	tablesOfProjs := make(map[string][]string)
+	if err == nil {
+		for _, projName := range projs {
+			tables, err := internal.ListTables(projName)
+			if err != nil {
+				continue
+			}
+			tablesOfProjs[projName] = tables
+		}
+	}
+	projsMutex.RUnlock()
+	if err != nil {
+		internal.LogError("compaction error", err)
+		return
+	}
+
+	for projName, tables := range tablesOfProjs {
+		for _, tableName := range tables {
+			// counted as a write, so that '/set-mode' waits for it
+			doneWrite := startWrite()
+			if !refusesWrites(projName, tableName) {
+				compactTableIfNecessary(projName, tableName, threshold)
+			}
+			doneWrite()
+		}
+	}
+}
+
+func compactTableIfNecessary(projName, tableName string, threshold float64) {
+	stats := &compactionTableStats{}
+	compactorMutex.Lock()
+	if old, ok := compactor.Tables[projName+"/"+tableName]; ok {
+		stats.LastCompaction = old.LastCompaction
+		stats.Compactions = old.Compactions
+		stats.ReclaimedBytes = old.ReclaimedBytes
+		stats.RemovedChanges = old.RemovedChanges
+	}
+	compactorMutex.Unlock()
+
+	defer func() {
+		compactorMutex.Lock()
+		compactor.Tables[projName+"/"+tableName] = stats
+		compactorMutex.Unlock()
+	}()
+
+	sizeOf := func() (int64, int64, []internal.FileSpace, error) {
+		tableLocks.RLock(projName, tableName)
+		defer tableLocks.RUnlock(projName, tableName)
+
+		files, err := internal.GetTableSpace(projName, tableName)
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		var size, dead int64
+		for _, file := range files {
+			size += file.Size
+			dead += file.DeadBytes
+		}
+		return size, dead, files, nil
+	}
+
+	stats.LastCheck = time.Now()
+	if cutoff, ok := internal.ChangeLogCutoff(); ok {
+		tableLocks.Lock(projName, tableName)
+		removed, err := internal.CompactChangeLog(projName, tableName, cutoff)
+		tableLocks.Unlock(projName, tableName)
+		if err != nil {
+			stats.LastError = err.Error()
+			return
+		}
+		stats.RemovedChanges += removed
+	}
+
+	size, dead, files, err := sizeOf()
+	if err != nil {
+		stats.LastError = err.Error()
+		return
+	}
+	stats.Files = files
+	stats.DeadBytes = dead
+	if size > 0 {
+		stats.DeadRatio = float64(dead) / float64(size)
+	}
+
+	if dead < compactionMinDeadBytes || stats.DeadRatio < threshold {
+		return
+	}
+
+	err = rebuildTableLive(projName, tableName, internal.TrimTableFiles, true)
+	if err != nil {
+		stats.LastError = err.Error()
+		return
+	}
+
+	newSize, newDead, newFiles, err := sizeOf()
+	if err != nil {
+		stats.LastError = err.Error()
+		return
+	}
+	stats.LastCompaction = time.Now()
+	stats.Compactions += 1
+	stats.ReclaimedBytes += max(size-newSize, 0)
+	stats.Files = newFiles
+	stats.DeadBytes = newDead
+	stats.DeadRatio = 0
+	if newSize > 0 {
+		stats.DeadRatio = float64(newDead) / float64(newSize)
+	}
+}
+
+func compactionStatsHTTP(w http.ResponseWriter, r *http.Request) {
+	compactorMutex.Lock()
+	compactor.Interval = getCompactionInterval()
+	compactor.Threshold = getCompactionThreshold()
+	jsonBytes, err := json.Marshal(compactor)
+	compactorMutex.Unlock()
+	if err != nil {
+		internal.PrintError(w, errors.Wrap(err, "json error"))
+		return
+	}
+
+	w.Write(jsonBytes)
+}
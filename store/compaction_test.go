@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saenuma/flaarum/internal"
+)
+
+func TestCompactionStatsNeedsAllProjects(t *testing.T) {
+	checkKeyScopes(t, "/compaction-stats")
+}
+
+func TestCompactChangeLog(t *testing.T) {
+	ts := newTestStore(t)
+	mustPost(t, ts, "/create-table/first_proj", url.Values{"stmt": {"table: notes\nfields:\n  title string\n::\n"}})
+
+	mustPost(t, ts, "/insert-row/first_proj/notes", url.Values{"title": {"a"}})
+	mustPost(t, ts, "/insert-row/first_proj/notes", url.Values{"title": {"b"}})
+	time.Sleep(10 * time.Millisecond)
+	cutoff := time.Now()
+	mustPost(t, ts, "/insert-row/first_proj/notes", url.Values{"title": {"c"}})
+
+	removed, err := internal.CompactChangeLog("first_proj", "notes", cutoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 2 {
+		t.Errorf("removed %d changes, expected 2", removed)
+	}
+	if trimmedSeq := internal.GetTrimmedSeq("first_proj", "notes"); trimmedSeq != 2 {
+		t.Errorf("the trimmed sequence number is %d, expected 2", trimmedSeq)
+	}
+
+	// the changes recorded after a compaction are appended to the rewritten log
+	mustPost(t, ts, "/insert-row/first_proj/notes", url.Values{"title": {"d"}})
+	changes, err := internal.ReadChangesSince("first_proj", "notes", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 || changes[0].Seq != 3 || changes[0].Row["title"] != "c" ||
+		changes[1].Seq != 4 || changes[1].Row["title"] != "d" {
+		t.Errorf("the change log has %v", changes)
+	}
+
+	status, _ := post(t, ts, "/changes/first_proj", url.Values{"since": {"1"}, "follow": {"f"}})
+	if status == http.StatusOK {
+		t.Error("a stream from a removed change was started")
+	}
+	body := mustPost(t, ts, "/changes/first_proj", url.Values{"since": {"2"}, "follow": {"f"}})
+	if lines := strings.Split(strings.TrimSpace(body), "\n"); len(lines) != 2 {
+		t.Errorf("the stream sent %q", body)
+	}
+}
+
+func TestCompactionSkipsTablesNotInNormalMode(t *testing.T) {
+	ts := newTestStore(t)
+	for _, tableName := range []string{"checked", "repaired", "free"} {
+		mustPost(t, ts, "/create-table/first_proj", url.Values{"stmt": {"table: " + tableName + "\nfields:\n  title string\n::\n"}})
+	}
+	mustPost(t, ts, "/set-mode", url.Values{"mode": {MODE_READ_ONLY}, "project": {"first_proj"}, "table": {"checked"}})
+	mustPost(t, ts, "/set-mode", url.Values{"mode": {MODE_MAINTENANCE}, "project": {"first_proj"}, "table": {"repaired"}})
+	t.Cleanup(func() {
+		scopedModesMutex.Lock()
+		clear(scopedModes)
+		scopedModesMutex.Unlock()
+	})
+
+	compactorMutex.Lock()
+	clear(compactor.Tables)
+	compactorMutex.Unlock()
+
+	runCompaction()
+
+	compactorMutex.Lock()
+	defer compactorMutex.Unlock()
+	for _, tableName := range []string{"checked", "repaired"} {
+		if _, ok := compactor.Tables["first_proj/"+tableName]; ok {
+			t.Errorf("the table '%s' was compacted while not in normal mode", tableName)
+		}
+	}
+	if _, ok := compactor.Tables["first_proj/free"]; !ok {
+		t.Error("the table in normal mode was not checked")
+	}
+}
@@ -27,12 +27,6 @@ func deleteRows(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rows, err := innerSearch(projName, stmt)
-	if err != nil {
-		internal.PrintError(w, err)
-		return
-	}
-
 	existingTables, err := internal.ListTables(projName)
 	if err != nil {
 		internal.PrintError(w, err)
@@ -54,6 +48,22 @@ func deleteRows(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// the table and the tables pointing to it are locked together, so that no row pointing to a
+	// deleted row can be written while the rows are deleted
+	toLock := []string{tableName}
+	for otherTbl := range relatedRelationshipDetails {
+		toLock = append(toLock, otherTbl)
+	}
+	unlock := tableLocks.LockTables(projName, toLock)
+	defer unlock()
+
+	rows, scanned, err := innerSearchNoLock(projName, stmt)
+	setRowsScanned(r, scanned)
+	if err != nil {
+		internal.PrintError(w, err)
+		return
+	}
+
 	for _, row := range *rows {
 		for otherTbl, fkd := range relatedRelationshipDetails {
 			innerStmt := fmt.Sprintf(`
@@ -62,7 +72,7 @@ func deleteRows(w http.ResponseWriter, r *http.Request) {
           %s = %s
         `, otherTbl, fkd.FieldName, row["id"])
 
-			toCheckRows, err := innerSearch(projName, innerStmt)
+			toCheckRows, _, err := innerSearchNoLock(projName, innerStmt)
 			if err != nil {
 				internal.PrintError(w, err)
 				return
@@ -76,32 +86,24 @@ func deleteRows(w http.ResponseWriter, r *http.Request) {
 				}
 
 			} else if fkd.OnDelete == "on_delete_delete" {
-				otherTblFullName := projName + ":" + otherTbl
-				tablesMutexes[otherTblFullName].Lock()
-
 				err := innerDelete(projName, otherTbl, toCheckRows)
 				if err != nil {
 					internal.PrintError(w, err)
-					tablesMutexes[otherTblFullName].Unlock()
 					return
 				}
-				tablesMutexes[otherTblFullName].Unlock()
 
 			}
 
 		}
 	}
 
-	fullTableName := projName + ":" + tableName
-	tablesMutexes[fullTableName].Lock()
-	defer tablesMutexes[fullTableName].Unlock()
-
 	err = innerDelete(projName, tableName, rows)
 	if err != nil {
 		internal.PrintError(w, err)
 		return
 	}
 
+	setAuditDetails(r, tableName, stmt, rowIds(rows))
 	fmt.Fprintf(w, "ok")
 }
 
@@ -114,7 +116,22 @@ func innerDelete(projName, tableName string, rows *[]map[string]string) error {
 		return err
 	}
 
+	softDelete := internal.IsSoftDeleteOn(projName, tableName)
+
+	changes := make([]rowChange, 0, len(*rows))
 	for _, row := range *rows {
+		err = internal.RecordRowHistory(projName, tableName, row["id"], internal.HISTORY_DELETE, row)
+		if err != nil {
+			return err
+		}
+
+		if softDelete {
+			err = internal.MoveRowToTrash(projName, tableName, row)
+			if err != nil {
+				return err
+			}
+		}
+
 		// write null data to flaa2 file
 		tablePath := internal.GetTablePath(projName, tableName)
 
@@ -146,6 +163,8 @@ func innerDelete(projName, tableName string, rows *[]map[string]string) error {
 			}
 		}
 		delete(elemsMap, row["id"])
+
+		changes = append(changes, rowChange{internal.HISTORY_DELETE, row["id"], nil})
 	}
 
 	// rewrite index
@@ -154,5 +173,5 @@ func innerDelete(projName, tableName string, rows *[]map[string]string) error {
 		return err
 	}
 
-	return nil
+	return recordChanges(projName, tableName, changes)
 }
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"slices"
+
+	"github.com/pkg/errors"
+	"github.com/saenuma/flaarum/internal"
+	"github.com/saenuma/flaarumlib"
+)
+
+type utsDryRunReport struct {
+	TableName            string         `json:"table"`
+	CurrentVersion       int            `json:"current_version"`
+	Changed              bool           `json:"changed"`
+	AddedFields          []string       `json:"added_fields"`
+	RemovedFields        []string       `json:"removed_fields"`
+	ChangedFields        []string       `json:"changed_fields"`
+	AddedForeignKeys     []string       `json:"added_foreign_keys"`
+	RemovedForeignKeys   []string       `json:"removed_foreign_keys"`
+	RequiredViolations   map[string]int `json:"required_violations"`
+	UniqueViolations     map[string]int `json:"unique_violations"`
+	ForeignKeyViolations map[string]int `json:"foreign_key_violations"`
+	IndexesToCreate      []string       `json:"indexes_to_create"`
+	IndexesToDrop        []string       `json:"indexes_to_drop"`
+}
+
+// indexedFieldNames returns the names of the indexes a table structure would produce. This includes
+// the derived fields of date and datetime fields.
+func indexedFieldNames(tableStruct flaarumlib.TableStruct) []string {
+	ret := []string{"_version"}
+	for _, fd := range tableStruct.Fields {
+		if fd.NotIndexed || fd.FieldType == "text" {
+			continue
+		}
+		ret = append(ret, fd.FieldName)
+
+		if fd.FieldType == "date" || fd.FieldType == "datetime" {
+			ret = append(ret, fd.FieldName+"_year", fd.FieldName+"_month", fd.FieldName+"_day")
+		}
+		if fd.FieldType == "datetime" {
+			ret = append(ret, fd.FieldName+"_hour", fd.FieldName+"_date", fd.FieldName+"_tzname")
+		}
+	}
+
+	return ret
+}
+
+func describeFieldChanges(oldFd, newFd flaarumlib.FieldStruct) []string {
+	ret := make([]string, 0)
+	if oldFd.FieldType != newFd.FieldType {
+		ret = append(ret, fmt.Sprintf("%s: type %s -> %s", newFd.FieldName, oldFd.FieldType, newFd.FieldType))
+	}
+	if oldFd.Required != newFd.Required {
+		ret = append(ret, fmt.Sprintf("%s: required %t -> %t", newFd.FieldName, oldFd.Required, newFd.Required))
+	}
+	if oldFd.Unique != newFd.Unique {
+		ret = append(ret, fmt.Sprintf("%s: unique %t -> %t", newFd.FieldName, oldFd.Unique, newFd.Unique))
+	}
+	if oldFd.NotIndexed != newFd.NotIndexed {
+		ret = append(ret, fmt.Sprintf("%s: nindex %t -> %t", newFd.FieldName, oldFd.NotIndexed, newFd.NotIndexed))
+	}
+	return ret
+}
+
+func describeFKey(fkd flaarumlib.FKeyStruct) string {
+	return fmt.Sprintf("%s %s %s", fkd.FieldName, fkd.PointedTable, fkd.OnDelete)
+}
+
+// dryRunTableStructure computes how changing a table to newStruct would affect the data
+// already stored in it. It does not write anything.
+func dryRunTableStructure(projName string, newStruct flaarumlib.TableStruct) (utsDryRunReport, error) {
+	tableName := newStruct.TableName
+	report := utsDryRunReport{
+		TableName:            tableName,
+		AddedFields:          make([]string, 0),
+		RemovedFields:        make([]string, 0),
+		ChangedFields:        make([]string, 0),
+		AddedForeignKeys:     make([]string, 0),
+		RemovedForeignKeys:   make([]string, 0),
+		RequiredViolations:   make(map[string]int),
+		UniqueViolations:     make(map[string]int),
+		ForeignKeyViolations: make(map[string]int),
+		IndexesToCreate:      make([]string, 0),
+		IndexesToDrop:        make([]string, 0),
+	}
+
+	currentVersionNum, err := getCurrentVersionNum(projName, tableName)
+	if err != nil {
+		return report, err
+	}
+	report.CurrentVersion = currentVersionNum
+
+	oldStruct, err := getTableStructureParsed(projName, tableName, currentVersionNum)
+	if err != nil {
+		return report, err
+	}
+	report.Changed = flaarumlib.FormatTableStruct(oldStruct) != flaarumlib.FormatTableStruct(newStruct)
+
+	oldFields := make(map[string]flaarumlib.FieldStruct)
+	for _, fd := range oldStruct.Fields {
+		oldFields[fd.FieldName] = fd
+	}
+	newFields := make(map[string]flaarumlib.FieldStruct)
+	for _, fd := range newStruct.Fields {
+		newFields[fd.FieldName] = fd
+		oldFd, ok := oldFields[fd.FieldName]
+		if !ok {
+			report.AddedFields = append(report.AddedFields, fd.FieldName)
+		} else {
+			report.ChangedFields = append(report.ChangedFields, describeFieldChanges(oldFd, fd)...)
+		}
+	}
+	for _, fd := range oldStruct.Fields {
+		if _, ok := newFields[fd.FieldName]; !ok {
+			report.RemovedFields = append(report.RemovedFields, fd.FieldName)
+		}
+	}
+
+	oldFKeys := make(map[string]flaarumlib.FKeyStruct)
+	for _, fkd := range oldStruct.ForeignKeys {
+		oldFKeys[fkd.FieldName] = fkd
+	}
+	newFKeys := make([]flaarumlib.FKeyStruct, 0)
+	newFKeyFields := make([]string, 0)
+	for _, fkd := range newStruct.ForeignKeys {
+		newFKeyFields = append(newFKeyFields, fkd.FieldName)
+		oldFkd, ok := oldFKeys[fkd.FieldName]
+		if !ok || oldFkd != fkd {
+			report.AddedForeignKeys = append(report.AddedForeignKeys, describeFKey(fkd))
+		}
+		if !ok || oldFkd.PointedTable != fkd.PointedTable {
+			newFKeys = append(newFKeys, fkd)
+		}
+	}
+	for _, fkd := range oldStruct.ForeignKeys {
+		if !slices.Contains(newFKeyFields, fkd.FieldName) {
+			report.RemovedForeignKeys = append(report.RemovedForeignKeys, describeFKey(fkd))
+		}
+	}
+
+	oldIndexes := indexedFieldNames(oldStruct)
+	newIndexes := indexedFieldNames(newStruct)
+	for _, idx := range newIndexes {
+		if !slices.Contains(oldIndexes, idx) {
+			report.IndexesToCreate = append(report.IndexesToCreate, idx)
+		}
+	}
+	for _, idx := range oldIndexes {
+		if !slices.Contains(newIndexes, idx) {
+			report.IndexesToDrop = append(report.IndexesToDrop, idx)
+		}
+	}
+
+	// count the rows that would violate the new constraints
+	rows, err := innerSearch(projName, fmt.Sprintf("table: %s", tableName))
+	if err != nil {
+		return report, err
+	}
+
+	for _, fd := range newStruct.Fields {
+		oldFd, existed := oldFields[fd.FieldName]
+
+		if fd.Required && (!existed || !oldFd.Required) {
+			count := 0
+			for _, row := range *rows {
+				if row[fd.FieldName] == "" {
+					count += 1
+				}
+			}
+			report.RequiredViolations[fd.FieldName] = count
+		}
+
+		if fd.Unique && (!existed || !oldFd.Unique) {
+			seen := make(map[string]int)
+			for _, row := range *rows {
+				if v := row[fd.FieldName]; v != "" {
+					seen[v] += 1
+				}
+			}
+			count := 0
+			for _, c := range seen {
+				if c > 1 {
+					count += c
+				}
+			}
+			report.UniqueViolations[fd.FieldName] = count
+		}
+	}
+
+	dataPath, _ := internal.GetRootPath()
+	for _, fkd := range newFKeys {
+		pointedElems := make(map[string]internal.DataF1Elem)
+		pointedF1Path := filepath.Join(dataPath, projName, fkd.PointedTable, "data.flaa1")
+		if internal.DoesPathExists(pointedF1Path) {
+			pointedElems, err = internal.ParseDataF1File(pointedF1Path)
+			if err != nil {
+				return report, err
+			}
+		}
+
+		count := 0
+		for _, row := range *rows {
+			v := row[fkd.FieldName]
+			if v == "" {
+				continue
+			}
+			if _, ok := pointedElems[v]; !ok {
+				count += 1
+			}
+		}
+		report.ForeignKeyViolations[fkd.FieldName] = count
+	}
+
+	return report, nil
+}
+
+func dryRunTableStructureHTTP(w http.ResponseWriter, projName string, tableStruct flaarumlib.TableStruct) {
+	report, err := dryRunTableStructure(projName, tableStruct)
+	if err != nil {
+		internal.PrintError(w, err)
+		return
+	}
+
+	jsonBytes, err := json.Marshal(report)
+	if err != nil {
+		internal.PrintError(w, errors.Wrap(err, "json error"))
+		return
+	}
+
+	w.Write(jsonBytes)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/saenuma/flaarum/internal"
+)
+
+func TestDryRunLeavesStructureUnchanged(t *testing.T) {
+	ts := newTestStore(t)
+	mustPost(t, ts, "/create-table/first_proj", url.Values{"stmt": {"table: users\nfields:\n  email string\n  name string\n::\n"}})
+	mustPost(t, ts, "/insert-row/first_proj/users", url.Values{"email": {"a@example.com"}, "name": {"a"}})
+	mustPost(t, ts, "/insert-row/first_proj/users", url.Values{"email": {"a@example.com"}})
+
+	tablePath := internal.GetTablePath("first_proj", "users")
+	filesBefore, err := os.ReadDir(tablePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	structureBefore, err := os.ReadFile(filepath.Join(tablePath, "structure1.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := mustPost(t, ts, "/update-table-structure/first_proj", url.Values{
+		"dry-run": {"t"},
+		"stmt":    {"table: users\nfields:\n  email string required unique\n  age int\n::\n"},
+	})
+	var report utsDryRunReport
+	err = json.Unmarshal([]byte(body), &report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Changed || !slices.Contains(report.AddedFields, "age") || !slices.Contains(report.RemovedFields, "name") {
+		t.Errorf("the report is %s", body)
+	}
+	// both rows have the same email
+	if report.UniqueViolations["email"] != 2 {
+		t.Errorf("the report has the unique violations %v, expected 2 rows for 'email'", report.UniqueViolations)
+	}
+
+	filesAfter, err := os.ReadDir(tablePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filesAfter) != len(filesBefore) {
+		t.Errorf("the table had %d files before the dry run and %d after", len(filesBefore), len(filesAfter))
+	}
+	structureAfter, err := os.ReadFile(filepath.Join(tablePath, "structure1.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(structureAfter) != string(structureBefore) {
+		t.Error("the dry run rewrote the structure")
+	}
+	if vnum := mustPost(t, ts, "/get-current-version-num/first_proj/users", nil); vnum != "1" {
+		t.Errorf("the version after the dry run is %s", vnum)
+	}
+}
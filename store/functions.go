@@ -28,7 +28,8 @@ func countRows(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rows, err := innerSearch(projName, stmt)
+	rows, scanned, err := innerSearchScanned(projName, stmt)
+	setRowsScanned(r, scanned)
 	if err != nil {
 		internal.PrintError(w, err)
 		return
@@ -43,10 +44,8 @@ func allRowsCount(w http.ResponseWriter, r *http.Request) {
 	dataPath, _ := internal.GetRootPath()
 	tablePath := filepath.Join(dataPath, projName, tableName)
 
-	createTableMutexIfNecessary(projName, tableName)
-	fullTableName := projName + ":" + tableName
-	tablesMutexes[fullTableName].RLock()
-	defer tablesMutexes[fullTableName].RUnlock()
+	tableLocks.RLock(projName, tableName)
+	defer tableLocks.RUnlock(projName, tableName)
 
 	dataF1Path := filepath.Join(tablePath, "data.flaa1")
 	elemsMap, err := internal.ParseDataF1File(dataF1Path)
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/saenuma/flaarum/internal"
+	"github.com/saenuma/zazabul"
+)
+
+var webhookWorkersOnce sync.Once
+
+// newTestStore starts the store's endpoints on a test server with a fresh data folder holding the
+// default config and the project 'first_proj'.
+func newTestStore(t *testing.T) *httptest.Server {
+	t.Helper()
+	return newTestStoreWithConfig(t, nil)
+}
+
+// the keys of the stores made by newProdTestStore
+const (
+	testMasterKeyStr  = "test-master-key"
+	testProjectKeyStr = "test-project-key" // an admin key of 'first_proj' and 'mine' only
+	testAllKeyStr     = "test-all-key"     // a read key of all projects
+)
+
+// newProdTestStore is newTestStore with in_production on and the keys testMasterKeyStr,
+// testProjectKeyStr and testAllKeyStr.
+func newProdTestStore(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	ts := newTestStoreWithConfig(t, map[string]string{"in_production": "true"})
+	err := os.WriteFile(internal.GetKeyStrPath(), []byte(testMasterKeyStr), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = internal.SaveAPIKeys([]internal.APIKey{
+		{Name: "project", Hash: internal.HashKeyStr(testProjectKeyStr), Role: internal.ROLE_ADMIN, Projects: []string{"first_proj", "mine"}},
+		{Name: "all", Hash: internal.HashKeyStr(testAllKeyStr), Role: internal.ROLE_READ, Projects: []string{"*"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ts
+}
+
+// newTestStoreWithConfig is newTestStore with some settings changed.
+func newTestStoreWithConfig(t *testing.T, settings map[string]string) *httptest.Server {
+	t.Helper()
+
+	rootPath := t.TempDir()
+	t.Setenv("SNAP_COMMON", rootPath)
+
+	conf, err := zazabul.ParseConfig(internal.RootConfigTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.Update(settings)
+	err = conf.Write(filepath.Join(rootPath, "flaarum.zconf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.MkdirAll(filepath.Join(rootPath, "first_proj"), 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	webhookWorkersOnce.Do(startWebhookWorkers)
+	webhooksMutex.Lock()
+	webhooksCache = nil
+	webhooksMutex.Unlock()
+
+	mux := http.NewServeMux()
+	registerRoutes(mux)
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// post sends a form to an endpoint of a test server and returns the status and body of the response.
+func post(t *testing.T, ts *httptest.Server, path string, values url.Values) (int, string) {
+	t.Helper()
+
+	resp, err := http.PostForm(ts.URL+path, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+// mustPost is post for the requests a test expects to succeed.
+func mustPost(t *testing.T, ts *httptest.Server, path string, values url.Values) string {
+	t.Helper()
+
+	status, body := post(t, ts, path, values)
+	if status != http.StatusOK {
+		t.Fatalf("%s: status %d: %s", path, status, body)
+	}
+	return body
+}
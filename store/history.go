@@ -0,0 +1,408 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	arrayOperations "github.com/adam-hanna/arrayOperations"
+	"github.com/pkg/errors"
+	"github.com/saenuma/flaarum/internal"
+	"github.com/saenuma/flaarumlib"
+)
+
+// extractAsOf removes the 'as of <datetime>' line from a search statement. It returns the rest of
+// the statement and the parsed datetime, which is nil if the statement has no 'as of' line.
+func extractAsOf(stmt string) (string, *time.Time, error) {
+	lines := strings.Split(stmt, "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if !strings.HasPrefix(trimmed, "as of ") {
+			continue
+		}
+
+		value := strings.TrimSpace(strings.TrimPrefix(trimmed, "as of "))
+		asOf, err := time.Parse(flaarumlib.DATETIME_FORMAT, value)
+		if err != nil {
+			return stmt, nil, errors.New(fmt.Sprintf("The value '%s' in the 'as of' line is not in datetime format.", value))
+		}
+
+		lines = slices.Delete(lines, i, i+1)
+		return strings.Join(lines, "\n"), &asOf, nil
+	}
+
+	return stmt, nil, nil
+}
+
+func compareFieldValues(fieldType, a, b string) (int, bool) {
+	switch fieldType {
+	case "int":
+		x, err1 := strconv.ParseInt(a, 10, 64)
+		y, err2 := strconv.ParseInt(b, 10, 64)
+		if err1 != nil || err2 != nil {
+			return 0, false
+		}
+		return compareOrdered(x, y), true
+	case "float":
+		x, err1 := strconv.ParseFloat(a, 64)
+		y, err2 := strconv.ParseFloat(b, 64)
+		if err1 != nil || err2 != nil {
+			return 0, false
+		}
+		return compareOrdered(x, y), true
+	case "date", "datetime":
+		format := flaarumlib.DATE_FORMAT
+		if fieldType == "datetime" {
+			format = flaarumlib.DATETIME_FORMAT
+		}
+		x, err1 := time.Parse(format, a)
+		y, err2 := time.Parse(format, b)
+		if err1 != nil || err2 != nil {
+			return 0, false
+		}
+		return x.Compare(y), true
+	}
+
+	return strings.Compare(a, b), true
+}
+
+func compareOrdered[T int64 | float64](x, y T) int {
+	if x < y {
+		return -1
+	} else if x > y {
+		return 1
+	}
+	return 0
+}
+
+// rowMatchesWhere checks a where option against a row in memory. It is used where the indexes
+// cannot be used, like in 'as of' searches.
+func rowMatchesWhere(projName, tableName string, row map[string]string, whereStruct flaarumlib.WhereStruct) bool {
+	value, ok := row[whereStruct.FieldName]
+	fieldType := internal.GetFieldType(projName, tableName, whereStruct.FieldName)
+
+	switch whereStruct.Relation {
+	case "=":
+		return ok && value == whereStruct.FieldValue
+	case "!=":
+		return !ok || value != whereStruct.FieldValue
+	case "in":
+		return ok && slices.Contains(whereStruct.FieldValues, value)
+	case "nin":
+		return !ok || !slices.Contains(whereStruct.FieldValues, value)
+	case "has":
+		return ok && strings.Contains(value, whereStruct.FieldValue)
+	case ">", ">=", "<", "<=":
+		if !ok {
+			return false
+		}
+		c, valid := compareFieldValues(fieldType, value, whereStruct.FieldValue)
+		if !valid {
+			return false
+		}
+		switch whereStruct.Relation {
+		case ">":
+			return c > 0
+		case ">=":
+			return c >= 0
+		case "<":
+			return c < 0
+		default:
+			return c <= 0
+		}
+	}
+
+	return false
+}
+
+// filterRowsInMemory returns the ids of the rows matching the where options. It combines the
+// options the same way as doOnlyOneSearch.
+func filterRowsInMemory(projName, tableName string, rows map[string]map[string]string, whereOpts []flaarumlib.WhereStruct) []string {
+	beforeFilter := make([][]string, 0)
+	for _, whereStruct := range whereOpts {
+		ids := make([]string, 0)
+		for id, row := range rows {
+			if rowMatchesWhere(projName, tableName, row, whereStruct) {
+				ids = append(ids, id)
+			}
+		}
+		beforeFilter = append(beforeFilter, ids)
+	}
+
+	andsCount := 0
+	orsCount := 0
+	for _, whereStruct := range whereOpts {
+		if whereStruct.Joiner == "and" {
+			andsCount += 1
+		} else if whereStruct.Joiner == "or" {
+			orsCount += 1
+		}
+	}
+
+	retIds := make([]string, 0)
+	if andsCount == len(whereOpts)-1 {
+		retIds = arrayOperations.Intersect(beforeFilter...)
+	} else if orsCount == len(whereOpts)-1 {
+		retIds = arrayOperations.Union(beforeFilter...)
+	}
+
+	return retIds
+}
+
+// rowsAsOf rebuilds the rows of a table as they were at asOf from the current rows and the history.
+// The caller must hold the table's lock.
+func rowsAsOf(projName, tableName string, asOf time.Time) (map[string]map[string]string, error) {
+	histories, err := internal.ReadTableHistory(projName, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make(map[string]map[string]string)
+	dataF1Path := filepath.Join(internal.GetTablePath(projName, tableName), "data.flaa1")
+	if internal.DoesPathExists(dataF1Path) {
+		elemsMap, err := internal.ParseDataF1File(dataF1Path)
+		if err != nil {
+			return nil, err
+		}
+
+		for id, elem := range elemsMap {
+			rawRowData, err := internal.ReadPortionF2File(projName, tableName, "data", elem.DataBegin, elem.DataEnd)
+			if err != nil {
+				return nil, err
+			}
+			rowMap, err := internal.ParseEncodedRowData(rawRowData)
+			if err != nil {
+				return nil, err
+			}
+			rowMap["id"] = id
+			ret[id] = rowMap
+		}
+	}
+
+	// The first operation after asOf holds the version of the row at asOf. Without such an operation
+	// the current row is the version at asOf.
+	for id, versions := range histories {
+		for _, version := range versions {
+			if !version.Time.After(asOf) {
+				continue
+			}
+
+			if version.Op == internal.HISTORY_INSERT {
+				delete(ret, id)
+			} else {
+				ret[id] = version.Row
+			}
+			break
+		}
+	}
+
+	return ret, nil
+}
+
+// innerSearchAsOf runs a search statement against the rows of a table as they were at asOf.
+func innerSearchAsOf(projName, stmt string, asOf time.Time) (*[]map[string]string, int, error) {
+	stmtStruct, err := flaarumlib.ParseSearchStmt(stmt)
+	if err != nil {
+		return nil, 0, err
+	}
+	tableName := stmtStruct.TableName
+
+	if stmtStruct.Expand {
+		return nil, 0, errors.New("'expand' is not supported in 'as of' searches")
+	}
+
+	if !internal.IsHistoryKept(projName, tableName) {
+		return nil, 0, errors.New(fmt.Sprintf("table '%s' does not keep history. Set its 'history' option to true.", tableName))
+	}
+
+	tableLocks.RLock(projName, tableName)
+	defer tableLocks.RUnlock(projName, tableName)
+
+	rows, err := rowsAsOf(projName, tableName, asOf)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var retIds []string
+	if stmtStruct.Multi && len(stmtStruct.MultiWhereOptions) != 0 {
+		outs := make([][]string, 0)
+		for _, whereOpts := range stmtStruct.MultiWhereOptions {
+			outs = append(outs, filterRowsInMemory(projName, tableName, rows, whereOpts))
+		}
+
+		if stmtStruct.Joiner == "and" {
+			retIds = arrayOperations.Intersect(outs...)
+		} else if stmtStruct.Joiner == "or" {
+			retIds = arrayOperations.Union(outs...)
+		}
+	} else if !stmtStruct.Multi && len(stmtStruct.WhereOptions) != 0 {
+		retIds = filterRowsInMemory(projName, tableName, rows, stmtStruct.WhereOptions)
+	} else {
+		for id := range rows {
+			retIds = append(retIds, id)
+		}
+	}
+
+	found := make([]map[string]string, 0)
+	for _, id := range retIds {
+		found = append(found, rows[id])
+	}
+	observeRowsScanned(len(rows))
+
+	return orderLimitAndSelect(projName, tableName, stmtStruct, found), len(rows), nil
+}
+
+func rowHistory(w http.ResponseWriter, r *http.Request) {
+	projName := r.PathValue("proj")
+	tableName := r.PathValue("tbl")
+	rowId := r.PathValue("id")
+
+	if !doesTableExists(projName, tableName) {
+		internal.PrintError(w, errors.New(fmt.Sprintf("Table '%s' of Project '%s' does not exists.", tableName, projName)))
+		return
+	}
+
+	tableLocks.RLock(projName, tableName)
+	histories, err := internal.ReadTableHistory(projName, tableName)
+	tableLocks.RUnlock(projName, tableName)
+	if err != nil {
+		internal.PrintError(w, err)
+		return
+	}
+
+	versions, ok := histories[rowId]
+	if !ok {
+		versions = make([]internal.RowVersion, 0)
+	}
+
+	jsonBytes, err := json.Marshal(versions)
+	if err != nil {
+		internal.PrintError(w, errors.Wrap(err, "json error"))
+		return
+	}
+
+	w.Write(jsonBytes)
+}
+
+func getTableOptionsHTTP(w http.ResponseWriter, r *http.Request) {
+	projName := r.PathValue("proj")
+	tableName := r.PathValue("tbl")
+
+	if !doesTableExists(projName, tableName) {
+		internal.PrintError(w, errors.New(fmt.Sprintf("Table '%s' of Project '%s' does not exists.", tableName, projName)))
+		return
+	}
+
+	conf, err := internal.GetTableOptions(projName, tableName)
+	if err != nil {
+		internal.PrintError(w, err)
+		return
+	}
+
+	options := make(map[string]string)
+	for _, item := range conf.Items {
+		options[item.Name] = item.Value
+	}
+
+	jsonBytes, err := json.Marshal(options)
+	if err != nil {
+		internal.PrintError(w, errors.Wrap(err, "json error"))
+		return
+	}
+
+	w.Write(jsonBytes)
+}
+
+func setTableOptions(w http.ResponseWriter, r *http.Request) {
+	projName := r.PathValue("proj")
+	tableName := r.PathValue("tbl")
+
+	r.ParseForm()
+	options := make(map[string]string)
+	for k := range r.PostForm {
+		if k == "key-str" {
+			continue
+		}
+		options[k] = r.PostForm.Get(k)
+	}
+
+	projsMutex.Lock()
+	defer projsMutex.Unlock()
+
+	if !doesTableExists(projName, tableName) {
+		internal.PrintError(w, errors.New(fmt.Sprintf("Table '%s' of Project '%s' does not exists.", tableName, projName)))
+		return
+	}
+
+	tableLocks.Lock(projName, tableName)
+	defer tableLocks.Unlock(projName, tableName)
+
+	if strategy, ok := options["id_strategy"]; ok {
+		err := checkIdStrategyChange(projName, tableName, strategy)
+		if err != nil {
+			printValError(w, err)
+			return
+		}
+
+		if strategy == internal.ID_SEQUENTIAL {
+			err = internal.RaiseLastId(projName, tableName)
+			if err != nil {
+				internal.PrintError(w, err)
+				return
+			}
+		}
+	}
+
+	err := internal.UpdateTableOptions(projName, tableName, options)
+	if err != nil {
+		printValError(w, err)
+		return
+	}
+
+	jsonBytes, _ := json.Marshal(options)
+	setAuditDetails(r, tableName, string(jsonBytes), nil)
+	fmt.Fprintf(w, "ok")
+}
+
+// checkIdStrategyChange refuses to change the type of the ids of a table which has rows or is
+// pointed to by foreign keys, as the ids already written or the foreign key fields would no longer
+// have the type of the ids. The caller must hold projsMutex.
+func checkIdStrategyChange(projName, tableName, strategy string) error {
+	if internal.IdTypeOfStrategy(strategy) == internal.GetIdFieldType(projName, tableName) {
+		return nil
+	}
+
+	// the rows in the trash can be restored
+	ids, err := internal.GetTableIds(projName, tableName)
+	if err != nil {
+		return err
+	}
+	if len(ids) > 0 {
+		return errors.New(fmt.Sprintf("The id strategy of table '%s' cannot be changed to '%s' as it has rows with ids of another type",
+			tableName, strategy))
+	}
+
+	existingTables, err := internal.ListTables(projName)
+	if err != nil {
+		return err
+	}
+	for _, tbl := range existingTables {
+		ts, err := getCurrentTableStructureParsed(projName, tbl)
+		if err != nil {
+			return err
+		}
+		for _, fkd := range ts.ForeignKeys {
+			if fkd.PointedTable == tableName {
+				return errors.New(fmt.Sprintf("The id strategy of table '%s' cannot be changed to '%s' as the foreign key '%s' of table '%s' points to it",
+					tableName, strategy, fkd.FieldName, tbl))
+			}
+		}
+	}
+
+	return nil
+}
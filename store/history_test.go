@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/saenuma/flaarumlib"
+)
+
+func TestExtractAsOf(t *testing.T) {
+	stmt, asOf, err := extractAsOf("table: notes\nas of 2024-03-05T10:30 UTC\nwhere:\n  title = a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stmt != "table: notes\nwhere:\n  title = a" {
+		t.Errorf("the statement without the 'as of' line is %q", stmt)
+	}
+	if asOf == nil || !asOf.Equal(time.Date(2024, 3, 5, 10, 30, 0, 0, time.UTC)) {
+		t.Errorf("the 'as of' datetime is %v", asOf)
+	}
+
+	_, asOf, err = extractAsOf("table: notes")
+	if err != nil || asOf != nil {
+		t.Errorf("a statement without 'as of' gave %v, %v", asOf, err)
+	}
+
+	_, _, err = extractAsOf("table: notes\nas of yesterday")
+	if err == nil {
+		t.Error("an 'as of' line which is not a datetime was accepted")
+	}
+}
+
+func TestSearchAsOfAcrossUpdateAndDelete(t *testing.T) {
+	ts := newTestStore(t)
+	mustPost(t, ts, "/create-table/first_proj", url.Values{"stmt": {"table: notes\nfields:\n  title string\n::\n"}})
+	mustPost(t, ts, "/set-table-options/first_proj/notes", url.Values{"history": {"true"}})
+
+	pause := func() time.Time {
+		time.Sleep(5 * time.Millisecond)
+		moment := time.Now()
+		time.Sleep(5 * time.Millisecond)
+		return moment
+	}
+
+	beforeInsert := pause()
+	updatedId := mustPost(t, ts, "/insert-row/first_proj/notes", url.Values{"title": {"first"}})
+	deletedId := mustPost(t, ts, "/insert-row/first_proj/notes", url.Values{"title": {"gone"}})
+	afterInsert := pause()
+	mustPost(t, ts, "/update-rows/first_proj", url.Values{
+		"stmt":   {"table: notes\nwhere:\n  id = " + updatedId},
+		"set1_k": {"title"},
+		"set1_v": {"second"},
+	})
+	afterUpdate := pause()
+	mustPost(t, ts, "/delete-rows/first_proj", url.Values{"stmt": {"table: notes\nwhere:\n  id = " + deletedId}})
+
+	titlesAt := func(asOf time.Time) map[string]string {
+		t.Helper()
+		rows, _, err := innerSearchAsOf("first_proj", "table: notes", asOf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		titles := make(map[string]string)
+		for _, row := range *rows {
+			titles[row["id"]] = row["title"]
+		}
+		return titles
+	}
+
+	if titles := titlesAt(beforeInsert); len(titles) != 0 {
+		t.Errorf("before the inserts the table had %v", titles)
+	}
+	if titles := titlesAt(afterInsert); len(titles) != 2 || titles[updatedId] != "first" || titles[deletedId] != "gone" {
+		t.Errorf("after the inserts the table had %v", titles)
+	}
+	if titles := titlesAt(afterUpdate); len(titles) != 2 || titles[updatedId] != "second" || titles[deletedId] != "gone" {
+		t.Errorf("after the update the table had %v", titles)
+	}
+	if titles := titlesAt(time.Now()); len(titles) != 1 || titles[updatedId] != "second" {
+		t.Errorf("after the delete the table had %v", titles)
+	}
+
+	// through the endpoint, whose 'as of' is to the minute
+	later := time.Now().Add(time.Minute).UTC().Format(flaarumlib.DATETIME_FORMAT)
+	rows := searchRows(t, ts, "table: notes\nas of "+later)
+	if len(rows) != 1 || rows[0]["title"] != "second" {
+		t.Errorf("the search as of %s found %v", later, rows)
+	}
+	earlier := time.Now().Add(-time.Minute).UTC().Format(flaarumlib.DATETIME_FORMAT)
+	if rows := searchRows(t, ts, "table: notes\nas of "+earlier); len(rows) != 0 {
+		t.Errorf("the search as of %s found %v", earlier, rows)
+	}
+}
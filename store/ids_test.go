@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/saenuma/flaarum/internal"
+)
+
+func TestClientIdCollisions(t *testing.T) {
+	ts := newTestStore(t)
+	mustPost(t, ts, "/create-table/first_proj", url.Values{"stmt": {"table: notes\nfields:\n  title string\n::\n"}})
+	mustPost(t, ts, "/set-table-options/first_proj/notes", url.Values{"soft_delete": {"true"}})
+
+	mustPost(t, ts, "/insert-row/first_proj/notes", url.Values{"id": {"5"}, "title": {"live"}})
+	mustPost(t, ts, "/insert-row/first_proj/notes", url.Values{"id": {"7"}, "title": {"trashed"}})
+	mustPost(t, ts, "/delete-rows/first_proj", url.Values{"stmt": {"table: notes\nwhere:\n  id = 7"}})
+
+	for _, id := range []string{"5", "7", "0", "abc"} {
+		status, body := post(t, ts, "/insert-row/first_proj/notes", url.Values{"id": {id}, "title": {"again"}})
+		if status == http.StatusOK {
+			t.Errorf("a row with the id '%s' was inserted: %s", id, body)
+		}
+	}
+
+	// the sequential ids continue after the client's ids
+	if id := mustPost(t, ts, "/insert-row/first_proj/notes", url.Values{"title": {"next"}}); id != "8" {
+		t.Errorf("the next id is '%s', expected '8'", id)
+	}
+}
+
+func TestIdStrategyChanges(t *testing.T) {
+	ts := newTestStore(t)
+	mustPost(t, ts, "/create-table/first_proj", url.Values{"stmt": {"table: flakes\nfields:\n  title string\n::\n"}})
+	mustPost(t, ts, "/set-table-options/first_proj/flakes", url.Values{"id_strategy": {internal.ID_SNOWFLAKE}})
+	flakeId := mustPost(t, ts, "/insert-row/first_proj/flakes", url.Values{"title": {"a"}})
+
+	// as in the tables written before lastId.txt followed every strategy
+	err := os.Remove(filepath.Join(internal.GetTablePath("first_proj", "flakes"), "lastId.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// snowflake and sequential ids are both integers
+	mustPost(t, ts, "/set-table-options/first_proj/flakes", url.Values{"id_strategy": {internal.ID_SEQUENTIAL}})
+	id := mustPost(t, ts, "/insert-row/first_proj/flakes", url.Values{"title": {"b"}})
+	idInt, _ := strconv.ParseInt(id, 10, 64)
+	flakeIdInt, _ := strconv.ParseInt(flakeId, 10, 64)
+	if idInt <= flakeIdInt {
+		t.Errorf("the sequential id '%s' is not after the snowflake id '%s'", id, flakeId)
+	}
+
+	// a table whose only row is in the trash keeps the type of its ids
+	mustPost(t, ts, "/create-table/first_proj", url.Values{"stmt": {"table: uuids\nfields:\n  title string\n::\n"}})
+	mustPost(t, ts, "/set-table-options/first_proj/uuids", url.Values{"soft_delete": {"true"}, "id_strategy": {internal.ID_UUIDV7}})
+	uuid := mustPost(t, ts, "/insert-row/first_proj/uuids", url.Values{"title": {"a"}})
+	mustPost(t, ts, "/delete-rows/first_proj", url.Values{"stmt": {"table: uuids\nwhere:\n  id = " + uuid}})
+
+	status, body := post(t, ts, "/set-table-options/first_proj/uuids", url.Values{"id_strategy": {internal.ID_SEQUENTIAL}})
+	if status == http.StatusOK {
+		t.Errorf("the id strategy of a table with a trashed row was changed: %s", body)
+	}
+
+	// a table pointed to by a foreign key keeps the type of its ids
+	mustPost(t, ts, "/create-table/first_proj", url.Values{"stmt": {"table: authors\nfields:\n  name string\n::\n"}})
+	mustPost(t, ts, "/create-table/first_proj", url.Values{"stmt": {"table: books\nfields:\n  author int\n::\nforeign_keys:\n  author authors on_delete_restrict\n::\n"}})
+	status, body = post(t, ts, "/set-table-options/first_proj/authors", url.Values{"id_strategy": {internal.ID_ULID}})
+	if status == http.StatusOK {
+		t.Errorf("the id strategy of a table pointed to by a foreign key was changed: %s", body)
+	}
+}
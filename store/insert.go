@@ -3,8 +3,8 @@ package main
 import (
 	"fmt"
 	"net/http"
-	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -14,6 +14,94 @@ import (
 	"github.com/saenuma/flaarumlib"
 )
 
+// lockForValidatedWrite write locks a table and read locks the tables its foreign keys point to, so
+// that the unique and foreign key checks of validateAndMutateDataMap hold until the rows are written.
+func lockForValidatedWrite(projName, tableName string) (func(), error) {
+	pointedTables, err := getPointedTables(projName, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		unlock := tableLocks.Acquire(projName, []string{tableName}, pointedTables)
+
+		// the structure may have changed before the locks were taken. It cannot change while the
+		// table is locked.
+		currentPointedTables, err := getPointedTables(projName, tableName)
+		if err != nil {
+			unlock()
+			return nil, err
+		}
+		if slices.Equal(pointedTables, currentPointedTables) {
+			return unlock, nil
+		}
+		unlock()
+		pointedTables = currentPointedTables
+	}
+}
+
+// getPointedTables returns the sorted names of the tables the foreign keys of a table point to.
+func getPointedTables(projName, tableName string) ([]string, error) {
+	tableStruct, err := getCurrentTableStructureParsed(projName, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	pointedTables := make([]string, 0, len(tableStruct.ForeignKeys))
+	for _, fkd := range tableStruct.ForeignKeys {
+		pointedTables = append(pointedTables, fkd.PointedTable)
+	}
+	slices.Sort(pointedTables)
+	return slices.Compact(pointedTables), nil
+}
+
+// checkUniqueWithinRows checks that rows written together do not share the value of a unique field,
+// which validateAndMutateDataMap cannot see as it checks each row against the rows already written.
+func checkUniqueWithinRows(tableStruct flaarumlib.TableStruct, rows []map[string]string) error {
+	for _, fd := range tableStruct.Fields {
+		if !fd.Unique {
+			continue
+		}
+		seen := make(map[string]bool)
+		for _, row := range rows {
+			value, ok := row[fd.FieldName]
+			if !ok || value == "" {
+				continue
+			}
+			if seen[value] {
+				return errors.New(fmt.Sprintf("UE: The data '%s' is not unique to field '%s'.", value, fd.FieldName))
+			}
+			seen[value] = true
+		}
+	}
+	return nil
+}
+
+// checkClientId checks that an id supplied by a client has the format of the table's id strategy
+// and is not used by another row. The caller must hold the table's write lock.
+func checkClientId(projName, tableName, id string) error {
+	err := internal.ValidateId(projName, tableName, id)
+	if err != nil {
+		return err
+	}
+
+	exists, _, err := innerSearchNoLock(projName, fmt.Sprintf("table: %s\nwhere:\n  id = %s\n", tableName, id))
+	if err != nil {
+		return err
+	}
+	// the rows in the trash keep their ids for a restore
+	ids, err := internal.GetTableIds(projName, tableName)
+	if err != nil {
+		return err
+	}
+	if len(*exists) > 0 || slices.Contains(ids, id) {
+		return errors.New(fmt.Sprintf("UE: The id '%s' is used by another row of table '%s'", id, tableName))
+	}
+	return nil
+}
+
+// validateAndMutateDataMap validates a row against the table structure, including its unique
+// fields and foreign keys. The caller must hold the locks taken by lockForValidatedWrite.
 func validateAndMutateDataMap(projName, tableName string, dataMap, oldValues map[string]string) (map[string]string, error) {
 	tableStruct, err := getCurrentTableStructureParsed(projName, tableName)
 	if err != nil {
@@ -107,7 +195,7 @@ func validateAndMutateDataMap(projName, tableName string, dataMap, oldValues map
       	where:
       		%s = %s
       	`, tableName, fd.FieldName, newValue)
-			toCheckRows, err := innerSearch(projName, innerStmt)
+			toCheckRows, _, err := innerSearchNoLock(projName, innerStmt)
 			if err != nil {
 				return nil, err
 			}
@@ -128,7 +216,7 @@ func validateAndMutateDataMap(projName, tableName string, dataMap, oldValues map
 					id = %s
 				`, fkd.PointedTable, v)
 
-			toCheckRows, err := innerSearch(projName, innerStmt)
+			toCheckRows, _, err := innerSearchNoLock(projName, innerStmt)
 			if err != nil {
 				return nil, err
 			}
@@ -175,71 +263,96 @@ func insertRow(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	unlock, err := lockForValidatedWrite(projName, tableName)
+	if err != nil {
+		internal.PrintError(w, err)
+		return
+	}
+	defer unlock()
+
 	// do unique and foreign key validation
-	toInsert, err := validateAndMutateDataMap(projName, tableName, toInsert, nil)
+	toInsert, err = validateAndMutateDataMap(projName, tableName, toInsert, nil)
 	if err != nil {
 		printValError(w, err)
 		return
 	}
 
-	createTableMutexIfNecessary(projName, tableName)
-	fullTableName := projName + ":" + tableName
-	tablesMutexes[fullTableName].Lock()
-	defer tablesMutexes[fullTableName].Unlock()
-
-	var lastId int64
-	lastIdPath := filepath.Join(tablePath, "lastId.txt")
-
-	if !internal.DoesPathExists(lastIdPath) {
-		lastId = 0
-	} else {
-		raw, err := os.ReadFile(lastIdPath)
+	if toWriteId, ok := toInsert["id"]; ok {
+		err = checkClientId(projName, tableName, toWriteId)
 		if err != nil {
-			internal.PrintError(w, err)
+			printValError(w, err)
 			return
 		}
-		lastIdTmp, _ := strconv.ParseInt(strings.TrimSpace(string(raw)), 10, 64)
-		lastId = lastIdTmp
 	}
 
+	writtenId, err := innerInsert(projName, tableName, toInsert)
+	if err != nil {
+		internal.PrintError(w, err)
+		return
+	}
+
+	setAuditDetails(r, tableName, "", []string{writtenId})
+	fmt.Fprint(w, writtenId)
+
+}
+
+// innerInsert writes a validated row and its indexes and returns its id. A client supplied id must
+// have been checked with checkClientId. The caller must hold the locks taken by lockForValidatedWrite.
+func innerInsert(projName, tableName string, toInsert map[string]string) (string, error) {
 	var writtenId string
-	if _, ok := toInsert["id"]; ok {
-		toWriteId := toInsert["id"]
-		err = internal.SaveRowData(projName, tableName, toWriteId, toInsert)
+	if toWriteId, ok := toInsert["id"]; ok {
+		err := internal.NoteClientId(projName, tableName, toWriteId)
 		if err != nil {
-			internal.PrintError(w, err)
-			return
+			return "", err
 		}
-		toWriteIdInt64, _ := strconv.ParseInt(toWriteId, 10, 64)
-		if toWriteIdInt64 > lastId {
-			os.WriteFile(lastIdPath, []byte(toWriteId), 0777)
-		}
-
 		writtenId = toWriteId
 	} else {
-		nextIdStr := strconv.FormatInt(lastId+1, 10)
-		err = internal.SaveRowData(projName, tableName, nextIdStr, toInsert)
+		var err error
+		writtenId, err = internal.NextId(projName, tableName)
 		if err != nil {
-			internal.PrintError(w, err)
-			return
+			return "", err
 		}
+	}
+
+	err := saveNewRow(projName, tableName, writtenId, toInsert)
+	if err != nil {
+		return "", err
+	}
+
+	toInsert["id"] = writtenId
+	err = recordChange(projName, tableName, internal.HISTORY_INSERT, writtenId, toInsert)
+	if err != nil {
+		return "", err
+	}
+
+	return writtenId, nil
+}
 
-		os.WriteFile(lastIdPath, []byte(nextIdStr), 0777)
-		writtenId = nextIdStr
+// saveNewRow writes a new row with its history and indexes. Inserts and restores from the trash
+// use it; they record the change. The caller must hold the locks taken by lockForValidatedWrite.
+func saveNewRow(projName, tableName, rowId string, row map[string]string) error {
+	err := internal.SaveRowData(projName, tableName, rowId, row)
+	if err != nil {
+		return err
+	}
+
+	err = internal.RecordRowHistory(projName, tableName, rowId, internal.HISTORY_INSERT, nil)
+	if err != nil {
+		return err
 	}
 
 	// create indexes
-	for k, v := range toInsert {
+	for k, v := range row {
+		if k == "id" {
+			continue
+		}
 		if !internal.IsNotIndexedField(projName, tableName, k) {
-			err := internal.MakeIndex(projName, tableName, k, v, writtenId)
+			err := internal.MakeIndex(projName, tableName, k, v, rowId)
 			if err != nil {
-				internal.PrintError(w, err)
-				return
+				return err
 			}
 		}
-
 	}
 
-	fmt.Fprint(w, writtenId)
-
+	return nil
 }
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+)
+
+func searchRows(t *testing.T, ts *httptest.Server, stmt string) []map[string]string {
+	t.Helper()
+
+	body := mustPost(t, ts, "/search-table/first_proj", url.Values{"stmt": {stmt}})
+	rows := make([]map[string]string, 0)
+	err := json.Unmarshal([]byte(body), &rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rows
+}
+
+func TestConcurrentInsertsOfUniqueValue(t *testing.T) {
+	ts := newTestStore(t)
+	mustPost(t, ts, "/create-table/first_proj", url.Values{"stmt": {"table: users\nfields:\n  email string required unique\n::\n"}})
+
+	const n = 20
+	statuses := make([]int, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			statuses[i], _ = post(t, ts, "/insert-row/first_proj/users", url.Values{"email": {"a@example.com"}})
+		}(i)
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, status := range statuses {
+		if status == http.StatusOK {
+			succeeded += 1
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("%d of the inserts succeeded, expected 1", succeeded)
+	}
+
+	rows := searchRows(t, ts, "table: users\nwhere:\n  email = a@example.com")
+	if len(rows) != 1 {
+		t.Errorf("%d rows have the unique value, expected 1", len(rows))
+	}
+}
+
+func TestInsertRacingDeleteOfReferencedRow(t *testing.T) {
+	ts := newTestStore(t)
+	mustPost(t, ts, "/create-table/first_proj", url.Values{"stmt": {"table: authors\nfields:\n  name string required\n::\n"}})
+	mustPost(t, ts, "/create-table/first_proj", url.Values{"stmt": {`
+table: books
+fields:
+  title string required
+  author int required
+::
+foreign_keys:
+  author authors on_delete_restrict
+::
+`}})
+
+	for i := 0; i < 20; i++ {
+		authorId := mustPost(t, ts, "/insert-row/first_proj/authors", url.Values{"name": {fmt.Sprintf("author%d", i)}})
+
+		var insertStatus, deleteStatus int
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			insertStatus, _ = post(t, ts, "/insert-row/first_proj/books", url.Values{
+				"title":  {fmt.Sprintf("book%d", i)},
+				"author": {authorId},
+			})
+		}()
+		go func() {
+			defer wg.Done()
+			deleteStatus, _ = post(t, ts, "/delete-rows/first_proj", url.Values{"stmt": {"table: authors\nwhere:\n  id = " + authorId}})
+		}()
+		wg.Wait()
+
+		if insertStatus == http.StatusOK && deleteStatus == http.StatusOK {
+			t.Fatalf("round %d: both the insert of a book and the delete of its author succeeded", i)
+		}
+		if insertStatus != http.StatusOK && deleteStatus != http.StatusOK {
+			t.Fatalf("round %d: both the insert of a book and the delete of its author failed", i)
+		}
+
+		authors := searchRows(t, ts, "table: authors\nwhere:\n  id = "+authorId)
+		books := searchRows(t, ts, "table: books\nwhere:\n  author = "+authorId)
+		if len(books) > 0 && len(authors) == 0 {
+			t.Fatalf("round %d: a book points to a deleted author", i)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"slices"
+	"sync"
+)
+
+// lockManager holds the locks of the tables. A table's lock is made the first time it is asked
+// for and is kept for the life of the store, so a table which is deleted and created again gets
+// the same lock.
+//
+// To avoid deadlocks, projsMutex is always taken before the locks of tables, and the locks of
+// several tables are taken with LockTables or RLockTables which take them in the order of their
+// names.
+type lockManager struct {
+	mutex sync.Mutex
+	locks map[string]*timedRWMutex
+}
+
+var tableLocks = &lockManager{locks: make(map[string]*timedRWMutex)}
+
+func (lm *lockManager) get(projName, tableName string) *timedRWMutex {
+	lm.mutex.Lock()
+	defer lm.mutex.Unlock()
+
+	fullTableName := projName + ":" + tableName
+	lock, ok := lm.locks[fullTableName]
+	if !ok {
+		lock = &timedRWMutex{}
+		lm.locks[fullTableName] = lock
+	}
+	return lock
+}
+
+func (lm *lockManager) Lock(projName, tableName string) {
+	lm.get(projName, tableName).Lock()
+}
+
+func (lm *lockManager) Unlock(projName, tableName string) {
+	lm.get(projName, tableName).Unlock()
+}
+
+func (lm *lockManager) RLock(projName, tableName string) {
+	lm.get(projName, tableName).RLock()
+}
+
+func (lm *lockManager) RUnlock(projName, tableName string) {
+	lm.get(projName, tableName).RUnlock()
+}
+
+// Acquire write locks the tables writeTables and read locks the tables readTables of a project, in
+// the order of their names, and returns the function which unlocks them. A table may be given more
+// than once; it is write locked if it is in writeTables.
+func (lm *lockManager) Acquire(projName string, writeTables, readTables []string) func() {
+	sorted := slices.Concat(writeTables, readTables)
+	slices.Sort(sorted)
+	sorted = slices.Compact(sorted)
+
+	for _, tableName := range sorted {
+		if slices.Contains(writeTables, tableName) {
+			lm.Lock(projName, tableName)
+		} else {
+			lm.RLock(projName, tableName)
+		}
+	}
+	return func() {
+		for _, tableName := range slices.Backward(sorted) {
+			if slices.Contains(writeTables, tableName) {
+				lm.Unlock(projName, tableName)
+			} else {
+				lm.RUnlock(projName, tableName)
+			}
+		}
+	}
+}
+
+// LockTables write locks tables of a project in the order of their names and returns the function
+// which unlocks them.
+func (lm *lockManager) LockTables(projName string, tableNames []string) func() {
+	return lm.Acquire(projName, tableNames, nil)
+}
+
+// RLockTables read locks tables of a project in the order of their names and returns the function
+// which unlocks them.
+func (lm *lockManager) RLockTables(projName string, tableNames []string) func() {
+	return lm.Acquire(projName, nil, tableNames)
+}
+
+// LockAll write locks every table the store has used, in the order of their names. It is used on
+// shutdown and the locks are never released.
+func (lm *lockManager) LockAll() {
+	lm.mutex.Lock()
+	names := make([]string, 0, len(lm.locks))
+	for fullTableName := range lm.locks {
+		names = append(names, fullTableName)
+	}
+	lm.mutex.Unlock()
+
+	slices.Sort(names)
+	for _, fullTableName := range names {
+		lm.mutex.Lock()
+		lock := lm.locks[fullTableName]
+		lm.mutex.Unlock()
+		lock.Lock()
+	}
+}
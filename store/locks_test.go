@@ -0,0 +1,257 @@
+package main
+
+import (
+	"math/rand"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/saenuma/flaarum/internal"
+	"github.com/saenuma/flaarumlib"
+)
+
+func newTestLockManager() *lockManager {
+	return &lockManager{locks: make(map[string]*timedRWMutex)}
+}
+
+// waitOrFail fails the test if wg is not done within the timeout, which means a deadlock.
+func waitOrFail(t *testing.T, wg *sync.WaitGroup, timeout time.Duration) {
+	t.Helper()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		t.Fatal("the goroutines did not finish; the locks deadlocked")
+	}
+}
+
+func TestLockManagerGetConcurrent(t *testing.T) {
+	lm := newTestLockManager()
+
+	const n = 50
+	got := make([]*timedRWMutex, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			got[i] = lm.get("proj", "tbl")
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 1; i < n; i++ {
+		if got[i] != got[0] {
+			t.Fatal("concurrent calls to get made different locks for the same table")
+		}
+	}
+	if lm.get("proj", "other") == got[0] {
+		t.Error("two tables share a lock")
+	}
+	if lm.get("other", "tbl") == got[0] {
+		t.Error("tables of two projects share a lock")
+	}
+}
+
+func TestAcquireOverlappingSets(t *testing.T) {
+	lm := newTestLockManager()
+
+	// the counters are only changed under the write locks of their tables, so the race detector
+	// reports any two writers holding the same table.
+	counters := map[string]*int{"a": new(int), "b": new(int), "c": new(int)}
+	sets := []struct {
+		write []string
+		read  []string
+	}{
+		{[]string{"a", "b"}, []string{"c"}},
+		{[]string{"c"}, []string{"b", "a"}},
+		{[]string{"b"}, []string{"a", "c"}},
+		{[]string{"c", "a"}, []string{"b"}},
+		// a table in both sets is write locked
+		{[]string{"a"}, []string{"a", "b"}},
+	}
+
+	const rounds = 200
+	var wg sync.WaitGroup
+	for _, set := range sets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				unlock := lm.Acquire("proj", set.write, set.read)
+				for _, tableName := range set.write {
+					*counters[tableName] += 1
+				}
+				for _, tableName := range set.read {
+					_ = *counters[tableName]
+				}
+				unlock()
+			}
+		}()
+	}
+	waitOrFail(t, &wg, 10*time.Second)
+
+	expected := make(map[string]int)
+	for _, set := range sets {
+		for _, tableName := range set.write {
+			expected[tableName] += rounds
+		}
+	}
+	for tableName, count := range expected {
+		if *counters[tableName] != count {
+			t.Errorf("table '%s' was written %d times, expected %d", tableName, *counters[tableName], count)
+		}
+	}
+}
+
+func TestAcquireReadersShare(t *testing.T) {
+	lm := newTestLockManager()
+
+	unlock := lm.RLockTables("proj", []string{"a", "b"})
+	defer unlock()
+
+	acquired := make(chan struct{})
+	go func() {
+		unlock := lm.RLockTables("proj", []string{"b", "a"})
+		unlock()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a reader waited on another reader")
+	}
+}
+
+func TestLockTablesContention(t *testing.T) {
+	lm := newTestLockManager()
+
+	tables := []string{"a", "b", "c", "d", "e"}
+	counters := make(map[string]*int)
+	for _, tableName := range tables {
+		counters[tableName] = new(int)
+	}
+
+	const goroutines = 20
+	const rounds = 100
+	var writes [goroutines]map[string]int
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(int64(g)))
+			writes[g] = make(map[string]int)
+			for i := 0; i < rounds; i++ {
+				// a random subset in a random order
+				picked := make([]string, 0)
+				for _, j := range rng.Perm(len(tables))[:1+rng.Intn(len(tables))] {
+					picked = append(picked, tables[j])
+				}
+
+				if g%2 == 0 {
+					unlock := lm.LockTables("proj", picked)
+					for _, tableName := range picked {
+						*counters[tableName] += 1
+						writes[g][tableName] += 1
+					}
+					unlock()
+				} else {
+					unlock := lm.RLockTables("proj", picked)
+					for _, tableName := range picked {
+						_ = *counters[tableName]
+					}
+					unlock()
+				}
+			}
+		}(g)
+	}
+	waitOrFail(t, &wg, 20*time.Second)
+
+	for _, tableName := range tables {
+		expected := 0
+		for g := 0; g < goroutines; g += 2 {
+			expected += writes[g][tableName]
+		}
+		if *counters[tableName] != expected {
+			t.Errorf("table '%s' was written %d times, expected %d", tableName, *counters[tableName], expected)
+		}
+	}
+}
+
+func TestLockForValidatedWriteSeesStructureChange(t *testing.T) {
+	ts := newTestStore(t)
+	for _, tableName := range []string{"authors", "publishers"} {
+		mustPost(t, ts, "/create-table/first_proj", url.Values{"stmt": {"table: " + tableName + "\nfields:\n  name string\n::\n"}})
+	}
+	mustPost(t, ts, "/create-table/first_proj", url.Values{"stmt": {`
+table: books
+fields:
+  author int
+  publisher int
+::
+foreign_keys:
+  author authors on_delete_empty
+::
+`}})
+
+	// the write reads the structure, then waits for the lock of 'books' while a foreign key to
+	// 'publishers' is added
+	tableLocks.Lock("first_proj", "books")
+	locked := make(chan func(), 1)
+	go func() {
+		unlock, err := lockForValidatedWrite("first_proj", "books")
+		if err != nil {
+			t.Error(err)
+			unlock = func() {}
+		}
+		locked <- unlock
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	tableStruct, err := flaarumlib.ParseTableStructureStmt(`
+table: books
+fields:
+  author int
+  publisher int
+::
+foreign_keys:
+  author authors on_delete_empty
+  publisher publishers on_delete_empty
+::
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(internal.GetTablePath("first_proj", "books"), "structure2.txt"),
+		[]byte(flaarumlib.FormatTableStruct(tableStruct)), 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tableLocks.Unlock("first_proj", "books")
+
+	var unlock func()
+	select {
+	case unlock = <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the write did not get its locks")
+	}
+	publishersLock := tableLocks.get("first_proj", "publishers")
+	if publishersLock.TryLock() {
+		publishersLock.Unlock()
+		t.Error("the table of the new foreign key is not locked")
+	}
+	unlock()
+	if !publishersLock.TryLock() {
+		t.Fatal("the table of the new foreign key is still locked")
+	}
+	publishersLock.Unlock()
+}
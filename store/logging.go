@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/saenuma/flaarum/internal"
+)
+
+const defaultSlowQueryMs = 500
+
+// requestInfo follows a request through its handler for the logs.
+type requestInfo struct {
+	id          string
+	rowsScanned int
+}
+
+const requestInfoContextKey contextKey = "request-info"
+
+var requestIdRegex = regexp.MustCompile(`^[a-zA-Z0-9\-_.]{1,64}$`)
+
+// newRequestId keeps the id sent by a client in the 'X-Request-Id' header, so that the logs of both
+// can be matched, or makes one.
+func newRequestId(r *http.Request) string {
+	if id := r.Header.Get(internal.REQUEST_ID_HEADER); requestIdRegex.MatchString(id) {
+		return id
+	}
+	return internal.GenerateSecureRandomString(16)
+}
+
+func getRequestInfo(r *http.Request) *requestInfo {
+	info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo)
+	if !ok {
+		return &requestInfo{}
+	}
+	return info
+}
+
+// setRowsScanned records the rows read by the search of a request for the slow query log.
+func setRowsScanned(r *http.Request, count int) {
+	getRequestInfo(r).rowsScanned += count
+}
+
+// getSlowQueryThreshold returns the 'slow_query_ms' setting. 0 turns the slow query log off.
+func getSlowQueryThreshold() time.Duration {
+	ms, err := strconv.Atoi(internal.GetSetting("slow_query_ms"))
+	if err != nil || ms < 0 {
+		ms = defaultSlowQueryMs
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// logRequest logs a served request and, if it has a statement which took longer than the slow
+// query threshold, logs the statement as a slow query.
+func logRequest(r *http.Request, status int, duration time.Duration) {
+	info := getRequestInfo(r)
+	keyName, _ := r.Context().Value(keyNameContextKey).(string)
+	durationMs := float64(duration.Microseconds()) / 1000
+
+	internal.Logger.Info("request", "request_id", info.id, "method", r.Method, "route", metricsRoute(r),
+		"path", r.URL.Path, "status", status, "duration_ms", durationMs, "key", keyName)
+
+	threshold := getSlowQueryThreshold()
+	stmt := r.FormValue("stmt")
+	if stmt == "" || threshold == 0 || duration < threshold {
+		return
+	}
+	internal.Logger.Warn("slow query", "request_id", info.id, "project", r.PathValue("proj"),
+		"route", metricsRoute(r), "stmt", stmt, "duration_ms", durationMs, "rows_scanned", info.rowsScanned)
+}
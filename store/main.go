@@ -2,19 +2,23 @@
 package main
 
 import (
+	"context"
+	"crypto/subtle"
 	"fmt"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/saenuma/flaarum/internal"
 	"github.com/saenuma/zazabul"
 )
 
-var projsMutex *sync.RWMutex // for projects and tables (table data uses different mutexes) creation, editing, deletion
-var tablesMutexes map[string]*sync.RWMutex
+var projsMutex = &sync.RWMutex{} // for projects and tables (table data uses different mutexes) creation, editing, deletion
 
 func main() {
 	// initialize
@@ -32,10 +36,6 @@ func main() {
 		}
 	}
 
-	// create mutexes
-	projsMutex = &sync.RWMutex{}
-	tablesMutexes = make(map[string]*sync.RWMutex)
-
 	confPath, err := internal.GetConfigPath()
 	if err != nil {
 		panic(err)
@@ -49,70 +49,260 @@ func main() {
 		conf.Write(confPath)
 	}
 
-	http.Handle("/is-flaarum", Q(func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprintf(w, "yeah-flaarum")
-	}))
+	err = internal.SetupLogger()
+	if err != nil {
+		panic(err)
+	}
 
-	// projects
-	http.Handle("/create-project/{proj}", Q(createProject))
-	http.Handle("/delete-project/{proj}", Q(deleteProject))
-	http.Handle("/list-projects", Q(listProjects))
-	http.Handle("/rename-project/{proj}/{nproj}", Q(renameProject))
+	// folders of the trims and reindexes cut short when the store last stopped
+	err = internal.RemoveRebuildFolders()
+	if err != nil {
+		panic(err)
+	}
 
-	// tables
-	http.Handle("/create-table/{proj}", Q(createTable))
-	http.Handle("/update-table-structure/{proj}", Q(updateTableStructure))
-	http.Handle("/get-current-version-num/{proj}/{tbl}", Q(getCurrentVersionNumHTTP))
-	http.Handle("/get-table-structure/{proj}/{tbl}/{vnum}", Q(getTableStructureHTTP))
-	http.Handle("/list-tables/{proj}", Q(listTables))
-	http.Handle("/delete-table/{proj}/{tbl}", Q(deleteTable))
+	startWebhookWorkers()
+	startReplication()
+	startCompactor()
 
-	// rows
-	http.Handle("/insert-row/{proj}/{tbl}", Q(insertRow))
-	http.Handle("/search-table/{proj}", Q(searchTable))
-	http.Handle("/delete-rows/{proj}", Q(deleteRows))
-	http.Handle("/update-rows/{proj}", Q(updateRows))
-	http.Handle("/count-rows/{proj}", Q(countRows))
-	http.Handle("/all-rows-count/{proj}/{tbl}", Q(allRowsCount))
+	registerRoutes(http.DefaultServeMux)
 
 	port := internal.GetSetting("port")
 
-	fmt.Printf("Serving on port: %s\n", port)
+	internal.Logger.Info("serving", "port", port)
 
-	err = http.ListenAndServeTLS(fmt.Sprintf(":%s", port), internal.G("https-server.crt"),
-		internal.G("https-server.key"), nil)
+	tlsConfig, err := getTLSConfig()
 	if err != nil {
 		panic(err)
 	}
+
+	server := &http.Server{Addr: fmt.Sprintf(":%s", port), TLSConfig: tlsConfig}
+	handleShutdown(server)
+	err = server.ListenAndServeTLS(internal.G("https-server.crt"), internal.G("https-server.key"))
+	if err != nil && err != http.ErrServerClosed {
+		panic(err)
+	}
+
+	// the shutdown goroutine exits once the writes are drained
+	select {}
+}
+
+// registerRoutes adds the endpoints of the store to mux.
+func registerRoutes(mux *http.ServeMux) {
+	mux.Handle("/is-flaarum", Q(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "yeah-flaarum")
+	}, internal.ROLE_READ))
+	mux.Handle("/metrics", Q(metricsHTTP, internal.ROLE_READ))
+	mux.HandleFunc("/healthz", healthz)
+	mux.HandleFunc("/readyz", readyz)
+
+	// projects
+	mux.Handle("/create-project/{proj}", Q(createProject, internal.ROLE_ADMIN))
+	mux.Handle("/delete-project/{proj}", Q(deleteProject, internal.ROLE_ADMIN))
+	mux.Handle("/list-projects", Q(listProjects, internal.ROLE_READ))
+	mux.Handle("/rename-project/{proj}/{nproj}", Q(renameProject, internal.ROLE_ADMIN))
+	mux.Handle("/backup-project/{proj}", Q(backupProject, internal.ROLE_ADMIN))
+	mux.Handle("/restore-project/{proj}", Q(restoreProject, internal.ROLE_ADMIN))
+
+	// tables
+	mux.Handle("/create-table/{proj}", Q(createTable, internal.ROLE_ADMIN))
+	mux.Handle("/update-table-structure/{proj}", Q(updateTableStructure, internal.ROLE_ADMIN))
+	mux.Handle("/get-current-version-num/{proj}/{tbl}", Q(getCurrentVersionNumHTTP, internal.ROLE_READ))
+	mux.Handle("/get-table-structure/{proj}/{tbl}/{vnum}", Q(getTableStructureHTTP, internal.ROLE_READ))
+	mux.Handle("/list-tables/{proj}", Q(listTables, internal.ROLE_READ))
+	mux.Handle("/delete-table/{proj}/{tbl}", Q(deleteTable, internal.ROLE_ADMIN))
+	mux.Handle("/rename-table/{proj}/{tbl}/{ntbl}", Q(renameTable, internal.ROLE_ADMIN))
+	mux.Handle("/copy-table/{proj}/{tbl}/{ntbl}", Q(copyTable, internal.ROLE_ADMIN))
+	mux.Handle("/get-table-options/{proj}/{tbl}", Q(getTableOptionsHTTP, internal.ROLE_READ))
+	mux.Handle("/set-table-options/{proj}/{tbl}", Q(setTableOptions, internal.ROLE_ADMIN))
+	mux.Handle("/trim-table/{proj}/{tbl}", Q(trimTableHTTP, internal.ROLE_ADMIN))
+	mux.Handle("/reindex-table/{proj}/{tbl}", Q(reindexTableHTTP, internal.ROLE_ADMIN))
+	mux.Handle("/compaction-stats", Q(compactionStatsHTTP, internal.ROLE_READ))
+	mux.Handle("/stats/{proj}", Q(projectStatsHTTP, internal.ROLE_READ))
+	mux.Handle("/stats/{proj}/{tbl}", Q(tableStatsHTTP, internal.ROLE_READ))
+
+	// rows
+	mux.Handle("/insert-row/{proj}/{tbl}", Q(insertRow, internal.ROLE_WRITE))
+	mux.Handle("/upsert-row/{proj}/{tbl}", Q(upsertRow, internal.ROLE_WRITE))
+	mux.Handle("/search-table/{proj}", Q(searchTable, internal.ROLE_READ))
+	mux.Handle("/delete-rows/{proj}", Q(deleteRows, internal.ROLE_WRITE))
+	mux.Handle("/update-rows/{proj}", Q(updateRows, internal.ROLE_WRITE))
+	mux.Handle("/count-rows/{proj}", Q(countRows, internal.ROLE_READ))
+	mux.Handle("/all-rows-count/{proj}/{tbl}", Q(allRowsCount, internal.ROLE_READ))
+	mux.Handle("/row-history/{proj}/{tbl}/{id}", Q(rowHistory, internal.ROLE_READ))
+	mux.Handle("/list-trash/{proj}/{tbl}", Q(listTrash, internal.ROLE_READ))
+	mux.Handle("/restore-rows/{proj}/{tbl}", Q(restoreRows, internal.ROLE_WRITE))
+	mux.Handle("/purge-trash/{proj}/{tbl}", Q(purgeTrash, internal.ROLE_WRITE))
+	mux.Handle("/changes/{proj}", Q(streamChanges, internal.ROLE_READ))
+
+	// webhooks
+	mux.Handle("/add-webhook/{proj}", Q(addWebhook, internal.ROLE_ADMIN))
+	mux.Handle("/list-webhooks/{proj}", Q(listWebhooks, internal.ROLE_ADMIN))
+	mux.Handle("/test-webhook/{proj}/{id}", Q(testWebhook, internal.ROLE_ADMIN))
+	mux.Handle("/remove-webhook/{proj}/{id}", Q(removeWebhook, internal.ROLE_ADMIN))
+
+	// replication
+	mux.Handle("/replication-state", Q(replicationState, internal.ROLE_READ))
+	mux.Handle("/replication-snapshot/{proj}/{tbl}", Q(replicationSnapshot, internal.ROLE_READ))
+	mux.Handle("/replication-status", Q(replicationStatusHTTP, internal.ROLE_READ))
+
+	// modes
+	mux.Handle("/get-mode", Q(getModeHTTP, internal.ROLE_READ))
+	mux.Handle("/set-mode", Q(setMode, masterRole))
+
+	// tokens
+	mux.Handle("/mint-token", Q(mintToken, masterRole))
+	mux.Handle("/rotate-token-secret", Q(rotateTokenSecret, masterRole))
 }
 
-func Q(f func(w http.ResponseWriter, r *http.Request)) http.Handler {
-	return keyEnforcementMiddleware(http.HandlerFunc(f))
+// Q wraps a handler with key enforcement. neededRole is the least role a key must have to
+// call the handler.
+func Q(f func(w http.ResponseWriter, r *http.Request), neededRole string) http.Handler {
+	return keyEnforcementMiddleware(http.HandlerFunc(f), neededRole)
 }
 
-func keyEnforcementMiddleware(next http.Handler) http.Handler {
+func keyEnforcementMiddleware(next http.Handler, neededRole string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusRecordingWriter{ResponseWriter: w, status: http.StatusOK}
+		w = sw
+		info := &requestInfo{id: newRequestId(r)}
+		w.Header().Set(internal.REQUEST_ID_HEADER, info.id)
+		r = r.WithContext(context.WithValue(r.Context(), requestInfoContextKey, info))
+		defer func() {
+			observeRequest(r, sw.status, time.Since(start))
+			logRequest(r, sw.status, time.Since(start))
+		}()
+
 		inProd := internal.GetSetting("in_production")
 		if inProd == "" {
 			panic(errors.New("Have you installed and launched flaarum.store"))
-		} else if inProd == "true" {
-			keyStr := r.FormValue("key-str")
+		}
+
+		certName, err := verifyClientCert(r)
+		if err != nil {
+			http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+			return
+		}
+
+		if replicaWriteBlocked(neededRole) {
+			printReplicaError(w)
+			return
+		}
+
+		keyName := "anonymous"
+		var key internal.APIKey
+		if inProd == "true" {
 			keyPath := internal.GetKeyStrPath()
 			raw, err := os.ReadFile(keyPath)
 			if err != nil {
 				http.Error(w, "Improperly Configured Server", http.StatusInternalServerError)
+				return
 			}
-			if keyStr == string(raw) {
-				// Call the next handler, which can be another middleware in the chain, or the final handlehttp.
-				next.ServeHTTP(w, r)
+
+			var ok bool
+			keyStr := r.FormValue("key-str")
+			authHeader := r.Header.Get("Authorization")
+			if strings.HasPrefix(authHeader, "Bearer ") {
+				claims, err := internal.VerifyToken(strings.TrimPrefix(authHeader, "Bearer "))
+				if err != nil {
+					http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
+					return
+				}
+				key, ok = claims.AsAPIKey(), true
+			} else if subtle.ConstantTimeCompare([]byte(keyStr), raw) == 1 {
+				key, ok = internal.APIKey{Name: "master", Role: masterRole, Projects: []string{"*"}}, true
 			} else {
+				key, ok = internal.FindAPIKey(keyStr)
+			}
+
+			if !ok && certName != "" {
+				key, ok = internal.FindAPIKeyByName(certName)
+			}
+
+			if !ok {
 				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
 			}
+			if key.Role != masterRole && !keyAllowsPath(key, r, neededRole) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			keyName = key.Name
+		}
+
+		doneWrite := trackWrite(r, neededRole)
+		defer doneWrite()
+		if mode := modeBlocks(r, neededRole); mode != "" {
+			printModeError(w, mode)
+			return
+		}
 
-		} else {
-			// Call the next handler, which can be another middleware in the chain, or the final handlehttp.
-			next.ServeHTTP(w, r)
+		ctx := context.WithValue(r.Context(), keyNameContextKey, keyName)
+		if inProd == "true" {
+			ctx = context.WithValue(ctx, keyContextKey, key)
 		}
+		ctx = context.WithValue(ctx, auditDetailsContextKey, &auditDetails{})
+		r = r.WithContext(ctx)
+
+		// Call the next handler, which can be another middleware in the chain, or the final handlehttp.
+		next.ServeHTTP(sw, r)
 
+		if neededRole != internal.ROLE_READ && sw.status == http.StatusOK {
+			writeAuditEntry(r, keyName)
+		}
 	})
 }
+
+type contextKey string
+
+// masterRole is the role of the key in 'flaarum.keyfile'. Handlers which need it can only be
+// called with that key.
+const masterRole = "master"
+
+const (
+	keyNameContextKey contextKey = "key-name"
+	keyContextKey     contextKey = "key"
+)
+
+// anyKeyPaths are the paths without a project which the keys of some projects can call too, as
+// they tell nothing about the projects or only about those of the key.
+var anyKeyPaths = []string{"/is-flaarum", "/list-projects", "/get-mode"}
+
+// keyAllowsPath reports whether a key can call the endpoint of a request. Endpoints without a
+// project need a key of all projects, except for anyKeyPaths.
+func keyAllowsPath(key internal.APIKey, r *http.Request, neededRole string) bool {
+	projName := r.PathValue("proj")
+	if projName == "" && slices.Contains(anyKeyPaths, r.URL.Path) {
+		return internal.RoleIncludes(key.Role, neededRole)
+	}
+	return key.Allows(projName, neededRole)
+}
+
+// requestAllows reports whether the key of a request can perform an operation needing neededRole
+// on the project projName. It is used by handlers which need more than the role of their route for
+// some of their operations. Without in_production every request is allowed.
+func requestAllows(r *http.Request, projName, neededRole string) bool {
+	key, ok := r.Context().Value(keyContextKey).(internal.APIKey)
+	if !ok {
+		return true
+	}
+	return key.Role == masterRole || key.Allows(projName, neededRole)
+}
+
+// statusRecordingWriter remembers the status code written by a handler.
+type statusRecordingWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusRecordingWriter) WriteHeader(status int) {
+	sw.status = status
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+// Flush lets streaming handlers like streamChanges flush through the wrapper.
+func (sw *statusRecordingWriter) Flush() {
+	if flusher, ok := sw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
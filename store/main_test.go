@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// checkKeyScopes checks that a path without a project can be called by the keys of all projects and
+// not by the keys of some projects.
+func checkKeyScopes(t *testing.T, path string) {
+	t.Helper()
+
+	ts := newProdTestStore(t)
+
+	status, body := post(t, ts, path, url.Values{"key-str": {testProjectKeyStr}})
+	if status != http.StatusForbidden {
+		t.Errorf("%s with a key of one project: status %d, expected %d: %s", path, status, http.StatusForbidden, body)
+	}
+	mustPost(t, ts, path, url.Values{"key-str": {testAllKeyStr}})
+	mustPost(t, ts, path, url.Values{"key-str": {testMasterKeyStr}})
+}
+
+func TestReplicationStateNeedsAllProjects(t *testing.T) {
+	checkKeyScopes(t, "/replication-state")
+}
+
+func TestAnyKeyPaths(t *testing.T) {
+	ts := newProdTestStore(t)
+
+	for _, path := range anyKeyPaths {
+		mustPost(t, ts, path, url.Values{"key-str": {testProjectKeyStr}})
+	}
+
+	status, _ := post(t, ts, "/is-flaarum", url.Values{"key-str": {"not-a-key"}})
+	if status != http.StatusForbidden {
+		t.Errorf("/is-flaarum with an unknown key: status %d, expected %d", status, http.StatusForbidden)
+	}
+}
+
+func TestMetricsNeedAllProjects(t *testing.T) {
+	checkKeyScopes(t, "/metrics")
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/saenuma/flaarum/internal"
+)
+
+// the tables being trimmed or reindexed. A table is rebuilt by one trim or reindex at a time as
+// they share the table's rebuild folders.
+var (
+	rebuildsMutex sync.Mutex
+	rebuilds      = make(map[string]bool)
+)
+
+func startRebuild(projName, tableName string) bool {
+	rebuildsMutex.Lock()
+	defer rebuildsMutex.Unlock()
+
+	if rebuilds[projName+"/"+tableName] {
+		return false
+	}
+	rebuilds[projName+"/"+tableName] = true
+	return true
+}
+
+func finishRebuild(projName, tableName string) {
+	rebuildsMutex.Lock()
+	delete(rebuilds, projName+"/"+tableName)
+	rebuildsMutex.Unlock()
+}
+
+// rebuildTableLive rebuilds the files of a table while it is in use. The table is read locked
+// while its files are copied to a snapshot and build writes the rebuilt files from the snapshot
+// without any lock. The table is then write locked while the writes made in the meantime are
+// applied from the change log and the rebuilt files are swapped in. compactTrash is set by trims,
+// which purge the rows kept in the trash for longer than the retention period.
+func rebuildTableLive(projName, tableName string, build func(projName, srcTableName, outTableName string) error,
+	compactTrash bool) error {
+	if !startRebuild(projName, tableName) {
+		return errors.New(fmt.Sprintf("Table '%s' of Project '%s' is already being trimmed or reindexed.", tableName, projName))
+	}
+	defer finishRebuild(projName, tableName)
+
+	srcTableName := tableName + "_rebuild_src"
+	outTableName := tableName + "_rebuild_tmp"
+	defer os.RemoveAll(internal.GetTablePath(projName, srcTableName))
+	defer os.RemoveAll(internal.GetTablePath(projName, outTableName))
+
+	tableLocks.RLock(projName, tableName)
+	err := internal.CopyTableFolder(projName, tableName, srcTableName)
+	snapshotSeq := internal.GetLastSeq(projName, tableName)
+	snapshotVersion, _ := internal.GetCurrentVersionNum(projName, tableName)
+	tableLocks.RUnlock(projName, tableName)
+	if err != nil {
+		return err
+	}
+
+	err = build(projName, srcTableName, outTableName)
+	if err != nil {
+		return err
+	}
+
+	tableLocks.Lock(projName, tableName)
+	defer tableLocks.Unlock(projName, tableName)
+
+	if !internal.DoesTableExists(projName, tableName) {
+		return errors.New(fmt.Sprintf("Table '%s' of Project '%s' was deleted during the rebuild.", tableName, projName))
+	}
+	currentVersion, _ := internal.GetCurrentVersionNum(projName, tableName)
+	if currentVersion != snapshotVersion {
+		return errors.New("the table structure changed during the rebuild. Run it again.")
+	}
+
+	if internal.GetTrimmedSeq(projName, tableName) > snapshotSeq {
+		return errors.New("the change log was compacted during the rebuild. Run it again.")
+	}
+	changes, err := internal.ReadChangesSince(projName, tableName, snapshotSeq)
+	if err != nil {
+		return err
+	}
+	for _, entry := range changes {
+		entry.Table = outTableName
+		_, err = internal.ApplyChangeToRows(projName, entry)
+		if err != nil {
+			return err
+		}
+	}
+
+	// history, trash, options and the change log were written to the table during the rebuild
+	tablePath := internal.GetTablePath(projName, tableName)
+	outTablePath := internal.GetTablePath(projName, outTableName)
+	dirFIs, err := os.ReadDir(tablePath)
+	if err != nil {
+		return errors.Wrap(err, "directory read error")
+	}
+	for _, dirFI := range dirFIs {
+		name := dirFI.Name()
+		if !internal.IsCopiedTableFile(name) && name != "lastId.txt" {
+			continue
+		}
+		raw, err := os.ReadFile(filepath.Join(tablePath, name))
+		if err != nil {
+			return errors.Wrap(err, "file read error")
+		}
+		err = os.WriteFile(filepath.Join(outTablePath, name), raw, 0777)
+		if err != nil {
+			return errors.Wrap(err, "os error")
+		}
+	}
+
+	if compactTrash {
+		err = internal.CompactTrash(projName, outTableName, internal.TrashCutoff(projName, tableName))
+		if err != nil {
+			return err
+		}
+	}
+
+	return internal.SwapTableFolder(projName, tableName, outTableName)
+}
+
+func trimTableHTTP(w http.ResponseWriter, r *http.Request) {
+	projName := r.PathValue("proj")
+	tableName := r.PathValue("tbl")
+
+	if !doesTableExists(projName, tableName) {
+		printValError(w, errors.New(fmt.Sprintf("Table '%s' of Project '%s' does not exists.", tableName, projName)))
+		return
+	}
+
+	err := rebuildTableLive(projName, tableName, internal.TrimTableFiles, true)
+	if err != nil {
+		internal.PrintError(w, err)
+		return
+	}
+
+	fmt.Fprintf(w, "ok")
+}
+
+func reindexTableHTTP(w http.ResponseWriter, r *http.Request) {
+	projName := r.PathValue("proj")
+	tableName := r.PathValue("tbl")
+
+	if !doesTableExists(projName, tableName) {
+		printValError(w, errors.New(fmt.Sprintf("Table '%s' of Project '%s' does not exists.", tableName, projName)))
+		return
+	}
+
+	err := rebuildTableLive(projName, tableName, internal.ReindexTableFiles, false)
+	if err != nil {
+		internal.PrintError(w, err)
+		return
+	}
+
+	fmt.Fprintf(w, "ok")
+}
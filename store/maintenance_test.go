@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/saenuma/flaarum/internal"
+)
+
+func TestReindexAndTrimTable(t *testing.T) {
+	ts := newTestStore(t)
+	mustPost(t, ts, "/create-table/first_proj", url.Values{"stmt": {"table: notes\nfields:\n  title string\n  tag string\n  score int\n::\n"}})
+	for i := 0; i < 20; i++ {
+		mustPost(t, ts, "/insert-row/first_proj/notes", url.Values{
+			"title": {fmt.Sprintf("note%d", i)},
+			"tag":   {fmt.Sprintf("tag%d", i%3)},
+			"score": {fmt.Sprint(i)},
+		})
+	}
+	mustPost(t, ts, "/delete-rows/first_proj", url.Values{"stmt": {"table: notes\nwhere:\n  tag = tag0"}})
+
+	for _, path := range []string{"/reindex-table/first_proj/notes", "/trim-table/first_proj/notes"} {
+		mustPost(t, ts, path, nil)
+
+		if rows := searchRows(t, ts, "table: notes\nwhere:\n  tag = tag1"); len(rows) != 7 {
+			t.Errorf("after %s: %d rows have 'tag1', expected 7", path, len(rows))
+		}
+		if rows := searchRows(t, ts, "table: notes\nwhere:\n  tag = tag0"); len(rows) != 0 {
+			t.Errorf("after %s: the deleted rows are found", path)
+		}
+	}
+}
+
+func TestRebuildCatchesUpWithWrites(t *testing.T) {
+	ts := newTestStore(t)
+	mustPost(t, ts, "/create-table/first_proj", url.Values{"stmt": {"table: notes\nfields:\n  title string\n  tag string\n::\n"}})
+	for i := 0; i < 5; i++ {
+		mustPost(t, ts, "/insert-row/first_proj/notes", url.Values{"title": {fmt.Sprintf("note%d", i)}, "tag": {"old"}})
+	}
+
+	// the writes made while the snapshot is rebuilt are applied to the rebuilt files
+	build := func(projName, srcTableName, outTableName string) error {
+		mustPost(t, ts, "/insert-row/first_proj/notes", url.Values{"title": {"during"}, "tag": {"new"}})
+		mustPost(t, ts, "/update-rows/first_proj", url.Values{
+			"stmt":   {"table: notes\nwhere:\n  title = note1"},
+			"set1_k": {"tag"},
+			"set1_v": {"new"},
+		})
+		mustPost(t, ts, "/delete-rows/first_proj", url.Values{"stmt": {"table: notes\nwhere:\n  title = note2"}})
+		return internal.ReindexTableFiles(projName, srcTableName, outTableName)
+	}
+	err := rebuildTableLive("first_proj", "notes", build, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rows := searchRows(t, ts, "table: notes\nwhere:\n  tag = new"); len(rows) != 2 {
+		t.Errorf("%d rows have the tag 'new', expected 2: %v", len(rows), rows)
+	}
+	if rows := searchRows(t, ts, "table: notes\nwhere:\n  title = note2"); len(rows) != 0 {
+		t.Errorf("the row deleted during the rebuild is found: %v", rows)
+	}
+	if rows := searchRows(t, ts, "table: notes"); len(rows) != 5 {
+		t.Errorf("the table has %d rows, expected 5", len(rows))
+	}
+
+	for _, folderName := range []string{"notes_rebuild_src", "notes_rebuild_tmp"} {
+		if internal.DoesPathExists(internal.GetTablePath("first_proj", folderName)) {
+			t.Errorf("the rebuild left the folder '%s'", folderName)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/saenuma/flaarum/internal"
+)
+
+// histogram is a Prometheus histogram. counts[i] is the number of observations not above
+// buckets[i]; the +Inf bucket is count.
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, bucket := range h.buckets {
+		if v <= bucket {
+			h.counts[i] += 1
+		}
+	}
+	h.sum += v
+	h.count += 1
+}
+
+func (h *histogram) write(w io.Writer, name, labels string) {
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	for i, bucket := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{%s%sle=\"%g\"} %d\n", name, labels, sep, bucket, h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, sep, h.count)
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %g\n", name, labels, h.sum)
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.count)
+}
+
+var (
+	durationBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	rowsBuckets     = []float64{1, 10, 100, 1000, 10000, 100000, 1000000}
+)
+
+type requestKey struct {
+	route string
+	code  int
+}
+
+// the metrics of the store since it started
+var (
+	metricsMutex     sync.Mutex
+	requestsTotal    = make(map[requestKey]uint64)
+	requestDurations = make(map[string]*histogram)
+	errorsTotal      = make(map[string]uint64)
+	lockWaits        = map[string]*histogram{"read": newHistogram(durationBuckets), "write": newHistogram(durationBuckets)}
+	rowsScanned      = newHistogram(rowsBuckets)
+)
+
+// metricsRoute returns the route of a request without its path values eg. '/search-table'.
+func metricsRoute(r *http.Request) string {
+	route := r.Pattern
+	if route == "" {
+		route = r.URL.Path
+	}
+	if i := strings.Index(route[1:], "/"); i != -1 {
+		route = route[:i+1]
+	}
+	return route
+}
+
+// errorType groups the error statuses of the store. Validation errors are written by printValError
+// and internal errors by internal.PrintError.
+func errorType(status int) string {
+	switch {
+	case status == http.StatusBadRequest:
+		return "validation"
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return "auth"
+	case status == http.StatusServiceUnavailable:
+		return "unavailable"
+	case status >= 500:
+		return "internal"
+	case status >= 400:
+		return "other"
+	}
+	return ""
+}
+
+func observeRequest(r *http.Request, status int, duration time.Duration) {
+	route := metricsRoute(r)
+
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+
+	requestsTotal[requestKey{route, status}] += 1
+	if _, ok := requestDurations[route]; !ok {
+		requestDurations[route] = newHistogram(durationBuckets)
+	}
+	requestDurations[route].observe(duration.Seconds())
+	if errType := errorType(status); errType != "" {
+		errorsTotal[errType] += 1
+	}
+}
+
+func observeRowsScanned(count int) {
+	metricsMutex.Lock()
+	rowsScanned.observe(float64(count))
+	metricsMutex.Unlock()
+}
+
+// timedRWMutex is the mutex of a table. It records how long Lock and RLock wait.
+type timedRWMutex struct {
+	sync.RWMutex
+}
+
+func (m *timedRWMutex) Lock() {
+	start := time.Now()
+	m.RWMutex.Lock()
+	observeLockWait("write", time.Since(start))
+}
+
+func (m *timedRWMutex) RLock() {
+	start := time.Now()
+	m.RWMutex.RLock()
+	observeLockWait("read", time.Since(start))
+}
+
+func observeLockWait(kind string, wait time.Duration) {
+	metricsMutex.Lock()
+	lockWaits[kind].observe(wait.Seconds())
+	metricsMutex.Unlock()
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// writeFileSizeMetrics writes the sizes of the data and indexes files of every table.
+func writeFileSizeMetrics(w io.Writer) {
+	projsMutex.RLock()
+	defer projsMutex.RUnlock()
+
+	projs, err := listReplicatedProjects()
+	if err != nil {
+		return
+	}
+
+	fmt.Fprintln(w, "# HELP flaarum_table_data_bytes Size of the data file of a table.")
+	fmt.Fprintln(w, "# TYPE flaarum_table_data_bytes gauge")
+	indexLines := make([]string, 0)
+	for _, projName := range projs {
+		tables, err := internal.ListTables(projName)
+		if err != nil {
+			continue
+		}
+		for _, tableName := range tables {
+			dirFIs, err := os.ReadDir(internal.GetTablePath(projName, tableName))
+			if err != nil {
+				continue
+			}
+
+			var dataSize, indexesSize int64
+			for _, dirFI := range dirFIs {
+				info, err := dirFI.Info()
+				if err != nil {
+					continue
+				}
+				if dirFI.Name() == "data.flaa2" {
+					dataSize = info.Size()
+				} else if strings.HasSuffix(dirFI.Name(), "_indexes.flaa2") {
+					indexesSize += info.Size()
+				}
+			}
+
+			labels := fmt.Sprintf("project=%q,table=%q", projName, tableName)
+			fmt.Fprintf(w, "flaarum_table_data_bytes{%s} %d\n", labels, dataSize)
+			indexLines = append(indexLines, fmt.Sprintf("flaarum_table_indexes_bytes{%s} %d\n", labels, indexesSize))
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP flaarum_table_indexes_bytes Total size of the indexes files of a table.")
+	fmt.Fprintln(w, "# TYPE flaarum_table_indexes_bytes gauge")
+	for _, line := range indexLines {
+		io.WriteString(w, line)
+	}
+}
+
+// metricsHTTP serves the metrics of the store in the Prometheus text format. They cover every
+// project, so only the keys of all projects can read them.
+func metricsHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	metricsMutex.Lock()
+
+	fmt.Fprintln(w, "# HELP flaarum_requests_total Requests served by route and status code.")
+	fmt.Fprintln(w, "# TYPE flaarum_requests_total counter")
+	keys := make([]requestKey, 0, len(requestsTotal))
+	for key := range requestsTotal {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b requestKey) int {
+		if a.route != b.route {
+			return strings.Compare(a.route, b.route)
+		}
+		return a.code - b.code
+	})
+	for _, key := range keys {
+		fmt.Fprintf(w, "flaarum_requests_total{route=%q,code=\"%d\"} %d\n", key.route, key.code, requestsTotal[key])
+	}
+
+	fmt.Fprintln(w, "# HELP flaarum_request_duration_seconds Time taken to serve requests by route.")
+	fmt.Fprintln(w, "# TYPE flaarum_request_duration_seconds histogram")
+	for _, route := range sortedKeys(requestDurations) {
+		requestDurations[route].write(w, "flaarum_request_duration_seconds", fmt.Sprintf("route=%q", route))
+	}
+
+	fmt.Fprintln(w, "# HELP flaarum_errors_total Error responses by type.")
+	fmt.Fprintln(w, "# TYPE flaarum_errors_total counter")
+	for _, errType := range sortedKeys(errorsTotal) {
+		fmt.Fprintf(w, "flaarum_errors_total{type=%q} %d\n", errType, errorsTotal[errType])
+	}
+
+	fmt.Fprintln(w, "# HELP flaarum_lock_wait_seconds Time waited for the locks of tables.")
+	fmt.Fprintln(w, "# TYPE flaarum_lock_wait_seconds histogram")
+	for _, kind := range sortedKeys(lockWaits) {
+		lockWaits[kind].write(w, "flaarum_lock_wait_seconds", fmt.Sprintf("kind=%q", kind))
+	}
+
+	fmt.Fprintln(w, "# HELP flaarum_search_rows_scanned Rows read per search.")
+	fmt.Fprintln(w, "# TYPE flaarum_search_rows_scanned histogram")
+	rowsScanned.write(w, "flaarum_search_rows_scanned", "")
+
+	metricsMutex.Unlock()
+
+	writeFileSizeMetrics(w)
+}
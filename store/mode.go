@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/saenuma/flaarum/internal"
+	"github.com/saenuma/flaarumlib"
+	"github.com/saenuma/zazabul"
+)
+
+// modes of the store. In read_only mode writes are refused; in maintenance mode every request is.
+const (
+	MODE_NORMAL      = "normal"
+	MODE_READ_ONLY   = "read_only"
+	MODE_MAINTENANCE = "maintenance"
+)
+
+var validModes = []string{MODE_NORMAL, MODE_READ_ONLY, MODE_MAINTENANCE}
+
+// scopedModes holds the modes of projects (keyed 'proj') and tables (keyed 'proj/tbl') set with
+// '/set-mode'. They are not persisted, so a restart clears them.
+var (
+	scopedModesMutex sync.RWMutex
+	scopedModes      = make(map[string]string)
+)
+
+// writesInFlight counts the writes being served by the generation of modes in which they passed
+// modeBlocks. '/set-mode' starts a new generation and waits for the writes of the older ones.
+var (
+	writesMutex      sync.Mutex
+	writesDone       = sync.NewCond(&writesMutex)
+	writesGeneration int64
+	writesInFlight   = make(map[int64]int)
+)
+
+// paths which are served in every mode
+var modeExemptPaths = []string{"/is-flaarum", "/get-mode", "/set-mode", "/metrics"}
+
+// getGlobalMode returns the 'mode' setting. Config files written before the setting existed are
+// in normal mode.
+func getGlobalMode() string {
+	mode := internal.GetSetting("mode")
+	if mode == "" {
+		return MODE_NORMAL
+	}
+	return mode
+}
+
+// requestTable returns the table a request works on, either from its path or from its statement.
+func requestTable(r *http.Request) string {
+	if tableName := r.PathValue("tbl"); tableName != "" {
+		return tableName
+	}
+
+	if stmt := r.FormValue("stmt"); stmt != "" {
+		stmtStruct, err := flaarumlib.ParseSearchStmt(stmt)
+		if err == nil {
+			return stmtStruct.TableName
+		}
+	}
+
+	return ""
+}
+
+// modeBlocks returns the mode refusing a request needing neededRole, or an empty string if the
+// request can be served.
+func modeBlocks(r *http.Request, neededRole string) string {
+	if slices.Contains(modeExemptPaths, r.URL.Path) {
+		return ""
+	}
+
+	blocks := func(mode string) bool {
+		return mode == MODE_MAINTENANCE || (mode == MODE_READ_ONLY && neededRole != internal.ROLE_READ)
+	}
+
+	if mode := getGlobalMode(); blocks(mode) {
+		return mode
+	}
+
+	projName := r.PathValue("proj")
+	if projName == "" {
+		return ""
+	}
+
+	scopedModesMutex.RLock()
+	defer scopedModesMutex.RUnlock()
+	if len(scopedModes) == 0 {
+		return ""
+	}
+
+	if mode := scopedModes[projName]; blocks(mode) {
+		return mode
+	}
+	if tableName := requestTable(r); tableName != "" {
+		if mode := scopedModes[projName+"/"+tableName]; blocks(mode) {
+			return mode
+		}
+	}
+
+	return ""
+}
+
+// trackWrite counts a request needing neededRole as in flight until the returned function is
+// called. It must be called before modeBlocks, so that a write let in by a mode is counted in its
+// generation.
+func trackWrite(r *http.Request, neededRole string) func() {
+	if neededRole == internal.ROLE_READ || slices.Contains(modeExemptPaths, r.URL.Path) {
+		return func() {}
+	}
+
+	return startWrite()
+}
+
+// startWrite counts a write as in flight until the returned function is called.
+func startWrite() func() {
+	writesMutex.Lock()
+	generation := writesGeneration
+	writesInFlight[generation] += 1
+	writesMutex.Unlock()
+
+	return func() {
+		writesMutex.Lock()
+		writesInFlight[generation] -= 1
+		if writesInFlight[generation] == 0 {
+			delete(writesInFlight, generation)
+		}
+		writesMutex.Unlock()
+		writesDone.Broadcast()
+	}
+}
+
+// waitForWrites returns once the writes which started before it was called have finished. Writes
+// started after it see the mode set before the call.
+func waitForWrites() {
+	writesMutex.Lock()
+	defer writesMutex.Unlock()
+
+	writesGeneration += 1
+	current := writesGeneration
+	for {
+		older := false
+		for generation := range writesInFlight {
+			if generation < current {
+				older = true
+				break
+			}
+		}
+		if !older {
+			return
+		}
+		writesDone.Wait()
+	}
+}
+
+// refusesWrites tells if the store, or a project or table with '/set-mode', is in a mode other than
+// normal, in which its files must not be rewritten.
+func refusesWrites(projName, tableName string) bool {
+	if getGlobalMode() != MODE_NORMAL {
+		return true
+	}
+
+	scopedModesMutex.RLock()
+	defer scopedModesMutex.RUnlock()
+	return scopedModes[projName] != "" || scopedModes[projName+"/"+tableName] != ""
+}
+
+func printModeError(w http.ResponseWriter, mode string) {
+	message := "Service Unavailable: the store is in maintenance. Try again later."
+	if mode == MODE_READ_ONLY {
+		message = "Service Unavailable: the store is read-only. Writes are not accepted."
+	}
+	http.Error(w, message, http.StatusServiceUnavailable)
+}
+
+// getModeHTTP returns the mode of the store and the modes of the projects and tables the key can read.
+func getModeHTTP(w http.ResponseWriter, r *http.Request) {
+	scopedModesMutex.RLock()
+	scoped := make(map[string]string)
+	for scope, mode := range scopedModes {
+		projName, _, _ := strings.Cut(scope, "/")
+		if requestAllows(r, projName, internal.ROLE_READ) {
+			scoped[scope] = mode
+		}
+	}
+	scopedModesMutex.RUnlock()
+
+	jsonBytes, err := json.Marshal(map[string]any{"mode": getGlobalMode(), "scoped": scoped})
+	if err != nil {
+		internal.PrintError(w, errors.Wrap(err, "json error"))
+		return
+	}
+
+	w.Write(jsonBytes)
+}
+
+// setMode switches the mode of the store, or of a project or table when the 'project' form value
+// is set. The mode of the store is saved to 'flaarum.zconf'. When the new mode refuses writes, it
+// returns once the writes let in by the previous mode have finished.
+func setMode(w http.ResponseWriter, r *http.Request) {
+	mode := r.FormValue("mode")
+	projName := r.FormValue("project")
+	tableName := r.FormValue("table")
+
+	if !slices.Contains(validModes, mode) {
+		printValError(w, errors.New(fmt.Sprintf("mode '%s' is not one of '%s'", mode, strings.Join(validModes, "', '"))))
+		return
+	}
+
+	if projName == "" {
+		if tableName != "" {
+			printValError(w, errors.New("a table's mode needs its project"))
+			return
+		}
+
+		confPath, err := internal.GetConfigPath()
+		if err != nil {
+			internal.PrintError(w, err)
+			return
+		}
+		conf, err := zazabul.LoadConfigFile(confPath)
+		if err != nil {
+			internal.PrintError(w, err)
+			return
+		}
+		conf.Update(map[string]string{"mode": mode})
+		err = conf.Write(confPath)
+		if err != nil {
+			internal.PrintError(w, err)
+			return
+		}
+
+		if mode != MODE_NORMAL {
+			waitForWrites()
+		}
+
+		setAuditDetails(r, "", "mode "+mode, nil)
+		fmt.Fprintf(w, "ok")
+		return
+	}
+
+	scope := projName
+	if tableName != "" {
+		scope = projName + "/" + tableName
+	}
+
+	scopedModesMutex.Lock()
+	if mode == MODE_NORMAL {
+		delete(scopedModes, scope)
+	} else {
+		scopedModes[scope] = mode
+	}
+	scopedModesMutex.Unlock()
+
+	if mode != MODE_NORMAL {
+		waitForWrites()
+	}
+
+	setAuditDetails(r, tableName, "mode "+mode+" for "+scope, nil)
+	fmt.Fprintf(w, "ok")
+}
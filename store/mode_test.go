@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/saenuma/flaarum/internal"
+)
+
+func TestGetModeOfKey(t *testing.T) {
+	ts := newProdTestStore(t)
+	mustPost(t, ts, "/create-project/other", url.Values{"key-str": {testMasterKeyStr}})
+	mustPost(t, ts, "/set-mode", url.Values{"key-str": {testMasterKeyStr}, "mode": {MODE_READ_ONLY}, "project": {"other"}})
+	t.Cleanup(func() {
+		scopedModesMutex.Lock()
+		clear(scopedModes)
+		scopedModesMutex.Unlock()
+	})
+
+	getScoped := func(keyStr string) map[string]string {
+		body := mustPost(t, ts, "/get-mode", url.Values{"key-str": {keyStr}})
+		var out struct {
+			Mode   string            `json:"mode"`
+			Scoped map[string]string `json:"scoped"`
+		}
+		err := json.Unmarshal([]byte(body), &out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out.Scoped
+	}
+
+	if scoped := getScoped(testProjectKeyStr); len(scoped) != 0 {
+		t.Errorf("a key of 'first_proj' saw the modes %v", scoped)
+	}
+	if scoped := getScoped(testAllKeyStr); scoped["other"] != MODE_READ_ONLY {
+		t.Errorf("a key of all projects saw the modes %v", scoped)
+	}
+}
+
+func TestWaitForWritesWaitsForOlderWrites(t *testing.T) {
+	r := httptest.NewRequest("POST", "/insert-row/first_proj/users", nil)
+	doneOld := trackWrite(r, internal.ROLE_WRITE)
+
+	waited := make(chan struct{})
+	go func() {
+		waitForWrites()
+		close(waited)
+	}()
+
+	select {
+	case <-waited:
+		t.Fatal("waitForWrites returned while an older write was in flight")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// a write started after the switch does not hold it back
+	doneNew := trackWrite(r, internal.ROLE_WRITE)
+	defer doneNew()
+	doneOld()
+
+	select {
+	case <-waited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("waitForWrites did not return after the older write finished")
+	}
+}
@@ -61,30 +61,14 @@ func deleteProject(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		for _, tableName := range existingTables {
-			createTableMutexIfNecessary(projName, tableName)
-			fullTableName := projName + ":" + tableName
-			tablesMutexes[fullTableName].Lock()
-		}
+		unlock := tableLocks.LockTables(projName, existingTables)
+		defer unlock()
 
 		err = os.RemoveAll(filepath.Join(dataPath, projName))
 		if err != nil {
-			for _, tableName := range existingTables {
-				createTableMutexIfNecessary(projName, tableName)
-				fullTableName := projName + ":" + tableName
-				tablesMutexes[fullTableName].Unlock()
-			}
 			internal.PrintError(w, errors.Wrap(err, "delete directory failed."))
 			return
 		}
-
-		for _, tableName := range existingTables {
-			createTableMutexIfNecessary(projName, tableName)
-			fullTableName := projName + ":" + tableName
-			tablesMutexes[fullTableName].Unlock()
-
-			delete(tablesMutexes, fullTableName)
-		}
 	}
 
 	fmt.Fprintf(w, "ok")
@@ -104,11 +88,13 @@ func listProjects(w http.ResponseWriter, r *http.Request) {
 
 	projs := make([]string, 0)
 	for _, fi := range fis {
-		if fi.IsDir() && !isInternalProjectName(fi.Name()) {
+		if fi.IsDir() && !isInternalProjectName(fi.Name()) && requestAllows(r, fi.Name(), internal.ROLE_READ) {
 			projs = append(projs, fi.Name())
 		}
 	}
-	projs = append(projs, "first_proj")
+	if requestAllows(r, "first_proj", internal.ROLE_READ) {
+		projs = append(projs, "first_proj")
+	}
 
 	jsonBytes, err := json.Marshal(projs)
 	if err != nil {
@@ -154,38 +140,26 @@ func renameProject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	for _, tableName := range existingTables {
-		createTableMutexIfNecessary(projName, tableName)
-		fullTableName := projName + ":" + tableName
-		tablesMutexes[fullTableName].Lock()
-	}
+	// the tables are locked under both names, as they are visible under the new one once renamed
+	unlock := tableLocks.LockTables(projName, existingTables)
+	defer unlock()
+	unlockNew := tableLocks.LockTables(newProjName, existingTables)
+	defer unlockNew()
 
 	oldPath := filepath.Join(dataPath, projName)
 	newPath := filepath.Join(dataPath, newProjName)
 	err = os.Rename(oldPath, newPath)
 	if err != nil {
-		for _, tableName := range existingTables {
-			createTableMutexIfNecessary(projName, tableName)
-			fullTableName := projName + ":" + tableName
-			tablesMutexes[fullTableName].Unlock()
-		}
 		internal.PrintError(w, errors.Wrap(err, "renamed failed."))
 		return
 	}
-
-	for _, tableName := range existingTables {
-		createTableMutexIfNecessary(projName, tableName)
-		fullTableName := projName + ":" + tableName
-		tablesMutexes[fullTableName].Unlock()
-
-		delete(tablesMutexes, fullTableName)
-	}
+	setAuditDetails(r, "", "rename to "+newProjName, nil)
 
 	fmt.Fprintf(w, "ok")
 }
 
 func isInternalProjectName(projName string) bool {
-	internalNames := []string{"keyfile", "first_proj", "flaarum_exports"}
+	internalNames := []string{"keyfile", "first_proj", "flaarum_exports", "flaarum_backups", "flaarum_lost"}
 
 	for _, iName := range internalNames {
 		if projName == iName {
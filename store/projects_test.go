@@ -0,0 +1,31 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"slices"
+	"testing"
+)
+
+func TestListProjectsOfKey(t *testing.T) {
+	ts := newProdTestStore(t)
+	mustPost(t, ts, "/create-project/other", url.Values{"key-str": {testMasterKeyStr}})
+
+	listProjects := func(keyStr string) []string {
+		body := mustPost(t, ts, "/list-projects", url.Values{"key-str": {keyStr}})
+		projs := make([]string, 0)
+		err := json.Unmarshal([]byte(body), &projs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		slices.Sort(projs)
+		return projs
+	}
+
+	if projs := listProjects(testProjectKeyStr); !slices.Equal(projs, []string{"first_proj"}) {
+		t.Errorf("a key of 'first_proj' listed %v", projs)
+	}
+	if projs := listProjects(testAllKeyStr); !slices.Equal(projs, []string{"first_proj", "other"}) {
+		t.Errorf("a key of all projects listed %v", projs)
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/saenuma/flaarum/internal"
+)
+
+// the time between the synchronizations of a replica with its primary
+const replicationInterval = 2 * time.Second
+
+// replicationStatus is returned by '/replication-status'.
+type replicationStatus struct {
+	Role    string `json:"role"`
+	Primary string `json:"primary,omitempty"`
+	// CaughtUp is the last time the replica had applied every change the primary had when the
+	// synchronization started. LagSeconds is the time since then.
+	CaughtUp   string  `json:"caught_up,omitempty"`
+	LagSeconds float64 `json:"lag_seconds"`
+	Pending    int64   `json:"pending_changes"`
+	LastError  string  `json:"last_error,omitempty"`
+}
+
+var (
+	replicationMutex    sync.Mutex
+	replicationCaughtUp time.Time
+	replicationPending  int64
+	replicationLastErr  string
+)
+
+func isReplica() bool {
+	return internal.GetSetting("replica_of") != ""
+}
+
+func replicationStatusHTTP(w http.ResponseWriter, r *http.Request) {
+	status := replicationStatus{Role: "primary"}
+	if isReplica() {
+		replicationMutex.Lock()
+		status.Role = "replica"
+		status.Primary = internal.GetSetting("replica_of")
+		status.Pending = replicationPending
+		status.LastError = replicationLastErr
+		if !replicationCaughtUp.IsZero() {
+			status.CaughtUp = replicationCaughtUp.Format(time.RFC3339)
+			status.LagSeconds = time.Since(replicationCaughtUp).Seconds()
+		}
+		replicationMutex.Unlock()
+	}
+
+	jsonBytes, err := json.Marshal(status)
+	if err != nil {
+		internal.PrintError(w, errors.Wrap(err, "json error"))
+		return
+	}
+
+	w.Write(jsonBytes)
+}
+
+// listReplicatedProjects returns the projects a replica copies from its primary.
+func listReplicatedProjects() ([]string, error) {
+	dataPath, _ := internal.GetRootPath()
+	fis, err := os.ReadDir(dataPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "ioutil error")
+	}
+
+	projs := []string{"first_proj"}
+	for _, fi := range fis {
+		if fi.IsDir() && !isInternalProjectName(fi.Name()) {
+			projs = append(projs, fi.Name())
+		}
+	}
+	return projs, nil
+}
+
+// replicationState returns the state of every table on this store for its replicas.
+func replicationState(w http.ResponseWriter, r *http.Request) {
+	projsMutex.RLock()
+	defer projsMutex.RUnlock()
+
+	projs, err := listReplicatedProjects()
+	if err != nil {
+		internal.PrintError(w, err)
+		return
+	}
+
+	state := make(internal.ReplicationState)
+	for _, projName := range projs {
+		tables, err := internal.ListTables(projName)
+		if err != nil {
+			internal.PrintError(w, err)
+			return
+		}
+
+		state[projName] = make(map[string]internal.ReplicationTableState)
+		for _, tableName := range tables {
+			tableLocks.RLock(projName, tableName)
+			tableState, err := internal.GetReplicationTableState(projName, tableName)
+			tableLocks.RUnlock(projName, tableName)
+			if err != nil {
+				internal.PrintError(w, err)
+				return
+			}
+			state[projName][tableName] = tableState
+		}
+	}
+
+	jsonBytes, err := json.Marshal(state)
+	if err != nil {
+		internal.PrintError(w, errors.Wrap(err, "json error"))
+		return
+	}
+
+	w.Write(jsonBytes)
+}
+
+// replicationSnapshot sends a backup archive of one table. Replicas use it to copy tables they
+// cannot follow through the change log.
+func replicationSnapshot(w http.ResponseWriter, r *http.Request) {
+	projName := r.PathValue("proj")
+	tableName := r.PathValue("tbl")
+
+	if !doesTableExists(projName, tableName) {
+		internal.PrintError(w, errors.New(fmt.Sprintf("Table '%s' of Project '%s' does not exists.", tableName, projName)))
+		return
+	}
+
+	outPath := filepath.Join(internal.GetBackupsPath(), "snapshot_tmp_"+internal.UntestedRandomString(10)+".tar.gz")
+	defer os.Remove(outPath)
+
+	tableLocks.RLock(projName, tableName)
+	_, err := internal.WriteBackupArchive(projName, []string{tableName}, outPath)
+	tableLocks.RUnlock(projName, tableName)
+	if err != nil {
+		internal.PrintError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	http.ServeFile(w, r, outPath)
+}
+
+// startReplication starts the goroutine which keeps a replica in sync with its primary. It does
+// nothing on a primary, so a replica is promoted by emptying the 'replica_of' setting.
+func startReplication() {
+	go func() {
+		for {
+			if isReplica() {
+				err := syncWithPrimary()
+				replicationMutex.Lock()
+				if err != nil {
+					replicationLastErr = err.Error()
+					internal.LogError("replication error", err)
+				} else {
+					replicationLastErr = ""
+				}
+				replicationMutex.Unlock()
+			}
+			time.Sleep(replicationInterval)
+		}
+	}()
+}
+
+func fetchPrimaryState() (internal.ReplicationState, error) {
+	resp, err := internal.PrimaryRequest("replication-state", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var state internal.ReplicationState
+	err = json.NewDecoder(resp.Body).Decode(&state)
+	if err != nil {
+		return nil, errors.Wrap(err, "json error")
+	}
+	return state, nil
+}
+
+// copyTableFromPrimary replaces a table of the replica with a snapshot from the primary.
+func copyTableFromPrimary(projName, tableName string) error {
+	resp, err := internal.PrimaryRequest(fmt.Sprintf("replication-snapshot/%s/%s", projName, tableName), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	archivePath := filepath.Join(internal.GetBackupsPath(), "snapshot_tmp_"+internal.UntestedRandomString(10)+".tar.gz")
+	defer os.Remove(archivePath)
+	archiveHandle, err := os.Create(archivePath)
+	if err != nil {
+		return errors.Wrap(err, "os error")
+	}
+	_, err = io.Copy(archiveHandle, resp.Body)
+	archiveHandle.Close()
+	if err != nil {
+		return errors.Wrap(err, "http error")
+	}
+
+	extractedPath := filepath.Join(internal.GetBackupsPath(), "restore_tmp_"+internal.UntestedRandomString(10))
+	defer os.RemoveAll(extractedPath)
+	_, err = internal.ExtractBackupArchive(archivePath, extractedPath)
+	if err != nil {
+		return err
+	}
+
+	dataPath, _ := internal.GetRootPath()
+	os.MkdirAll(filepath.Join(dataPath, projName), 0777)
+
+	tableLocks.Lock(projName, tableName)
+	defer tableLocks.Unlock(projName, tableName)
+
+	tablePath := internal.GetTablePath(projName, tableName)
+	os.RemoveAll(tablePath)
+	err = os.Rename(filepath.Join(extractedPath, tableName), tablePath)
+	if err != nil {
+		return errors.Wrap(err, "rename failed.")
+	}
+	return nil
+}
+
+// applyChangesFromPrimary reads the changes to the tables of a project after the replica's sequence
+// numbers and applies them.
+func applyChangesFromPrimary(projName string, tables []string) error {
+	sinceParts := make([]string, 0, len(tables))
+	for _, tableName := range tables {
+		sinceParts = append(sinceParts, fmt.Sprintf("%s:%d", tableName, internal.GetLastSeq(projName, tableName)))
+	}
+
+	resp, err := internal.PrimaryRequest("changes/"+projName, url.Values{
+		"tables":    {strings.Join(tables, ",")},
+		"since":     {strings.Join(sinceParts, ",")},
+		"with-rows": {"t"},
+		"follow":    {"f"},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var event changeEvent
+		err = json.Unmarshal([]byte(line), &event)
+		if err != nil {
+			return errors.Wrap(err, "json error")
+		}
+
+		entry := internal.ChangeEntry{Seq: event.Seq, Time: event.Time, Table: event.Table, Op: event.Op,
+			Id: event.Id, Row: event.Row}
+
+		tableLocks.Lock(projName, entry.Table)
+		// changes already applied are skipped, so that a retried synchronization is harmless.
+		if entry.Seq > internal.GetLastSeq(projName, entry.Table) {
+			err = internal.ApplyChange(projName, entry)
+			if err == nil {
+				changesBroker.publish(projName, entry)
+			}
+		}
+		tableLocks.Unlock(projName, entry.Table)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("applying change %d of table '%s'", entry.Seq, entry.Table))
+		}
+	}
+
+	return scanner.Err()
+}
+
+// syncWithPrimary makes the projects and tables of the replica match those of the primary and
+// applies the changes recorded on the primary since the last synchronization.
+func syncWithPrimary() error {
+	startedAt := time.Now()
+	primaryState, err := fetchPrimaryState()
+	if err != nil {
+		return err
+	}
+
+	dataPath, _ := internal.GetRootPath()
+	localProjs, err := listReplicatedProjects()
+	if err != nil {
+		return err
+	}
+
+	// remove what was removed on the primary
+	projsMutex.Lock()
+	for _, projName := range localProjs {
+		if _, ok := primaryState[projName]; !ok && projName != "first_proj" {
+			os.RemoveAll(filepath.Join(dataPath, projName))
+		}
+	}
+	for projName, tables := range primaryState {
+		localTables, _ := internal.ListTables(projName)
+		for _, tableName := range localTables {
+			if _, ok := tables[tableName]; !ok {
+				os.RemoveAll(internal.GetTablePath(projName, tableName))
+			}
+		}
+	}
+	projsMutex.Unlock()
+
+	var pending int64
+	for _, projName := range slices.Sorted(maps.Keys(primaryState)) {
+		os.MkdirAll(filepath.Join(dataPath, projName), 0777)
+		tables := primaryState[projName]
+
+		toFollow := make([]string, 0)
+		for _, tableName := range slices.Sorted(maps.Keys(tables)) {
+			primaryTable := tables[tableName]
+
+			// tables whose structure changed or whose change log cannot be followed are copied whole
+			if !internal.DoesTableExists(projName, tableName) {
+				err = copyTableFromPrimary(projName, tableName)
+			} else if localTable, err2 := internal.GetReplicationTableState(projName, tableName); err2 != nil ||
+				localTable.Version != primaryTable.Version || localTable.Seq > primaryTable.Seq ||
+				localTable.Seq < primaryTable.TrimmedSeq {
+				err = copyTableFromPrimary(projName, tableName)
+			} else if !maps.Equal(localTable.Options, primaryTable.Options) {
+				err = internal.UpdateTableOptions(projName, tableName, primaryTable.Options)
+			}
+			if err != nil {
+				return errors.Wrap(err, fmt.Sprintf("copying table '%s' of project '%s'", tableName, projName))
+			}
+
+			if internal.GetLastSeq(projName, tableName) < primaryTable.Seq {
+				toFollow = append(toFollow, tableName)
+			}
+		}
+
+		if len(toFollow) == 0 {
+			continue
+		}
+
+		err = applyChangesFromPrimary(projName, toFollow)
+		if err != nil {
+			return err
+		}
+		for _, tableName := range toFollow {
+			if behind := tables[tableName].Seq - internal.GetLastSeq(projName, tableName); behind > 0 {
+				pending += behind
+			}
+		}
+	}
+
+	replicationMutex.Lock()
+	replicationPending = pending
+	if pending == 0 {
+		replicationCaughtUp = startedAt
+	}
+	replicationMutex.Unlock()
+
+	return nil
+}
+
+// replicaWriteBlocked reports whether a request needing neededRole must be refused because this
+// store is a replica.
+func replicaWriteBlocked(neededRole string) bool {
+	return neededRole != internal.ROLE_READ && isReplica()
+}
+
+func printReplicaError(w http.ResponseWriter) {
+	http.Error(w, "Service Unavailable: this store is a read-only replica of "+internal.GetSetting("replica_of")+
+		". Send writes to the primary.", http.StatusServiceUnavailable)
+}
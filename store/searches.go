@@ -21,7 +21,13 @@ func searchTable(w http.ResponseWriter, r *http.Request) {
 
 	projName := r.PathValue("proj")
 
-	stmtStruct, err := flaarumlib.ParseSearchStmt(r.FormValue("stmt"))
+	stmt, asOf, err := extractAsOf(r.FormValue("stmt"))
+	if err != nil {
+		printValError(w, err)
+		return
+	}
+
+	stmtStruct, err := flaarumlib.ParseSearchStmt(stmt)
 	if err != nil {
 		internal.PrintError(w, err)
 		return
@@ -34,7 +40,14 @@ func searchTable(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rets, err := innerSearch(projName, r.FormValue("stmt"))
+	var rets *[]map[string]string
+	var scanned int
+	if asOf != nil {
+		rets, scanned, err = innerSearchAsOf(projName, stmt, *asOf)
+	} else {
+		rets, scanned, err = innerSearchScanned(projName, stmt)
+	}
+	setRowsScanned(r, scanned)
 	if err != nil {
 		internal.PrintError(w, err)
 		return
@@ -81,7 +94,7 @@ func findIdsContainingTrueWhereValues(projName, tableName, fieldName string, tru
 		readBytes, err := internal.ReadPortionF2File(projName, tableName,
 			fieldName+"_indexes", elemHandle.DataBegin, elemHandle.DataEnd)
 		if err != nil {
-			fmt.Printf("%+v\n", err)
+			internal.LogError("bad indexes file", err)
 		}
 		retIds = append(retIds, strings.Split(string(readBytes), ",")...)
 	}
@@ -214,7 +227,7 @@ func doOnlyOneSearch(projName, tableName string, expand bool, whereOpts []flaaru
 						readBytes, err := internal.ReadPortionF2File(projName, pTbl, parts[1]+"_indexes",
 							elemHandle.DataBegin, elemHandle.DataEnd)
 						if err != nil {
-							fmt.Printf("%+v\n", err)
+							internal.LogError("bad indexes file", err)
 						}
 						trueWhereValues = append(trueWhereValues, strings.Split(string(readBytes), ",")...)
 					}
@@ -239,7 +252,7 @@ func doOnlyOneSearch(projName, tableName string, expand bool, whereOpts []flaaru
 						readBytes, err := internal.ReadPortionF2File(projName, tableName,
 							whereStruct.FieldName+"_indexes", elemHandle.DataBegin, elemHandle.DataEnd)
 						if err != nil {
-							fmt.Printf("%+v\n", err)
+							internal.LogError("bad indexes file", err)
 						}
 						beforeFilter = append(beforeFilter, strings.Split(string(readBytes), ","))
 					} else {
@@ -287,7 +300,7 @@ func doOnlyOneSearch(projName, tableName string, expand bool, whereOpts []flaaru
 							readBytes, err := internal.ReadPortionF2File(projName, pTbl,
 								parts[1]+"_indexes", elem.DataBegin, elem.DataEnd)
 							if err != nil {
-								fmt.Printf("%+v\n", err)
+								internal.LogError("bad indexes file", err)
 							}
 							trueWhereValues = append(trueWhereValues, strings.Split(string(readBytes), ",")...)
 						}
@@ -317,7 +330,7 @@ func doOnlyOneSearch(projName, tableName string, expand bool, whereOpts []flaaru
 							readBytes, err := internal.ReadPortionF2File(projName, tableName,
 								whereStruct.FieldName+"_indexes", elem.DataBegin, elem.DataEnd)
 							if err != nil {
-								fmt.Printf("%+v\n", err)
+								internal.LogError("bad indexes file", err)
 							}
 							stringIds = append(stringIds, strings.Split(string(readBytes), ",")...)
 						}
@@ -410,7 +423,7 @@ func doOnlyOneSearch(projName, tableName string, expand bool, whereOpts []flaaru
 							readBytes, err := internal.ReadPortionF2File(projName, pTbl,
 								resolvedFieldName+"_indexes", elem.DataBegin, elem.DataEnd)
 							if err != nil {
-								fmt.Printf("%+v\n", err)
+								internal.LogError("bad indexes file", err)
 							}
 							trueWhereValues = append(trueWhereValues, strings.Split(string(readBytes), ",")...)
 						}
@@ -497,7 +510,7 @@ func doOnlyOneSearch(projName, tableName string, expand bool, whereOpts []flaaru
 							readBytes, err := internal.ReadPortionF2File(projName, tableName,
 								whereStruct.FieldName+"_indexes", elem.DataBegin, elem.DataEnd)
 							if err != nil {
-								fmt.Printf("%+v\n", err)
+								internal.LogError("bad indexes file", err)
 							}
 							stringIds = append(stringIds, strings.Split(string(readBytes), ",")...)
 						}
@@ -591,7 +604,7 @@ func doOnlyOneSearch(projName, tableName string, expand bool, whereOpts []flaaru
 							readBytes, err := internal.ReadPortionF2File(projName, pTbl,
 								resolvedFieldName+"_indexes", elem.DataBegin, elem.DataEnd)
 							if err != nil {
-								fmt.Printf("%+v\n", err)
+								internal.LogError("bad indexes file", err)
 							}
 							trueWhereValues = append(trueWhereValues, strings.Split(string(readBytes), ",")...)
 						}
@@ -677,7 +690,7 @@ func doOnlyOneSearch(projName, tableName string, expand bool, whereOpts []flaaru
 							readBytes, err := internal.ReadPortionF2File(projName, tableName,
 								whereStruct.FieldName+"_indexes", elem.DataBegin, elem.DataEnd)
 							if err != nil {
-								fmt.Printf("%+v\n", err)
+								internal.LogError("bad indexes file", err)
 							}
 							stringIds = append(stringIds, strings.Split(string(readBytes), ",")...)
 						}
@@ -770,7 +783,7 @@ func doOnlyOneSearch(projName, tableName string, expand bool, whereOpts []flaaru
 							readBytes, err := internal.ReadPortionF2File(projName, pTbl,
 								resolvedFieldName+"_indexes", elem.DataBegin, elem.DataEnd)
 							if err != nil {
-								fmt.Printf("%+v\n", err)
+								internal.LogError("bad indexes file", err)
 							}
 							trueWhereValues = append(trueWhereValues, strings.Split(string(readBytes), ",")...)
 						}
@@ -857,7 +870,7 @@ func doOnlyOneSearch(projName, tableName string, expand bool, whereOpts []flaaru
 							readBytes, err := internal.ReadPortionF2File(projName, tableName,
 								whereStruct.FieldName+"_indexes", elem.DataBegin, elem.DataEnd)
 							if err != nil {
-								fmt.Printf("%+v\n", err)
+								internal.LogError("bad indexes file", err)
 							}
 							stringIds = append(stringIds, strings.Split(string(readBytes), ",")...)
 						}
@@ -951,7 +964,7 @@ func doOnlyOneSearch(projName, tableName string, expand bool, whereOpts []flaaru
 							readBytes, err := internal.ReadPortionF2File(projName, pTbl,
 								resolvedFieldName+"_indexes", elem.DataBegin, elem.DataEnd)
 							if err != nil {
-								fmt.Printf("%+v\n", err)
+								internal.LogError("bad indexes file", err)
 							}
 							trueWhereValues = append(trueWhereValues, strings.Split(string(readBytes), ",")...)
 						}
@@ -1037,7 +1050,7 @@ func doOnlyOneSearch(projName, tableName string, expand bool, whereOpts []flaaru
 							readBytes, err := internal.ReadPortionF2File(projName, tableName,
 								whereStruct.FieldName+"_indexes", elem.DataBegin, elem.DataEnd)
 							if err != nil {
-								fmt.Printf("%+v\n", err)
+								internal.LogError("bad indexes file", err)
 							}
 							stringIds = append(stringIds, strings.Split(string(readBytes), ",")...)
 						}
@@ -1152,7 +1165,7 @@ func doOnlyOneSearch(projName, tableName string, expand bool, whereOpts []flaaru
 							readBytes, err := internal.ReadPortionF2File(projName, pTbl,
 								resolvedFieldName+"_indexes", elem.DataBegin, elem.DataEnd)
 							if err != nil {
-								fmt.Printf("%+v\n", err)
+								internal.LogError("bad indexes file", err)
 							}
 							trueWhereValues = append(trueWhereValues, strings.Split(string(readBytes), ",")...)
 						}
@@ -1260,7 +1273,7 @@ func doOnlyOneSearch(projName, tableName string, expand bool, whereOpts []flaaru
 							readBytes, err := internal.ReadPortionF2File(projName, tableName,
 								whereStruct.FieldName+"_indexes", elem.DataBegin, elem.DataEnd)
 							if err != nil {
-								fmt.Printf("%+v\n", err)
+								internal.LogError("bad indexes file", err)
 							}
 							stringIds = append(stringIds, strings.Split(string(readBytes), ",")...)
 						}
@@ -1374,7 +1387,7 @@ func doOnlyOneSearch(projName, tableName string, expand bool, whereOpts []flaaru
 							readBytes, err := internal.ReadPortionF2File(projName, pTbl,
 								resolvedFieldName+"_indexes", elem.DataBegin, elem.DataEnd)
 							if err != nil {
-								fmt.Printf("%+v\n", err)
+								internal.LogError("bad indexes file", err)
 							}
 							trueWhereValues = append(trueWhereValues, strings.Split(string(readBytes), ",")...)
 						}
@@ -1482,7 +1495,7 @@ func doOnlyOneSearch(projName, tableName string, expand bool, whereOpts []flaaru
 							readBytes, err := internal.ReadPortionF2File(projName, tableName,
 								whereStruct.FieldName+"_indexes", elem.DataBegin, elem.DataEnd)
 							if err != nil {
-								fmt.Printf("%+v\n", err)
+								internal.LogError("bad indexes file", err)
 							}
 							stringIds = append(stringIds, strings.Split(string(readBytes), ",")...)
 						}
@@ -1522,7 +1535,7 @@ func doOnlyOneSearch(projName, tableName string, expand bool, whereOpts []flaaru
 							readBytes, err := internal.ReadPortionF2File(projName, pTbl, parts[1]+"_indexes",
 								elemHandle.DataBegin, elemHandle.DataEnd)
 							if err != nil {
-								fmt.Printf("%+v\n", err)
+								internal.LogError("bad indexes file", err)
 							}
 							trueWhereValues = append(trueWhereValues, strings.Split(string(readBytes), ",")...)
 						}
@@ -1549,7 +1562,7 @@ func doOnlyOneSearch(projName, tableName string, expand bool, whereOpts []flaaru
 							readBytes, err := internal.ReadPortionF2File(projName, tableName,
 								whereStruct.FieldName+"_indexes", elemHandle.DataBegin, elemHandle.DataEnd)
 							if err != nil {
-								fmt.Printf("%+v\n", err)
+								internal.LogError("bad indexes file", err)
 							}
 							stringIds = append(stringIds, strings.Split(string(readBytes), ",")...)
 						}
@@ -1629,32 +1642,46 @@ func doOnlyOneSearch(projName, tableName string, expand bool, whereOpts []flaaru
 }
 
 func innerSearch(projName, stmt string) (*[]map[string]string, error) {
+	rows, _, err := innerSearchScanned(projName, stmt)
+	return rows, err
+}
+
+// innerSearchScanned is innerSearch which also returns the number of rows it read.
+func innerSearchScanned(projName, stmt string) (*[]map[string]string, int, error) {
 	stmtStruct, err := flaarumlib.ParseSearchStmt(stmt)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
+	}
+
+	tableLocks.RLock(projName, stmtStruct.TableName)
+	defer tableLocks.RUnlock(projName, stmtStruct.TableName)
+
+	return innerSearchNoLock(projName, stmt)
+}
+
+// innerSearchNoLock is innerSearchScanned for callers which already hold the lock of the table.
+func innerSearchNoLock(projName, stmt string) (*[]map[string]string, int, error) {
+	stmtStruct, err := flaarumlib.ParseSearchStmt(stmt)
+	if err != nil {
+		return nil, 0, err
 	}
 
 	dataPath, _ := internal.GetRootPath()
 	tablePath := filepath.Join(dataPath, projName, stmtStruct.TableName)
 	tableName := stmtStruct.TableName
 
-	createTableMutexIfNecessary(projName, stmtStruct.TableName)
-	fullTableName := projName + ":" + stmtStruct.TableName
-	tablesMutexes[fullTableName].RLock()
-	defer tablesMutexes[fullTableName].RUnlock()
-
 	// map of fieldName to pointed_table
 	expDetails := make(map[string]string)
 
 	tableStruct, err := getCurrentTableStructureParsed(projName, stmtStruct.TableName)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	if stmtStruct.Expand {
 		for _, fKeyStruct := range tableStruct.ForeignKeys {
 			if !internal.DoesPathExists(filepath.Join(dataPath, projName, fKeyStruct.PointedTable)) {
-				return nil, errors.New(fmt.Sprintf("table '%s' of project '%s' does not exists.", fKeyStruct.PointedTable, projName))
+				return nil, 0, errors.New(fmt.Sprintf("table '%s' of project '%s' does not exists.", fKeyStruct.PointedTable, projName))
 			}
 			expDetails[fKeyStruct.FieldName] = fKeyStruct.PointedTable
 		}
@@ -1669,7 +1696,7 @@ func innerSearch(projName, stmt string) (*[]map[string]string, error) {
 			if internal.DoesPathExists(dataF1Path) {
 				elemsMap, err := internal.ParseDataF1File(dataF1Path)
 				if err != nil {
-					return nil, err
+					return nil, 0, err
 				}
 
 				for k := range elemsMap {
@@ -1683,7 +1710,7 @@ func innerSearch(projName, stmt string) (*[]map[string]string, error) {
 			for _, whereOpt := range stmtStruct.MultiWhereOptions {
 				tmpIds, err := doOnlyOneSearch(projName, tableName, stmtStruct.Expand, whereOpt)
 				if err != nil {
-					return nil, err
+					return nil, 0, err
 				}
 
 				outs = append(outs, tmpIds)
@@ -1704,7 +1731,7 @@ func innerSearch(projName, stmt string) (*[]map[string]string, error) {
 			if internal.DoesPathExists(dataF1Path) {
 				elemsMap, err := internal.ParseDataF1File(dataF1Path)
 				if err != nil {
-					return nil, err
+					return nil, 0, err
 				}
 
 				for k := range elemsMap {
@@ -1715,7 +1742,7 @@ func innerSearch(projName, stmt string) (*[]map[string]string, error) {
 		} else {
 			retIds, err = doOnlyOneSearch(projName, tableName, stmtStruct.Expand, stmtStruct.WhereOptions)
 			if err != nil {
-				return nil, err
+				return nil, 0, err
 			}
 		}
 
@@ -1733,12 +1760,12 @@ func innerSearch(projName, stmt string) (*[]map[string]string, error) {
 		rawRowData, err := internal.ReadPortionF2File(projName, tableName, "data",
 			elem.DataBegin, elem.DataEnd)
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 
 		rowMap, err := internal.ParseEncodedRowData(rawRowData)
 		if err != nil {
-			fmt.Println(err)
+			internal.LogError("bad row", err)
 			continue
 		}
 
@@ -1748,7 +1775,7 @@ func innerSearch(projName, stmt string) (*[]map[string]string, error) {
 			if ok {
 				pTblelemsMap, err := internal.ParseDataF1File(filepath.Join(internal.GetTablePath(projName, pTbl), "data.flaa1"))
 				if err != nil {
-					fmt.Println(err)
+					internal.LogError("bad data file", err)
 				}
 
 				pTblelem, ok := pTblelemsMap[data]
@@ -1758,12 +1785,12 @@ func innerSearch(projName, stmt string) (*[]map[string]string, error) {
 				rawRowData2, err := internal.ReadPortionF2File(projName, pTbl, "data",
 					pTblelem.DataBegin, pTblelem.DataEnd)
 				if err != nil {
-					return nil, err
+					return nil, 0, err
 				}
 
 				rowMap2, err := internal.ParseEncodedRowData(rawRowData2)
 				if err != nil {
-					fmt.Println(err)
+					internal.LogError("bad row", err)
 					continue
 				}
 
@@ -1776,7 +1803,14 @@ func innerSearch(projName, stmt string) (*[]map[string]string, error) {
 		rowMap["id"] = retId
 		tmpRet = append(tmpRet, rowMap)
 	}
+	observeRowsScanned(len(tmpRet))
+
+	return orderLimitAndSelect(projName, tableName, stmtStruct, tmpRet), len(tmpRet), nil
+}
 
+// orderLimitAndSelect applies the order_by, start_index, limit, fields and distinct parts of a search
+// statement to the rows found by the search.
+func orderLimitAndSelect(projName, tableName string, stmtStruct flaarumlib.StmtStruct, tmpRet []map[string]string) *[]map[string]string {
 	elems := tmpRet
 	if stmtStruct.OrderBy != "" {
 		if stmtStruct.OrderDirection == "asc" {
@@ -1909,5 +1943,5 @@ func innerSearch(projName, stmt string) (*[]map[string]string, error) {
 		ret = beforeDistinct
 	}
 
-	return &ret, nil
+	return &ret
 }
@@ -3,26 +3,31 @@ package main
 import (
 	"crypto/sha512"
 	"fmt"
+	"log/slog"
 	"net/http"
-	"strings"
-	"sync"
 
 	"github.com/pkg/errors"
 	"github.com/saenuma/flaarum/internal"
 )
 
 func nameValidate(name string) error {
-	if strings.Contains(name, ".") || strings.Contains(name, " ") || strings.Contains(name, "\t") ||
-		strings.Contains(name, "\n") || strings.Contains(name, ":") || strings.Contains(name, "/") ||
-		strings.Contains(name, "~") {
-		return errors.New("object name must not contain space, '.', ':', '/', ~ ")
+	return internal.NameValidate(name)
+}
+
+// tableNameValidate also refuses the names of the folders written by trims and reindexes.
+func tableNameValidate(name string) error {
+	if err := nameValidate(name); err != nil {
+		return err
+	}
+	if internal.IsRebuildTableName(name) {
+		return errors.New(fmt.Sprintf("table name '%s' must not end with '_rebuild_src', '_rebuild_tmp', '_trim_tmp' or '_ridx_tmp'", name))
 	}
 
 	return nil
 }
 
 func printValError(w http.ResponseWriter, err error) {
-	fmt.Printf("%+v\n", err)
+	internal.LogResponseError(w, slog.LevelWarn, "validation error", err)
 	debug := internal.GetSetting("debug")
 	if debug == "true" {
 		http.Error(w, fmt.Sprintf("%+v", err), http.StatusBadRequest)
@@ -31,14 +36,6 @@ func printValError(w http.ResponseWriter, err error) {
 	}
 }
 
-func createTableMutexIfNecessary(projName, tableName string) {
-	fullTableName := projName + ":" + tableName
-	_, ok := tablesMutexes[fullTableName]
-	if !ok {
-		tablesMutexes[fullTableName] = &sync.RWMutex{}
-	}
-}
-
 func MakeHash(data string) string {
 	h := sha512.New()
 	h.Write([]byte(data))
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/saenuma/flaarum/internal"
+	"github.com/saenuma/zazabul"
+)
+
+// shutdownTimeout is how long the requests in flight get to finish after a SIGTERM.
+const shutdownTimeout = 30 * time.Second
+
+// webhooksShutdownTimeout is how long the queued webhook deliveries and their retries get to finish
+// once the requests are done.
+const webhooksShutdownTimeout = 20 * time.Second
+
+var (
+	shuttingDown     atomic.Bool
+	shutdownCh       = make(chan struct{}) // closed on shutdown to end the change streams
+	shutdownChClosed sync.Once
+)
+
+// handleShutdown stops the server on SIGTERM or SIGINT. It stops accepting connections, waits for
+// the requests in flight, the background writers and the webhook deliveries, flushes the files of
+// the tables to disk, removes the folders of unfinished rebuilds and exits.
+func handleShutdown(server *http.Server) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT)
+
+	go func() {
+		sig := <-sigCh
+		internal.Logger.Info("shutting down", "signal", sig.String())
+		shuttingDown.Store(true)
+		shutdownChClosed.Do(func() { close(shutdownCh) })
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		err := server.Shutdown(ctx)
+		if err != nil {
+			internal.LogError("shutdown error: some requests did not finish", err)
+		}
+
+		// the compactor and the replication hold the locks of the tables while they write
+		projsMutex.Lock()
+		tableLocks.LockAll()
+
+		err = finishShutdown(webhooksShutdownTimeout)
+		if err != nil {
+			internal.LogError("shutdown error", err)
+			os.Exit(1)
+		}
+
+		internal.Logger.Info("shut down")
+		os.Exit(0)
+	}()
+}
+
+// finishShutdown waits up to webhooksTimeout for the webhook deliveries, flushes the files of the
+// tables to disk and removes the folders of unfinished rebuilds. Nothing must write to the tables
+// while it runs.
+func finishShutdown(webhooksTimeout time.Duration) error {
+	if left := waitForWebhooks(webhooksTimeout); left > 0 {
+		internal.Logger.Warn("webhook deliveries were not sent", "count", left)
+	}
+
+	err := syncDataFiles()
+	if err != nil {
+		return err
+	}
+
+	err = internal.RemoveRebuildFolders()
+	if err != nil {
+		internal.LogError("shutdown error", err)
+	}
+
+	return nil
+}
+
+// syncDataFiles flushes the files of every table to disk.
+func syncDataFiles() error {
+	projs, err := listReplicatedProjects()
+	if err != nil {
+		return err
+	}
+
+	for _, projName := range projs {
+		tables, err := internal.ListTables(projName)
+		if err != nil {
+			continue
+		}
+		for _, tableName := range tables {
+			tablePath := internal.GetTablePath(projName, tableName)
+			dirFIs, err := os.ReadDir(tablePath)
+			if err != nil {
+				return errors.Wrap(err, "directory read error")
+			}
+			for _, dirFI := range dirFIs {
+				if dirFI.IsDir() {
+					continue
+				}
+				f, err := os.OpenFile(filepath.Join(tablePath, dirFI.Name()), os.O_RDWR, 0777)
+				if err != nil {
+					return errors.Wrap(err, "os error")
+				}
+				err = f.Sync()
+				f.Close()
+				if err != nil {
+					return errors.Wrap(err, "os error")
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// healthChecks checks that the config loads and that the data folder is writable.
+func healthChecks() map[string]string {
+	checks := map[string]string{"config": "ok", "data_root": "ok"}
+
+	confPath, err := internal.GetConfigPath()
+	if err == nil {
+		_, err = zazabul.LoadConfigFile(confPath)
+	}
+	if err != nil {
+		checks["config"] = err.Error()
+	}
+
+	dataPath, err := internal.GetRootPath()
+	if err == nil {
+		probePath := filepath.Join(dataPath, ".healthz_probe")
+		err = os.WriteFile(probePath, []byte("ok"), 0600)
+		os.Remove(probePath)
+	}
+	if err != nil {
+		checks["data_root"] = err.Error()
+	}
+
+	return checks
+}
+
+func writeHealth(w http.ResponseWriter, checks map[string]string) {
+	status := "ok"
+	for _, result := range checks {
+		if result != "ok" {
+			status = "failing"
+		}
+	}
+
+	jsonBytes, _ := json.Marshal(map[string]any{"status": status, "checks": checks})
+	w.Header().Set("Content-Type", "application/json")
+	if status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write(jsonBytes)
+}
+
+// healthz tells if the store works. It needs no key so that process supervisors can call it.
+func healthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, healthChecks())
+}
+
+// readyz tells if the store should be sent requests. It is not ready while shutting down or in
+// maintenance mode.
+func readyz(w http.ResponseWriter, r *http.Request) {
+	checks := healthChecks()
+	checks["shutdown"] = "ok"
+	if shuttingDown.Load() {
+		checks["shutdown"] = "shutting down"
+	}
+	checks["mode"] = "ok"
+	if mode := getGlobalMode(); mode == MODE_MAINTENANCE {
+		checks["mode"] = mode
+	}
+
+	writeHealth(w, checks)
+}
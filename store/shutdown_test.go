@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/saenuma/flaarum/internal"
+)
+
+func TestFinishShutdown(t *testing.T) {
+	ts := newTestStore(t)
+
+	var delivered atomic.Int64
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+		delivered.Add(1)
+	}))
+	t.Cleanup(receiver.Close)
+
+	mustPost(t, ts, "/create-table/first_proj", url.Values{"stmt": {"table: notes\nfields:\n  title string required\n::\n"}})
+	addTestWebhook(t, ts, "notes", receiver.URL)
+
+	rebuildPath := internal.GetTablePath("first_proj", "notes_rebuild_tmp")
+	err := os.MkdirAll(rebuildPath, 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(rebuildPath, "data.flaa1"), []byte("left over"), 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mustPost(t, ts, "/insert-row/first_proj/notes", url.Values{"title": {"first"}})
+	mustPost(t, ts, "/insert-row/first_proj/notes", url.Values{"title": {"second"}})
+
+	err = finishShutdown(10 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := delivered.Load(); got != 2 {
+		t.Errorf("%d webhooks were delivered before the shutdown finished, expected 2", got)
+	}
+	if left := webhooksInFlight.Load(); left != 0 {
+		t.Errorf("%d webhook deliveries are left in flight", left)
+	}
+	if _, err := os.Stat(rebuildPath); !os.IsNotExist(err) {
+		t.Errorf("the rebuild folder was not removed: %v", err)
+	}
+	if _, err := os.Stat(internal.GetTablePath("first_proj", "notes")); err != nil {
+		t.Errorf("the table folder was touched: %v", err)
+	}
+}
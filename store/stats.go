@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/saenuma/flaarum/internal"
+)
+
+func readTableStats(projName, tableName string) (internal.TableStats, error) {
+	tableLocks.RLock(projName, tableName)
+	defer tableLocks.RUnlock(projName, tableName)
+
+	return internal.GetTableStats(projName, tableName)
+}
+
+// tableStatsHTTP reports the row count, file sizes, dead space, index key counts and the
+// structure versions of the rows of a table.
+func tableStatsHTTP(w http.ResponseWriter, r *http.Request) {
+	projName := r.PathValue("proj")
+	tableName := r.PathValue("tbl")
+
+	if !doesTableExists(projName, tableName) {
+		printValError(w, errors.New(fmt.Sprintf("Table '%s' of Project '%s' does not exists.", tableName, projName)))
+		return
+	}
+
+	stats, err := readTableStats(projName, tableName)
+	if err != nil {
+		internal.PrintError(w, err)
+		return
+	}
+
+	jsonBytes, err := json.Marshal(stats)
+	if err != nil {
+		internal.PrintError(w, errors.Wrap(err, "json error"))
+		return
+	}
+
+	w.Write(jsonBytes)
+}
+
+// projectStatsHTTP reports the statistics of every table of a project.
+func projectStatsHTTP(w http.ResponseWriter, r *http.Request) {
+	projName := r.PathValue("proj")
+
+	projsMutex.RLock()
+	tables, err := internal.ListTables(projName)
+	projsMutex.RUnlock()
+	if err != nil {
+		printValError(w, errors.New(fmt.Sprintf("Project '%s' does not exists.", projName)))
+		return
+	}
+
+	out := make(map[string]internal.TableStats)
+	for _, tableName := range tables {
+		stats, err := readTableStats(projName, tableName)
+		if err != nil {
+			internal.PrintError(w, err)
+			return
+		}
+		out[tableName] = stats
+	}
+
+	jsonBytes, err := json.Marshal(out)
+	if err != nil {
+		internal.PrintError(w, errors.Wrap(err, "json error"))
+		return
+	}
+
+	w.Write(jsonBytes)
+}
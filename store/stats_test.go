@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/saenuma/flaarum/internal"
+)
+
+func TestTableStats(t *testing.T) {
+	ts := newTestStore(t)
+	mustPost(t, ts, "/create-table/first_proj", url.Values{"stmt": {"table: notes\nfields:\n  title string\n::\n"}})
+	mustPost(t, ts, "/create-table/first_proj", url.Values{"stmt": {"table: tags\nfields:\n  title string\n::\n"}})
+	for _, title := range []string{"a", "b", "b"} {
+		mustPost(t, ts, "/insert-row/first_proj/notes", url.Values{"title": {title}})
+	}
+	mustPost(t, ts, "/update-table-structure/first_proj", url.Values{"stmt": {"table: notes\nfields:\n  title string\n  body text\n::\n"}})
+	lastId := mustPost(t, ts, "/insert-row/first_proj/notes", url.Values{"title": {"c"}, "body": {"text"}})
+
+	var stats internal.TableStats
+	err := json.Unmarshal([]byte(mustPost(t, ts, "/stats/first_proj/notes", nil)), &stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Rows != 4 || stats.LastId != lastId {
+		t.Errorf("the stats have %d rows and last id '%s', expected 4 and '%s'", stats.Rows, stats.LastId, lastId)
+	}
+	if stats.Versions["1"] != 3 || stats.Versions["2"] != 1 {
+		t.Errorf("the stats have the versions %v", stats.Versions)
+	}
+	if stats.Indexes["title"].Keys != 3 {
+		t.Errorf("the title index has %d keys, expected 3", stats.Indexes["title"].Keys)
+	}
+	if stats.Files["data.flaa2"] == 0 || stats.TotalSize < stats.Files["data.flaa2"] {
+		t.Errorf("the stats have the files %v and the total size %d", stats.Files, stats.TotalSize)
+	}
+
+	projStats := make(map[string]internal.TableStats)
+	err = json.Unmarshal([]byte(mustPost(t, ts, "/stats/first_proj", nil)), &projStats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(projStats) != 2 || projStats["notes"].Rows != 4 || projStats["tags"].Rows != 0 {
+		t.Errorf("the project stats are %v", projStats)
+	}
+
+	status, _ := post(t, ts, "/stats/first_proj/missing", nil)
+	if status != http.StatusBadRequest {
+		t.Errorf("the stats of a missing table got status %d", status)
+	}
+	status, _ = post(t, ts, "/stats/missing_proj", nil)
+	if status != http.StatusBadRequest {
+		t.Errorf("the stats of a missing project got status %d", status)
+	}
+}
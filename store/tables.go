@@ -6,6 +6,8 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 
 	"github.com/pkg/errors"
 	"github.com/saenuma/flaarum/internal"
@@ -17,6 +19,10 @@ func doesTableExists(projName, tableName string) bool {
 }
 
 func validateTableStruct(projName string, tableStruct flaarumlib.TableStruct) error {
+	if err := tableNameValidate(tableStruct.TableName); err != nil {
+		return err
+	}
+
 	fields := make([]string, 0)
 	fTypeMap := make(map[string]string)
 	td := tableStruct
@@ -36,9 +42,10 @@ func validateTableStruct(projName string, tableStruct flaarumlib.TableStruct) er
 			return errors.New(fmt.Sprintf("The field '%s' in a foreign key definition is not defined in the fields section",
 				fkd.FieldName))
 		}
-		if fTypeMap[fkd.FieldName] != "int" {
-			return errors.New(fmt.Sprintf("The field '%s' is not of type 'int' and so cannot be used in a foreign key defnition",
-				fkd.FieldName))
+		idType := internal.GetIdFieldType(projName, fkd.PointedTable)
+		if fTypeMap[fkd.FieldName] != idType {
+			return errors.New(fmt.Sprintf("The field '%s' is not of type '%s' which is the type of the ids of table '%s' and so cannot be used in a foreign key defnition",
+				fkd.FieldName, idType, fkd.PointedTable))
 		}
 	}
 
@@ -88,6 +95,7 @@ func createTable(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	setAuditDetails(r, tableStruct.TableName, stmt, nil)
 	fmt.Fprintf(w, "ok")
 }
 
@@ -120,6 +128,16 @@ func updateTableStructure(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if r.FormValue("dry-run") == "t" {
+		skipAudit(r)
+		dryRunTableStructureHTTP(w, projName, tableStruct)
+		return
+	}
+
+	// the writes to the table lock the tables of its current foreign keys
+	tableLocks.Lock(projName, tableStruct.TableName)
+	defer tableLocks.Unlock(projName, tableStruct.TableName)
+
 	currentVersionNum, err := getCurrentVersionNum(projName, tableStruct.TableName)
 	if err != nil {
 		internal.PrintError(w, err)
@@ -141,6 +159,9 @@ func updateTableStructure(w http.ResponseWriter, r *http.Request) {
 			internal.PrintError(w, errors.Wrap(err, "ioutil error."))
 			return
 		}
+		setAuditDetails(r, tableStruct.TableName, stmt, nil)
+	} else {
+		skipAudit(r)
 	}
 
 	fmt.Fprintf(w, "ok")
@@ -232,25 +253,214 @@ func deleteTable(w http.ResponseWriter, r *http.Request) {
 	projsMutex.Lock()
 	defer projsMutex.Unlock()
 
-	createTableMutexIfNecessary(projName, tableName)
-	fullTableName := projName + ":" + tableName
-	tablesMutexes[fullTableName].Lock()
+	tableLocks.Lock(projName, tableName)
+	defer tableLocks.Unlock(projName, tableName)
 
 	if !doesTableExists(projName, tableName) {
-		tablesMutexes[fullTableName].Unlock()
 		internal.PrintError(w, errors.New(fmt.Sprintf("Table '%s' does not exist in project '%s'.", tableName, projName)))
 		return
 	}
 
 	err := os.RemoveAll(filepath.Join(dataPath, projName, tableName))
 	if err != nil {
-		tablesMutexes[fullTableName].Unlock()
 		internal.PrintError(w, errors.Wrap(err, "delete dir failed."))
 		return
 	}
 
-	tablesMutexes[fullTableName].Unlock()
-	delete(tablesMutexes, fullTableName)
+	fmt.Fprintf(w, "ok")
+}
+
+// rewriteStructureFiles rewrites every structure file of a table so that references to the table
+// oldName (as the table name or as a pointed table) become newName.
+func rewriteStructureFiles(tablePath, oldName, newName string) error {
+	dirFIs, err := os.ReadDir(tablePath)
+	if err != nil {
+		return errors.Wrap(err, "directory read error")
+	}
+
+	for _, dirFI := range dirFIs {
+		if !strings.HasPrefix(dirFI.Name(), "structure") || !strings.HasSuffix(dirFI.Name(), ".txt") {
+			continue
+		}
+
+		structPath := filepath.Join(tablePath, dirFI.Name())
+		raw, err := os.ReadFile(structPath)
+		if err != nil {
+			return errors.Wrap(err, "file read error")
+		}
+
+		tableStruct, err := flaarumlib.ParseTableStructureStmt(string(raw))
+		if err != nil {
+			return err
+		}
+
+		changed := false
+		if tableStruct.TableName == oldName {
+			tableStruct.TableName = newName
+			changed = true
+		}
+		for i, fkd := range tableStruct.ForeignKeys {
+			if fkd.PointedTable == oldName {
+				tableStruct.ForeignKeys[i].PointedTable = newName
+				changed = true
+			}
+		}
+
+		if changed {
+			err = os.WriteFile(structPath, []byte(flaarumlib.FormatTableStruct(tableStruct)), 0777)
+			if err != nil {
+				return errors.Wrap(err, "file write error")
+			}
+		}
+	}
+
+	return nil
+}
+
+func renameTable(w http.ResponseWriter, r *http.Request) {
+
+	projName := r.PathValue("proj")
+	tableName := r.PathValue("tbl")
+	newTableName := r.PathValue("ntbl")
+
+	if err := tableNameValidate(newTableName); err != nil {
+		printValError(w, err)
+		return
+	}
+
+	dataPath, _ := internal.GetRootPath()
+
+	projsMutex.Lock()
+	defer projsMutex.Unlock()
+
+	if !doesTableExists(projName, tableName) {
+		internal.PrintError(w, errors.New(fmt.Sprintf("Table '%s' does not exist in project '%s'.", tableName, projName)))
+		return
+	}
+
+	if doesTableExists(projName, newTableName) {
+		internal.PrintError(w, errors.New(fmt.Sprintf("Table '%s' already exists in project '%s'.", newTableName, projName)))
+		return
+	}
+
+	existingTables, err := internal.ListTables(projName)
+	if err != nil {
+		internal.PrintError(w, err)
+		return
+	}
+
+	// the new name is locked too, as the table is visible under it before its structure is rewritten
+	unlock := tableLocks.LockTables(projName, append(existingTables, newTableName))
+	defer unlock()
+
+	err = os.Rename(filepath.Join(dataPath, projName, tableName), filepath.Join(dataPath, projName, newTableName))
+	if err != nil {
+		internal.PrintError(w, errors.Wrap(err, "rename failed."))
+		return
+	}
+
+	// update the foreign keys pointing to the renamed table
+	for _, tbl := range existingTables {
+		if tbl == tableName {
+			tbl = newTableName
+		}
+		err = rewriteStructureFiles(filepath.Join(dataPath, projName, tbl), tableName, newTableName)
+		if err != nil {
+			internal.PrintError(w, err)
+			return
+		}
+	}
+
+	err = renameTableWebhooks(projName, tableName, newTableName)
+	if err != nil {
+		internal.PrintError(w, err)
+		return
+	}
+
+	setAuditDetails(r, tableName, "rename to "+newTableName, nil)
+	fmt.Fprintf(w, "ok")
+}
+
+func copyTable(w http.ResponseWriter, r *http.Request) {
+
+	projName := r.PathValue("proj")
+	tableName := r.PathValue("tbl")
+	newTableName := r.PathValue("ntbl")
+	withData := r.FormValue("with-data") == "t"
+
+	if err := tableNameValidate(newTableName); err != nil {
+		printValError(w, err)
+		return
+	}
+
+	dataPath, _ := internal.GetRootPath()
+
+	projsMutex.Lock()
+	defer projsMutex.Unlock()
+
+	if !doesTableExists(projName, tableName) {
+		internal.PrintError(w, errors.New(fmt.Sprintf("Table '%s' does not exist in project '%s'.", tableName, projName)))
+		return
+	}
+
+	if doesTableExists(projName, newTableName) {
+		internal.PrintError(w, errors.New(fmt.Sprintf("Table '%s' already exists in project '%s'.", newTableName, projName)))
+		return
+	}
+
+	tableLocks.RLock(projName, tableName)
+	defer tableLocks.RUnlock(projName, tableName)
+
+	tablePath := filepath.Join(dataPath, projName, tableName)
+	newTablePath := filepath.Join(dataPath, projName, newTableName)
+
+	dirFIs, err := os.ReadDir(tablePath)
+	if err != nil {
+		internal.PrintError(w, errors.Wrap(err, "directory read error"))
+		return
+	}
+
+	err = os.MkdirAll(newTablePath, 0777)
+	if err != nil {
+		internal.PrintError(w, errors.Wrap(err, "os error."))
+		return
+	}
+
+	for _, dirFI := range dirFIs {
+		if dirFI.IsDir() {
+			continue
+		}
+		isStructure := strings.HasPrefix(dirFI.Name(), "structure") && strings.HasSuffix(dirFI.Name(), ".txt")
+		if !isStructure && !withData {
+			continue
+		}
+		// the copy starts its own change log
+		if slices.Contains(internal.ChangeLogFiles, dirFI.Name()) {
+			continue
+		}
+
+		raw, err := os.ReadFile(filepath.Join(tablePath, dirFI.Name()))
+		if err != nil {
+			os.RemoveAll(newTablePath)
+			internal.PrintError(w, errors.Wrap(err, "file read error"))
+			return
+		}
+		err = os.WriteFile(filepath.Join(newTablePath, dirFI.Name()), raw, 0777)
+		if err != nil {
+			os.RemoveAll(newTablePath)
+			internal.PrintError(w, errors.Wrap(err, "file write error"))
+			return
+		}
+	}
+
+	// self referencing foreign keys now point to the copy
+	err = rewriteStructureFiles(newTablePath, tableName, newTableName)
+	if err != nil {
+		os.RemoveAll(newTablePath)
+		internal.PrintError(w, err)
+		return
+	}
 
+	setAuditDetails(r, tableName, fmt.Sprintf("copy to %s with-data=%t", newTableName, withData), nil)
 	fmt.Fprintf(w, "ok")
 }
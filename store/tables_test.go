@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/saenuma/flaarum/internal"
+)
+
+// pointedTables returns the tables the foreign keys of a table point to, keyed by field.
+func pointedTables(t *testing.T, projName, tableName string) map[string]string {
+	t.Helper()
+
+	tableStruct, err := internal.GetCurrentTableStructureParsed(projName, tableName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tableStruct.TableName != tableName {
+		t.Errorf("the structure of table '%s' has the name '%s'", tableName, tableStruct.TableName)
+	}
+	pointed := make(map[string]string)
+	for _, fkd := range tableStruct.ForeignKeys {
+		pointed[fkd.FieldName] = fkd.PointedTable
+	}
+	return pointed
+}
+
+// createSelfReferencingTable creates the table 'authors' whose field 'mentor' points to itself. The
+// table must exist before a foreign key can point to it.
+func createSelfReferencingTable(t *testing.T, ts *httptest.Server) {
+	t.Helper()
+
+	mustPost(t, ts, "/create-table/first_proj", url.Values{"stmt": {"table: authors\nfields:\n  name string required\n  mentor int\n::\n"}})
+	mustPost(t, ts, "/update-table-structure/first_proj", url.Values{"stmt": {`
+table: authors
+fields:
+  name string required
+  mentor int
+::
+foreign_keys:
+  mentor authors on_delete_empty
+::
+`}})
+}
+
+func TestRenameTable(t *testing.T) {
+	ts := newTestStore(t)
+	createSelfReferencingTable(t, ts)
+	mustPost(t, ts, "/create-table/first_proj", url.Values{"stmt": {`
+table: books
+fields:
+  title string required
+  author int required
+::
+foreign_keys:
+  author authors on_delete_restrict
+::
+`}})
+	authorId := mustPost(t, ts, "/insert-row/first_proj/authors", url.Values{"name": {"ada"}})
+	mustPost(t, ts, "/insert-row/first_proj/books", url.Values{"title": {"notes"}, "author": {authorId}})
+
+	mustPost(t, ts, "/rename-table/first_proj/authors/writers", nil)
+
+	if internal.DoesTableExists("first_proj", "authors") {
+		t.Error("the table is still found under its old name")
+	}
+	if pointed := pointedTables(t, "first_proj", "writers"); pointed["mentor"] != "writers" {
+		t.Errorf("the self reference points to '%s'", pointed["mentor"])
+	}
+	if pointed := pointedTables(t, "first_proj", "books"); pointed["author"] != "writers" {
+		t.Errorf("the foreign key of 'books' points to '%s'", pointed["author"])
+	}
+
+	// the foreign keys still work under the new name
+	mustPost(t, ts, "/insert-row/first_proj/writers", url.Values{"name": {"alan"}, "mentor": {authorId}})
+	status, _ := post(t, ts, "/insert-row/first_proj/books", url.Values{"title": {"lost"}, "author": {"999"}})
+	if status == http.StatusOK {
+		t.Error("a book pointing to a missing writer was inserted")
+	}
+	if rows := searchRows(t, ts, "table: writers\nwhere:\n  name = ada"); len(rows) != 1 {
+		t.Errorf("the renamed table has lost its row: %v", rows)
+	}
+}
+
+func TestCopyTable(t *testing.T) {
+	ts := newTestStore(t)
+	createSelfReferencingTable(t, ts)
+	mustPost(t, ts, "/insert-row/first_proj/authors", url.Values{"name": {"ada"}})
+	mustPost(t, ts, "/insert-row/first_proj/authors", url.Values{"name": {"alan"}})
+
+	mustPost(t, ts, "/copy-table/first_proj/authors/empty_authors", nil)
+	mustPost(t, ts, "/copy-table/first_proj/authors/full_authors", url.Values{"with-data": {"t"}})
+
+	for _, tableName := range []string{"empty_authors", "full_authors"} {
+		if pointed := pointedTables(t, "first_proj", tableName); pointed["mentor"] != tableName {
+			t.Errorf("the self reference of the copy '%s' points to '%s'", tableName, pointed["mentor"])
+		}
+		// the copies start their own change logs
+		if seq := internal.GetLastSeq("first_proj", tableName); seq != 0 {
+			t.Errorf("the copy '%s' has the last sequence number %d", tableName, seq)
+		}
+	}
+
+	if rows := searchRows(t, ts, "table: empty_authors"); len(rows) != 0 {
+		t.Errorf("the copy without data has the rows %v", rows)
+	}
+	if rows := searchRows(t, ts, "table: full_authors\nwhere:\n  name = ada"); len(rows) != 1 {
+		t.Errorf("the copy with data finds the rows %v", rows)
+	}
+
+	// the copy and the source are written apart
+	mustPost(t, ts, "/insert-row/first_proj/full_authors", url.Values{"name": {"grace"}})
+	if rows := searchRows(t, ts, "table: authors"); len(rows) != 2 {
+		t.Errorf("the source has %d rows after a write to the copy, expected 2", len(rows))
+	}
+	changes, err := internal.ReadChangesSince("first_proj", "full_authors", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Seq != 1 || changes[0].Row["name"] != "grace" {
+		t.Errorf("the change log of the copy has %v", changes)
+	}
+}
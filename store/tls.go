@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/saenuma/flaarum/internal"
+)
+
+// getTLSConfig returns the tls configuration of the server. When client_auth is on, client
+// certificates are verified against the certificate authority created by 'flaarum.prod genca'.
+func getTLSConfig() (*tls.Config, error) {
+	config := &tls.Config{}
+	if internal.GetSetting("client_auth") != "true" {
+		return config, nil
+	}
+
+	rootPath, _ := internal.GetRootPath()
+	rawCACert, err := os.ReadFile(filepath.Join(rootPath, "flaarum-ca.crt"))
+	if err != nil {
+		return nil, errors.Wrap(err, "client_auth is on but the certificate authority is missing. Run 'flaarum.prod genca'")
+	}
+
+	caPool := x509.NewCertPool()
+	if !caPool.AppendCertsFromPEM(rawCACert) {
+		return nil, errors.New("the certificate authority file is not valid")
+	}
+
+	config.ClientCAs = caPool
+	// The certificates are verified when given and verifyClientCert refuses requests without one,
+	// so that /healthz and /readyz stay reachable by probes without certificates.
+	config.ClientAuth = tls.VerifyClientCertIfGiven
+
+	return config, nil
+}
+
+// verifyClientCert returns the common name of the verified client certificate of a request.
+// When client_auth is on, requests without a certificate are refused, including those from this
+// machine: 'flaarum.cli' and 'flaarum.prod' present the local client certificate.
+func verifyClientCert(r *http.Request) (string, error) {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return r.TLS.PeerCertificates[0].Subject.CommonName, nil
+	}
+
+	if internal.GetSetting("client_auth") != "true" {
+		return "", nil
+	}
+
+	return "", errors.New("a client certificate is required")
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/saenuma/flaarum/internal"
+)
+
+func mintToken(w http.ResponseWriter, r *http.Request) {
+	name := r.FormValue("name")
+	role := r.FormValue("role")
+
+	if name == "" {
+		printValError(w, errors.New("a token needs a name"))
+		return
+	}
+
+	if !internal.IsValidRole(role) {
+		printValError(w, errors.New(fmt.Sprintf("role '%s' is not one of 'read', 'write' or 'admin'", role)))
+		return
+	}
+
+	projects := strings.Split(r.FormValue("projects"), ",")
+	if r.FormValue("projects") == "" {
+		printValError(w, errors.New("a token needs one or more projects"))
+		return
+	}
+
+	ttl, err := time.ParseDuration(r.FormValue("ttl"))
+	if err != nil || ttl <= 0 {
+		printValError(w, errors.New(fmt.Sprintf("ttl '%s' is not a valid duration eg. '1h'", r.FormValue("ttl"))))
+		return
+	}
+
+	token, err := internal.MintToken(internal.TokenClaims{Subject: name, Role: role, Projects: projects}, ttl)
+	if err != nil {
+		internal.PrintError(w, err)
+		return
+	}
+
+	fmt.Fprint(w, token)
+}
+
+func rotateTokenSecret(w http.ResponseWriter, r *http.Request) {
+	err := internal.RotateTokenSecrets()
+	if err != nil {
+		internal.PrintError(w, err)
+		return
+	}
+
+	fmt.Fprintf(w, "ok")
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// postWithToken is post with a Bearer token instead of a key string.
+func postWithToken(t *testing.T, ts *httptest.Server, path, token string, values url.Values) (int, string) {
+	t.Helper()
+
+	req, err := http.NewRequest("POST", ts.URL+path, strings.NewReader(values.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestBearerTokens(t *testing.T) {
+	ts := newProdTestStore(t)
+	mustPost(t, ts, "/create-project/other", url.Values{"key-str": {testMasterKeyStr}})
+	mustPost(t, ts, "/create-table/first_proj", url.Values{"key-str": {testMasterKeyStr},
+		"stmt": {"table: notes\nfields:\n  title string\n::\n"}})
+
+	token := mustPost(t, ts, "/mint-token", url.Values{"key-str": {testMasterKeyStr}, "name": {"reporter"},
+		"role": {"read"}, "projects": {"first_proj"}, "ttl": {"1h"}})
+
+	cases := []struct {
+		path   string
+		values url.Values
+		status int
+	}{
+		// the token reads its project
+		{"/search-table/first_proj", url.Values{"stmt": {"table: notes"}}, http.StatusOK},
+		// but does not write to it
+		{"/insert-row/first_proj/notes", url.Values{"title": {"a"}}, http.StatusForbidden},
+		// nor read the others
+		{"/list-tables/other", nil, http.StatusForbidden},
+		// nor call the endpoints of the master key
+		{"/mint-token", url.Values{"name": {"x"}, "role": {"admin"}, "projects": {"*"}, "ttl": {"1h"}}, http.StatusForbidden},
+	}
+	for _, c := range cases {
+		status, body := postWithToken(t, ts, c.path, token, c.values)
+		if status != c.status {
+			t.Errorf("%s: status %d, expected %d: %s", c.path, status, c.status, body)
+		}
+	}
+
+	status, _ := postWithToken(t, ts, "/search-table/first_proj", token+"x", url.Values{"stmt": {"table: notes"}})
+	if status != http.StatusUnauthorized {
+		t.Errorf("a token with a bad signature got the status %d", status)
+	}
+
+	expired := mustPost(t, ts, "/mint-token", url.Values{"key-str": {testMasterKeyStr}, "name": {"reporter"},
+		"role": {"read"}, "projects": {"first_proj"}, "ttl": {"1ns"}})
+	status, _ = postWithToken(t, ts, "/search-table/first_proj", expired, url.Values{"stmt": {"table: notes"}})
+	if status != http.StatusUnauthorized {
+		t.Errorf("an expired token got the status %d", status)
+	}
+
+	// the tokens minted before a rotation stay valid
+	mustPost(t, ts, "/rotate-token-secret", url.Values{"key-str": {testMasterKeyStr}})
+	status, body := postWithToken(t, ts, "/search-table/first_proj", token, url.Values{"stmt": {"table: notes"}})
+	if status != http.StatusOK {
+		t.Errorf("a token minted before the rotation got the status %d: %s", status, body)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/saenuma/flaarum/internal"
+	"github.com/saenuma/flaarumlib"
+)
+
+// parseIdsForm returns the ids in the comma separated 'ids' form value, each once.
+func parseIdsForm(r *http.Request) []string {
+	ids := make([]string, 0)
+	for _, id := range strings.Split(r.FormValue("ids"), ",") {
+		id = strings.TrimSpace(id)
+		if id != "" && !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func listTrash(w http.ResponseWriter, r *http.Request) {
+	projName := r.PathValue("proj")
+	tableName := r.PathValue("tbl")
+
+	if !doesTableExists(projName, tableName) {
+		internal.PrintError(w, errors.New(fmt.Sprintf("Table '%s' of Project '%s' does not exists.", tableName, projName)))
+		return
+	}
+
+	tableLocks.RLock(projName, tableName)
+	trashedRows, err := internal.ReadTrash(projName, tableName)
+	tableLocks.RUnlock(projName, tableName)
+	if err != nil {
+		internal.PrintError(w, err)
+		return
+	}
+
+	rows := make([]map[string]string, 0, len(trashedRows))
+	for _, row := range trashedRows {
+		rows = append(rows, row)
+	}
+
+	jsonBytes, err := json.Marshal(rows)
+	if err != nil {
+		internal.PrintError(w, errors.Wrap(err, "json error"))
+		return
+	}
+
+	w.Write(jsonBytes)
+}
+
+// rowOfStructure returns a trashed row with only the fields of the current table structure. The
+// fields derived from dates and datetimes are left out too; validateAndMutateDataMap adds them back.
+func rowOfStructure(tableStruct flaarumlib.TableStruct, row map[string]string, currentVersion int) map[string]string {
+	newRow := map[string]string{"id": row["id"], "_version": strconv.Itoa(currentVersion)}
+	for _, fd := range tableStruct.Fields {
+		if v, ok := row[fd.FieldName]; ok {
+			newRow[fd.FieldName] = v
+		}
+	}
+	return newRow
+}
+
+func restoreRows(w http.ResponseWriter, r *http.Request) {
+	projName := r.PathValue("proj")
+	tableName := r.PathValue("tbl")
+	ids := parseIdsForm(r)
+
+	if !doesTableExists(projName, tableName) {
+		internal.PrintError(w, errors.New(fmt.Sprintf("Table '%s' of Project '%s' does not exists.", tableName, projName)))
+		return
+	}
+
+	if len(ids) == 0 {
+		printValError(w, errors.New("expected the ids of the rows to restore"))
+		return
+	}
+
+	unlock, err := lockForValidatedWrite(projName, tableName)
+	if err != nil {
+		internal.PrintError(w, err)
+		return
+	}
+	defer unlock()
+
+	trashedRows, err := internal.ReadTrash(projName, tableName)
+	if err != nil {
+		internal.PrintError(w, err)
+		return
+	}
+
+	tableStruct, err := getCurrentTableStructureParsed(projName, tableName)
+	if err != nil {
+		internal.PrintError(w, err)
+		return
+	}
+	currentVersion, err := getCurrentVersionNum(projName, tableName)
+	if err != nil {
+		internal.PrintError(w, err)
+		return
+	}
+
+	// do unique and foreign key validation
+	toRestore := make([]map[string]string, 0)
+	for _, id := range ids {
+		row, ok := trashedRows[id]
+		if !ok {
+			printValError(w, errors.New(fmt.Sprintf("The row with id '%s' is not in the trash of table '%s'", id, tableName)))
+			return
+		}
+
+		exists, _, err := innerSearchNoLock(projName, fmt.Sprintf("table: %s\nwhere:\n  id = %s\n", tableName, id))
+		if err != nil {
+			internal.PrintError(w, err)
+			return
+		}
+		if len(*exists) > 0 {
+			printValError(w, errors.New(fmt.Sprintf("The id '%s' is used by another row of table '%s'", id, tableName)))
+			return
+		}
+
+		validatedRow, err := validateAndMutateDataMap(projName, tableName, rowOfStructure(tableStruct, row, currentVersion), nil)
+		if err != nil {
+			printValError(w, err)
+			return
+		}
+		toRestore = append(toRestore, validatedRow)
+	}
+
+	err = checkUniqueWithinRows(tableStruct, toRestore)
+	if err != nil {
+		printValError(w, err)
+		return
+	}
+
+	changes := make([]rowChange, 0, len(toRestore))
+	for _, row := range toRestore {
+		err = saveNewRow(projName, tableName, row["id"], row)
+		if err != nil {
+			internal.PrintError(w, err)
+			return
+		}
+		changes = append(changes, rowChange{internal.HISTORY_INSERT, row["id"], row})
+	}
+
+	err = internal.RemoveFromTrash(projName, tableName, ids)
+	if err != nil {
+		internal.PrintError(w, err)
+		return
+	}
+
+	err = recordChanges(projName, tableName, changes)
+	if err != nil {
+		internal.PrintError(w, err)
+		return
+	}
+
+	setAuditDetails(r, tableName, "", ids)
+	fmt.Fprintf(w, "ok")
+}
+
+func purgeTrash(w http.ResponseWriter, r *http.Request) {
+	projName := r.PathValue("proj")
+	tableName := r.PathValue("tbl")
+	ids := parseIdsForm(r)
+	all := r.FormValue("all") == "t"
+
+	if !doesTableExists(projName, tableName) {
+		internal.PrintError(w, errors.New(fmt.Sprintf("Table '%s' of Project '%s' does not exists.", tableName, projName)))
+		return
+	}
+
+	if all && len(ids) > 0 {
+		printValError(w, errors.New("expected either the ids of the rows to purge or 'all' set to 't', not both"))
+		return
+	}
+	if !all && len(ids) == 0 {
+		printValError(w, errors.New("expected the ids of the rows to purge, or 'all' set to 't' to empty the trash"))
+		return
+	}
+	if all && !requestAllows(r, projName, internal.ROLE_ADMIN) {
+		http.Error(w, "Forbidden: emptying the trash needs the admin role", http.StatusForbidden)
+		return
+	}
+
+	tableLocks.Lock(projName, tableName)
+	defer tableLocks.Unlock(projName, tableName)
+
+	var err error
+	if all {
+		err = internal.EmptyTrash(projName, tableName)
+		if err == nil {
+			err = recordChange(projName, tableName, internal.CHANGE_PURGE_TRASH, "", nil)
+		}
+	} else {
+		err = internal.RemoveFromTrash(projName, tableName, ids)
+		if err == nil {
+			changes := make([]rowChange, 0, len(ids))
+			for _, id := range ids {
+				changes = append(changes, rowChange{internal.CHANGE_PURGE_TRASH, id, nil})
+			}
+			err = recordChanges(projName, tableName, changes)
+		}
+	}
+	if err != nil {
+		internal.PrintError(w, err)
+		return
+	}
+
+	setAuditDetails(r, tableName, "", ids)
+	fmt.Fprintf(w, "ok")
+}
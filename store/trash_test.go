@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/saenuma/flaarum/internal"
+)
+
+func TestRestoreRowWithDatetime(t *testing.T) {
+	ts := newTestStore(t)
+
+	mustPost(t, ts, "/create-table/first_proj", url.Values{"stmt": {`
+table: events
+fields:
+  title string required
+  happened_at datetime
+  note string
+::
+`}})
+	mustPost(t, ts, "/set-table-options/first_proj/events", url.Values{"soft_delete": {"true"}})
+
+	id := mustPost(t, ts, "/insert-row/first_proj/events", url.Values{
+		"title":       {"launch"},
+		"happened_at": {"2024-03-05T10:30 UTC"},
+		"note":        {"dropped later"},
+	})
+	mustPost(t, ts, "/delete-rows/first_proj", url.Values{"stmt": {"table: events\nwhere:\n  id = " + id}})
+
+	// the trashed row keeps 'note' which the next structure drops
+	mustPost(t, ts, "/update-table-structure/first_proj", url.Values{"stmt": {`
+table: events
+fields:
+  title string required
+  happened_at datetime
+::
+`}})
+
+	mustPost(t, ts, "/restore-rows/first_proj/events", url.Values{"ids": {id}})
+
+	body := mustPost(t, ts, "/search-table/first_proj", url.Values{"stmt": {"table: events\nwhere:\n  id = " + id}})
+	rows := make([]map[string]string, 0)
+	err := json.Unmarshal([]byte(body), &rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 {
+		t.Fatalf("expected the restored row, got %v", rows)
+	}
+	if rows[0]["title"] != "launch" || rows[0]["happened_at"] != "2024-03-05T10:30 UTC" {
+		t.Errorf("the restored row is %v", rows[0])
+	}
+	if _, ok := rows[0]["note"]; ok {
+		t.Errorf("the restored row kept the dropped field 'note': %v", rows[0])
+	}
+
+	body = mustPost(t, ts, "/list-trash/first_proj/events", nil)
+	if body != "[]" {
+		t.Errorf("the trash still has %s", body)
+	}
+}
+
+func TestPurgeTrashNeedsIdsOrAll(t *testing.T) {
+	ts := newTestStore(t)
+
+	mustPost(t, ts, "/create-table/first_proj", url.Values{"stmt": {"table: notes\nfields:\n  title string\n::\n"}})
+	mustPost(t, ts, "/set-table-options/first_proj/notes", url.Values{"soft_delete": {"true"}})
+	id := mustPost(t, ts, "/insert-row/first_proj/notes", url.Values{"title": {"a"}})
+	mustPost(t, ts, "/delete-rows/first_proj", url.Values{"stmt": {"table: notes\nwhere:\n  id = " + id}})
+
+	status, _ := post(t, ts, "/purge-trash/first_proj/notes", nil)
+	if status == http.StatusOK {
+		t.Fatal("a purge without ids emptied the trash")
+	}
+	body := mustPost(t, ts, "/list-trash/first_proj/notes", nil)
+	if body == "[]" {
+		t.Fatal("a refused purge emptied the trash")
+	}
+
+	mustPost(t, ts, "/purge-trash/first_proj/notes", url.Values{"all": {"t"}})
+	body = mustPost(t, ts, "/list-trash/first_proj/notes", nil)
+	if body != "[]" {
+		t.Errorf("the trash still has %s", body)
+	}
+}
+
+func TestRestoreRepeatedId(t *testing.T) {
+	ts := newTestStore(t)
+
+	mustPost(t, ts, "/create-table/first_proj", url.Values{"stmt": {"table: notes\nfields:\n  title string\n::\n"}})
+	mustPost(t, ts, "/set-table-options/first_proj/notes", url.Values{"soft_delete": {"true"}})
+	id := mustPost(t, ts, "/insert-row/first_proj/notes", url.Values{"title": {"a"}})
+	mustPost(t, ts, "/delete-rows/first_proj", url.Values{"stmt": {"table: notes\nwhere:\n  id = " + id}})
+
+	mustPost(t, ts, "/restore-rows/first_proj/notes", url.Values{"ids": {id + "," + id}})
+
+	if rows := searchRows(t, ts, "table: notes\nwhere:\n  title = a"); len(rows) != 1 {
+		t.Errorf("the index of 'title' finds %d rows, expected 1", len(rows))
+	}
+	changes, err := internal.ReadChangesSince("first_proj", "notes", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the insert, the delete and the restore
+	if len(changes) != 3 {
+		t.Errorf("the change log has %d changes, expected 3: %v", len(changes), changes)
+	}
+}
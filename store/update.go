@@ -29,7 +29,15 @@ func updateRows(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rows, err := innerSearch(projName, stmt)
+	unlock, err := lockForValidatedWrite(projName, tableName)
+	if err != nil {
+		internal.PrintError(w, err)
+		return
+	}
+	defer unlock()
+
+	rows, scanned, err := innerSearchNoLock(projName, stmt)
+	setRowsScanned(r, scanned)
 	if err != nil {
 		internal.PrintError(w, err)
 		return
@@ -62,6 +70,38 @@ func updateRows(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	patchedRows := patchRows(projName, tableName, tableStruct, rows, updatedValues)
+
+	// validation
+	for i, row := range patchedRows {
+		validatedRow, err := validateAndMutateDataMap(projName, tableName, row, (*rows)[i])
+		if err != nil {
+			printValError(w, err)
+			return
+		}
+		patchedRows[i] = validatedRow
+	}
+	err = checkUniqueWithinRows(tableStruct, patchedRows)
+	if err != nil {
+		printValError(w, err)
+		return
+	}
+
+	err = innerUpdate(projName, tableName, rows, patchedRows)
+	if err != nil {
+		internal.PrintError(w, err)
+		return
+	}
+
+	setAuditDetails(r, tableName, stmt, rowIds(rows))
+	fmt.Fprintf(w, "ok")
+}
+
+// patchRows applies the updated values to copies of rows. The fields which are no longer in the
+// table structure are dropped from the copies together with their indexes.
+func patchRows(projName, tableName string, tableStruct flaarumlib.TableStruct, rows *[]map[string]string,
+	updatedValues map[string]string) []map[string]string {
+
 	fieldsDescs := make(map[string]flaarumlib.FieldStruct)
 	for _, fd := range tableStruct.Fields {
 		fieldsDescs[fd.FieldName] = fd
@@ -90,28 +130,18 @@ func updateRows(w http.ResponseWriter, r *http.Request) {
 		patchedRows = append(patchedRows, newRow)
 	}
 
-	// validation
-	for i, row := range patchedRows {
-		validatedRow, err := validateAndMutateDataMap(projName, tableName, row, (*rows)[i])
-		if err != nil {
-			printValError(w, err)
-			return
-		}
-		patchedRows[i] = validatedRow
-	}
-
-	createTableMutexIfNecessary(projName, tableName)
-	fullTableName := projName + ":" + tableName
-	tablesMutexes[fullTableName].Lock()
-	defer tablesMutexes[fullTableName].Unlock()
+	return patchedRows
+}
 
+// innerUpdate replaces rows with their validated patched copies and updates their indexes. The
+// caller must hold the locks taken by lockForValidatedWrite.
+func innerUpdate(projName, tableName string, rows *[]map[string]string, patchedRows []map[string]string) error {
 	dataPath, _ := internal.GetRootPath()
 	dataF1Path := filepath.Join(dataPath, projName, tableName, "data.flaa1")
 
 	elemsMap, err := internal.ParseDataF1File(dataF1Path)
 	if err != nil {
-		internal.PrintError(w, err)
-		return
+		return err
 	}
 
 	// write null data to flaa2 file
@@ -128,7 +158,7 @@ func updateRows(w http.ResponseWriter, r *http.Request) {
 		if internal.DoesPathExists(dataLumpPath) {
 			dataLumpHandle, err := os.OpenFile(dataLumpPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0777)
 			if err != nil {
-				fmt.Println(err)
+				internal.LogError("update error", err)
 				continue
 			}
 			defer dataLumpHandle.Close()
@@ -137,6 +167,7 @@ func updateRows(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	// create or delete indexes.
+	changes := make([]rowChange, 0, len(patchedRows))
 	for i, row := range patchedRows {
 		for fieldName, newData := range row {
 			if fieldName == "id" {
@@ -151,13 +182,11 @@ func updateRows(w http.ResponseWriter, r *http.Request) {
 				if ok && oldData != newData {
 					err = internal.DeleteIndex(projName, tableName, fieldName, oldData, row["id"], (*rows)[i]["_version"])
 					if err != nil {
-						internal.PrintError(w, err)
-						return
+						return err
 					}
 					err = internal.MakeIndex(projName, tableName, fieldName, newData, row["id"])
 					if err != nil {
-						internal.PrintError(w, err)
-						return
+						return err
 					}
 				}
 
@@ -165,13 +194,19 @@ func updateRows(w http.ResponseWriter, r *http.Request) {
 
 		}
 
+		err = internal.RecordRowHistory(projName, tableName, row["id"], internal.HISTORY_UPDATE, (*rows)[i])
+		if err != nil {
+			return err
+		}
+
 		// write data
 		err = internal.SaveRowData(projName, tableName, row["id"], row)
 		if err != nil {
-			internal.PrintError(w, err)
-			return
+			return err
 		}
+
+		changes = append(changes, rowChange{internal.HISTORY_UPDATE, row["id"], row})
 	}
 
-	fmt.Fprintf(w, "ok")
+	return recordChanges(projName, tableName, changes)
 }
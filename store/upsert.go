@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/saenuma/flaarum/internal"
+)
+
+// upsertRow inserts a row or updates the row which has the same value of the conflict field, which
+// is either 'id' or a unique field. The search and the write are done under the same locks, so no
+// other write can come between them. The action taken and the id of the row are returned as JSON.
+func upsertRow(w http.ResponseWriter, r *http.Request) {
+	projName := r.PathValue("proj")
+	tableName := r.PathValue("tbl")
+
+	conflictField := r.FormValue("conflict-field")
+	if conflictField == "" {
+		printValError(w, errors.New("expected the conflict field in 'conflict-field'"))
+		return
+	}
+
+	toWrite := make(map[string]string)
+	for k := range r.PostForm {
+		if k == "key-str" || k == "conflict-field" {
+			continue
+		}
+		if r.FormValue(k) == "" {
+			continue
+		}
+		toWrite[k] = r.FormValue(k)
+	}
+
+	if !doesTableExists(projName, tableName) {
+		internal.PrintError(w, errors.New(fmt.Sprintf("Table '%s' of Project '%s' does not exists.", tableName, projName)))
+		return
+	}
+
+	unlock, err := lockForValidatedWrite(projName, tableName)
+	if err != nil {
+		internal.PrintError(w, err)
+		return
+	}
+	defer unlock()
+
+	tableStruct, err := getCurrentTableStructureParsed(projName, tableName)
+	if err != nil {
+		internal.PrintError(w, err)
+		return
+	}
+
+	if conflictField != "id" {
+		isUnique := false
+		for _, fd := range tableStruct.Fields {
+			if fd.FieldName == conflictField && fd.Unique {
+				isUnique = true
+			}
+		}
+		if !isUnique {
+			printValError(w, errors.New(fmt.Sprintf("The conflict field '%s' is neither 'id' nor a unique field of table '%s'",
+				conflictField, tableName)))
+			return
+		}
+	}
+
+	conflictValue, ok := toWrite[conflictField]
+	if !ok {
+		printValError(w, errors.New(fmt.Sprintf("The row has no value for the conflict field '%s'", conflictField)))
+		return
+	}
+
+	rows, scanned, err := innerSearchNoLock(projName, fmt.Sprintf("table: %s\nwhere:\n  %s = %s\n",
+		tableName, conflictField, conflictValue))
+	setRowsScanned(r, scanned)
+	if err != nil {
+		internal.PrintError(w, err)
+		return
+	}
+	if len(*rows) > 1 {
+		internal.PrintError(w, errors.New(fmt.Sprintf("%d rows of table '%s' have '%s' = '%s'", len(*rows),
+			tableName, conflictField, conflictValue)))
+		return
+	}
+
+	currentVersion, err := getCurrentVersionNum(projName, tableName)
+	if err != nil {
+		internal.PrintError(w, err)
+		return
+	}
+	toWrite["_version"] = strconv.Itoa(currentVersion)
+
+	var action, rowId string
+	if len(*rows) == 0 {
+		action = "insert"
+
+		toWrite, err = validateAndMutateDataMap(projName, tableName, toWrite, nil)
+		if err != nil {
+			printValError(w, err)
+			return
+		}
+		if toWriteId, ok := toWrite["id"]; ok {
+			err = checkClientId(projName, tableName, toWriteId)
+			if err != nil {
+				printValError(w, err)
+				return
+			}
+		}
+
+		rowId, err = innerInsert(projName, tableName, toWrite)
+		if err != nil {
+			internal.PrintError(w, err)
+			return
+		}
+	} else {
+		action = "update"
+		rowId = (*rows)[0]["id"]
+
+		if toWriteId, ok := toWrite["id"]; ok && toWriteId != rowId {
+			printValError(w, errors.New(fmt.Sprintf("The id of the row with '%s' = '%s' is '%s' and cannot be changed",
+				conflictField, conflictValue, rowId)))
+			return
+		}
+		delete(toWrite, "id")
+
+		patchedRows := patchRows(projName, tableName, tableStruct, rows, toWrite)
+		validatedRow, err := validateAndMutateDataMap(projName, tableName, patchedRows[0], (*rows)[0])
+		if err != nil {
+			printValError(w, err)
+			return
+		}
+		patchedRows[0] = validatedRow
+
+		err = innerUpdate(projName, tableName, rows, patchedRows)
+		if err != nil {
+			internal.PrintError(w, err)
+			return
+		}
+	}
+
+	jsonBytes, err := json.Marshal(map[string]string{"action": action, "id": rowId})
+	if err != nil {
+		internal.PrintError(w, errors.Wrap(err, "json error"))
+		return
+	}
+
+	setAuditDetails(r, tableName, "", []string{rowId})
+	w.Write(jsonBytes)
+}
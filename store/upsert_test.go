@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+)
+
+// upsert sends an upsert and returns the action taken and the id of the row.
+func upsert(t *testing.T, ts *httptest.Server, values url.Values) (string, string) {
+	t.Helper()
+
+	body := mustPost(t, ts, "/upsert-row/first_proj/users", values)
+	var out map[string]string
+	err := json.Unmarshal([]byte(body), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out["action"], out["id"]
+}
+
+func TestUpsertInsertsThenUpdates(t *testing.T) {
+	ts := newTestStore(t)
+	mustPost(t, ts, "/create-table/first_proj", url.Values{"stmt": {"table: users\nfields:\n  email string required unique\n  name string\n::\n"}})
+
+	action, id := upsert(t, ts, url.Values{"conflict-field": {"email"}, "email": {"a@example.com"}, "name": {"first"}})
+	if action != "insert" {
+		t.Errorf("the first upsert did an %s", action)
+	}
+	action, updatedId := upsert(t, ts, url.Values{"conflict-field": {"email"}, "email": {"a@example.com"}, "name": {"second"}})
+	if action != "update" || updatedId != id {
+		t.Errorf("the second upsert did an %s of the row '%s', expected an update of '%s'", action, updatedId, id)
+	}
+
+	rows := searchRows(t, ts, "table: users")
+	if len(rows) != 1 || rows[0]["name"] != "second" {
+		t.Errorf("the table has the rows %v", rows)
+	}
+}
+
+func TestUpsertById(t *testing.T) {
+	ts := newTestStore(t)
+	mustPost(t, ts, "/create-table/first_proj", url.Values{"stmt": {"table: users\nfields:\n  name string\n::\n"}})
+
+	action, id := upsert(t, ts, url.Values{"conflict-field": {"id"}, "id": {"10"}, "name": {"first"}})
+	if action != "insert" || id != "10" {
+		t.Errorf("the first upsert did an %s of the row '%s'", action, id)
+	}
+	action, id = upsert(t, ts, url.Values{"conflict-field": {"id"}, "id": {"10"}, "name": {"second"}})
+	if action != "update" || id != "10" {
+		t.Errorf("the second upsert did an %s of the row '%s'", action, id)
+	}
+
+	rows := searchRows(t, ts, "table: users")
+	if len(rows) != 1 || rows[0]["name"] != "second" {
+		t.Errorf("the table has the rows %v", rows)
+	}
+}
+
+func TestUpsertRefusesConflictFields(t *testing.T) {
+	ts := newTestStore(t)
+	mustPost(t, ts, "/create-table/first_proj", url.Values{"stmt": {"table: users\nfields:\n  email string unique\n  name string\n::\n"}})
+
+	for _, values := range []url.Values{
+		// not unique
+		{"conflict-field": {"name"}, "name": {"a"}},
+		// not a field
+		{"conflict-field": {"nickname"}, "nickname": {"a"}},
+		// no value for the conflict field
+		{"conflict-field": {"email"}, "name": {"a"}},
+		{"name": {"a"}},
+	} {
+		status, body := post(t, ts, "/upsert-row/first_proj/users", values)
+		if status != http.StatusBadRequest {
+			t.Errorf("the upsert %v got the status %d: %s", values, status, body)
+		}
+	}
+
+	if rows := searchRows(t, ts, "table: users"); len(rows) != 0 {
+		t.Errorf("refused upserts wrote the rows %v", rows)
+	}
+}
+
+func TestConcurrentUpsertsOfUniqueValue(t *testing.T) {
+	ts := newTestStore(t)
+	mustPost(t, ts, "/create-table/first_proj", url.Values{"stmt": {"table: users\nfields:\n  email string required unique\n  name string\n::\n"}})
+
+	const n = 20
+	actions := make([]map[string]string, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			status, body := post(t, ts, "/upsert-row/first_proj/users", url.Values{
+				"conflict-field": {"email"},
+				"email":          {"a@example.com"},
+				"name":           {fmt.Sprintf("name%d", i)},
+			})
+			if status != http.StatusOK {
+				t.Errorf("upsert %d: status %d: %s", i, status, body)
+				return
+			}
+			json.Unmarshal([]byte(body), &actions[i])
+		}(i)
+	}
+	wg.Wait()
+
+	inserts := 0
+	for _, action := range actions {
+		if action["action"] == "insert" {
+			inserts += 1
+		}
+	}
+	if inserts != 1 {
+		t.Errorf("%d of the upserts inserted, expected 1", inserts)
+	}
+
+	rows := searchRows(t, ts, "table: users\nwhere:\n  email = a@example.com")
+	if len(rows) != 1 {
+		t.Errorf("%d rows have the unique value, expected 1", len(rows))
+	}
+}
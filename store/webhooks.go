@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/saenuma/flaarum/internal"
+)
+
+// webhookPayload is the body posted to webhooks.
+type webhookPayload struct {
+	Project string `json:"project"`
+	changeEvent
+}
+
+type webhookDelivery struct {
+	hook    internal.Webhook
+	body    []byte
+	attempt int
+}
+
+const (
+	webhookQueueSize   = 10000
+	webhookWorkers     = 4
+	webhookMaxAttempts = 6 // the delays between attempts are 1s, 2s, 4s, 8s and 16s
+)
+
+var (
+	webhooksMutex  sync.RWMutex
+	webhooksCache  []internal.Webhook
+	webhooksQueue  = make(chan webhookDelivery, webhookQueueSize)
+	webhooksClient = &http.Client{Timeout: 10 * time.Second}
+
+	// the deliveries which are queued, being posted or waiting for a retry
+	webhooksInFlight atomic.Int64
+)
+
+// startWebhookWorkers loads the webhooks and starts the goroutines which deliver them.
+func startWebhookWorkers() {
+	hooks, err := internal.LoadWebhooks()
+	if err != nil {
+		internal.LogError("webhooks error", err)
+	}
+	webhooksMutex.Lock()
+	webhooksCache = hooks
+	webhooksMutex.Unlock()
+
+	for i := 0; i < webhookWorkers; i++ {
+		go func() {
+			for delivery := range webhooksQueue {
+				deliverWebhook(delivery)
+			}
+		}()
+	}
+}
+
+func postWebhook(hook internal.Webhook, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "http error")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(internal.WEBHOOK_SIGNATURE_HEADER, internal.SignWebhookBody(hook.Secret, body))
+	req.Header.Set("X-Flaarum-Webhook", hook.Id)
+
+	resp, err := webhooksClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "http error")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New(fmt.Sprintf("webhook '%s' responded with status %d", hook.Id, resp.StatusCode))
+	}
+	return nil
+}
+
+func enqueueWebhookDelivery(delivery webhookDelivery) {
+	webhooksInFlight.Add(1)
+	requeueWebhookDelivery(delivery)
+}
+
+// requeueWebhookDelivery queues a delivery which is already counted in webhooksInFlight.
+func requeueWebhookDelivery(delivery webhookDelivery) {
+	select {
+	case webhooksQueue <- delivery:
+	default:
+		webhooksInFlight.Add(-1)
+		internal.Logger.Warn("webhook queue is full. Dropped a delivery", "webhook", delivery.hook.Id)
+	}
+}
+
+// deliverWebhook posts a delivery and schedules a retry with exponential backoff if it fails.
+func deliverWebhook(delivery webhookDelivery) {
+	err := postWebhook(delivery.hook, delivery.body)
+	if err == nil {
+		webhooksInFlight.Add(-1)
+		return
+	}
+
+	delivery.attempt += 1
+	if delivery.attempt >= webhookMaxAttempts {
+		webhooksInFlight.Add(-1)
+		internal.Logger.Warn("giving up on a delivery", "webhook", delivery.hook.Id, "error", err.Error())
+		return
+	}
+
+	backoff := time.Duration(1<<(delivery.attempt-1)) * time.Second
+	time.AfterFunc(backoff, func() {
+		requeueWebhookDelivery(delivery)
+	})
+}
+
+// waitForWebhooks waits up to timeout for the deliveries in flight, retries included, and returns
+// the number of those left.
+func waitForWebhooks(timeout time.Duration) int64 {
+	deadline := time.Now().Add(timeout)
+	for webhooksInFlight.Load() > 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	return webhooksInFlight.Load()
+}
+
+// queueWebhooks queues the deliveries of a change to the webhooks of its table.
+func queueWebhooks(projName string, entry internal.ChangeEntry) {
+	webhooksMutex.RLock()
+	defer webhooksMutex.RUnlock()
+
+	var body []byte
+	for _, hook := range webhooksCache {
+		if !hook.Matches(projName, entry.Table) {
+			continue
+		}
+
+		if body == nil {
+			var err error
+			body, err = json.Marshal(webhookPayload{projName, newChangeEvent(entry, true)})
+			if err != nil {
+				internal.LogError("webhooks error", err)
+				return
+			}
+		}
+		enqueueWebhookDelivery(webhookDelivery{hook: hook, body: body})
+	}
+}
+
+func addWebhook(w http.ResponseWriter, r *http.Request) {
+	projName := r.PathValue("proj")
+	tableName := r.FormValue("table")
+	hookURL := r.FormValue("url")
+
+	if tableName == "" {
+		tableName = "*"
+	}
+	if tableName != "*" && !doesTableExists(projName, tableName) {
+		printValError(w, errors.New(fmt.Sprintf("Table '%s' of Project '%s' does not exists.", tableName, projName)))
+		return
+	}
+
+	parsedURL, err := url.Parse(hookURL)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || parsedURL.Host == "" {
+		printValError(w, errors.New(fmt.Sprintf("'%s' is not a valid http or https url", hookURL)))
+		return
+	}
+
+	hook := internal.Webhook{
+		Id:      internal.GenerateSecureRandomString(12),
+		Project: projName,
+		Table:   tableName,
+		URL:     hookURL,
+		Secret:  internal.GenerateSecureRandomString(40),
+		Created: time.Now().Format(time.RFC3339),
+	}
+
+	webhooksMutex.Lock()
+	defer webhooksMutex.Unlock()
+
+	hooks := append(slices.Clone(webhooksCache), hook)
+	err = internal.SaveWebhooks(hooks)
+	if err != nil {
+		internal.PrintError(w, err)
+		return
+	}
+	webhooksCache = hooks
+
+	jsonBytes, err := json.Marshal(hook)
+	if err != nil {
+		internal.PrintError(w, errors.Wrap(err, "json error"))
+		return
+	}
+
+	setAuditDetails(r, tableName, hookURL, []string{hook.Id})
+	w.Write(jsonBytes)
+}
+
+func listWebhooks(w http.ResponseWriter, r *http.Request) {
+	projName := r.PathValue("proj")
+
+	webhooksMutex.RLock()
+	hooks := make([]internal.Webhook, 0)
+	for _, hook := range webhooksCache {
+		if hook.Project == projName {
+			hook.Secret = ""
+			hooks = append(hooks, hook)
+		}
+	}
+	webhooksMutex.RUnlock()
+
+	jsonBytes, err := json.Marshal(hooks)
+	if err != nil {
+		internal.PrintError(w, errors.Wrap(err, "json error"))
+		return
+	}
+
+	w.Write(jsonBytes)
+}
+
+func findWebhook(projName, hookId string) (internal.Webhook, bool) {
+	webhooksMutex.RLock()
+	defer webhooksMutex.RUnlock()
+
+	for _, hook := range webhooksCache {
+		if hook.Project == projName && hook.Id == hookId {
+			return hook, true
+		}
+	}
+	return internal.Webhook{}, false
+}
+
+// testWebhook posts a test event to a webhook once and reports the result.
+func testWebhook(w http.ResponseWriter, r *http.Request) {
+	projName := r.PathValue("proj")
+	hookId := r.PathValue("id")
+
+	hook, ok := findWebhook(projName, hookId)
+	if !ok {
+		printValError(w, errors.New(fmt.Sprintf("the webhook '%s' does not exists in project '%s'", hookId, projName)))
+		return
+	}
+
+	tableName := hook.Table
+	if tableName == "*" {
+		tableName = ""
+	}
+	entry := internal.ChangeEntry{Time: time.Now(), Table: tableName, Op: "test"}
+	body, err := json.Marshal(webhookPayload{projName, newChangeEvent(entry, true)})
+	if err != nil {
+		internal.PrintError(w, errors.Wrap(err, "json error"))
+		return
+	}
+
+	err = postWebhook(hook, body)
+	if err != nil {
+		printValError(w, err)
+		return
+	}
+
+	skipAudit(r)
+	fmt.Fprintf(w, "ok")
+}
+
+// renameTableWebhooks moves the webhooks of a table to its new name.
+func renameTableWebhooks(projName, tableName, newTableName string) error {
+	webhooksMutex.Lock()
+	defer webhooksMutex.Unlock()
+
+	hooks := slices.Clone(webhooksCache)
+	renamed := false
+	for i, hook := range hooks {
+		if hook.Project == projName && hook.Table == tableName {
+			hooks[i].Table = newTableName
+			renamed = true
+		}
+	}
+	if !renamed {
+		return nil
+	}
+
+	err := internal.SaveWebhooks(hooks)
+	if err != nil {
+		return err
+	}
+	webhooksCache = hooks
+	return nil
+}
+
+func removeWebhook(w http.ResponseWriter, r *http.Request) {
+	projName := r.PathValue("proj")
+	hookId := r.PathValue("id")
+
+	webhooksMutex.Lock()
+	defer webhooksMutex.Unlock()
+
+	hooks := slices.DeleteFunc(slices.Clone(webhooksCache), func(hook internal.Webhook) bool {
+		return hook.Project == projName && hook.Id == hookId
+	})
+	if len(hooks) == len(webhooksCache) {
+		printValError(w, errors.New(fmt.Sprintf("the webhook '%s' does not exists in project '%s'", hookId, projName)))
+		return
+	}
+
+	err := internal.SaveWebhooks(hooks)
+	if err != nil {
+		internal.PrintError(w, err)
+		return
+	}
+	webhooksCache = hooks
+
+	setAuditDetails(r, "", "", []string{hookId})
+	fmt.Fprintf(w, "ok")
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/saenuma/flaarum/internal"
+)
+
+type receivedWebhook struct {
+	signature string
+	hookId    string
+	body      []byte
+}
+
+// newWebhookReceiver starts a server which passes the webhooks posted to it to the returned channel.
+func newWebhookReceiver(t *testing.T) (*httptest.Server, chan receivedWebhook) {
+	received := make(chan receivedWebhook, 100)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- receivedWebhook{r.Header.Get(internal.WEBHOOK_SIGNATURE_HEADER), r.Header.Get("X-Flaarum-Webhook"), body}
+	}))
+	t.Cleanup(receiver.Close)
+	return receiver, received
+}
+
+func addTestWebhook(t *testing.T, ts *httptest.Server, tableName, hookURL string) internal.Webhook {
+	body := mustPost(t, ts, "/add-webhook/first_proj", url.Values{"table": {tableName}, "url": {hookURL}})
+	var hook internal.Webhook
+	err := json.Unmarshal([]byte(body), &hook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hook
+}
+
+func checkSignature(t *testing.T, hook internal.Webhook, got receivedWebhook) {
+	t.Helper()
+
+	mac := hmac.New(sha256.New, []byte(hook.Secret))
+	mac.Write(got.body)
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if got.signature != expected {
+		t.Errorf("the signature is '%s', expected '%s'", got.signature, expected)
+	}
+	if got.hookId != hook.Id {
+		t.Errorf("the webhook id is '%s', expected '%s'", got.hookId, hook.Id)
+	}
+}
+
+func waitForWebhook(t *testing.T, received chan receivedWebhook) receivedWebhook {
+	t.Helper()
+
+	select {
+	case got := <-received:
+		return got
+	case <-time.After(5 * time.Second):
+		t.Fatal("no webhook was delivered")
+	}
+	return receivedWebhook{}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	ts := newTestStore(t)
+	receiver, received := newWebhookReceiver(t)
+
+	mustPost(t, ts, "/create-table/first_proj", url.Values{"stmt": {"table: notes\nfields:\n  title string required\n::\n"}})
+	hook := addTestWebhook(t, ts, "notes", receiver.URL)
+
+	id := mustPost(t, ts, "/insert-row/first_proj/notes", url.Values{"title": {"first"}})
+
+	got := waitForWebhook(t, received)
+	checkSignature(t, hook, got)
+
+	var payload map[string]any
+	err := json.Unmarshal(got.body, &payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload["project"] != "first_proj" || payload["table"] != "notes" || payload["op"] != internal.HISTORY_INSERT || payload["id"] != id {
+		t.Errorf("unexpected payload %s", got.body)
+	}
+	row, _ := payload["row"].(map[string]any)
+	if row["title"] != "first" {
+		t.Errorf("unexpected row in payload %s", got.body)
+	}
+}
+
+func TestWebhookNotSentForFailedWrite(t *testing.T) {
+	ts := newTestStore(t)
+	receiver, received := newWebhookReceiver(t)
+
+	mustPost(t, ts, "/create-table/first_proj", url.Values{"stmt": {"table: notes\nfields:\n  title string required unique\n::\n"}})
+	addTestWebhook(t, ts, "notes", receiver.URL)
+
+	mustPost(t, ts, "/insert-row/first_proj/notes", url.Values{"title": {"a"}})
+	mustPost(t, ts, "/insert-row/first_proj/notes", url.Values{"title": {"b"}})
+	waitForWebhook(t, received)
+	waitForWebhook(t, received)
+
+	// both rows getting the same title breaks the unique field, so nothing is written
+	status, _ := post(t, ts, "/update-rows/first_proj", url.Values{
+		"stmt":   {"table: notes\nwhere:\n  title in a b"},
+		"set1_k": {"title"},
+		"set1_v": {"c"},
+	})
+	if status == http.StatusOK {
+		t.Fatal("the update breaking the unique field succeeded")
+	}
+
+	select {
+	case got := <-received:
+		t.Errorf("a webhook was delivered for a failed write: %s", got.body)
+	case <-time.After(500 * time.Millisecond):
+	}
+}
+
+func TestTestWebhookSignature(t *testing.T) {
+	ts := newTestStore(t)
+	receiver, received := newWebhookReceiver(t)
+
+	hook := addTestWebhook(t, ts, "*", receiver.URL)
+	mustPost(t, ts, "/test-webhook/first_proj/"+hook.Id, nil)
+
+	got := waitForWebhook(t, received)
+	checkSignature(t, hook, got)
+}
+
+func TestWaitForWebhooksWaitsForRetries(t *testing.T) {
+	ts := newTestStore(t)
+
+	attempts := make(chan struct{}, 10)
+	failed := false
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts <- struct{}{}
+		if !failed {
+			failed = true
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(receiver.Close)
+
+	mustPost(t, ts, "/create-table/first_proj", url.Values{"stmt": {"table: notes\nfields:\n  title string\n::\n"}})
+	addTestWebhook(t, ts, "notes", receiver.URL)
+	mustPost(t, ts, "/insert-row/first_proj/notes", url.Values{"title": {"a"}})
+
+	if left := waitForWebhooks(10 * time.Second); left != 0 {
+		t.Fatalf("%d deliveries are left", left)
+	}
+	if len(attempts) != 2 {
+		t.Errorf("the webhook was posted %d times, expected 2", len(attempts))
+	}
+}
+
+func TestWebhookFollowsTableRename(t *testing.T) {
+	ts := newTestStore(t)
+	receiver, received := newWebhookReceiver(t)
+
+	mustPost(t, ts, "/create-table/first_proj", url.Values{"stmt": {"table: notes\nfields:\n  title string required\n::\n"}})
+	hook := addTestWebhook(t, ts, "notes", receiver.URL)
+	mustPost(t, ts, "/rename-table/first_proj/notes/memos", nil)
+
+	id := mustPost(t, ts, "/insert-row/first_proj/memos", url.Values{"title": {"first"}})
+	got := waitForWebhook(t, received)
+	checkSignature(t, hook, got)
+	var payload webhookPayload
+	err := json.Unmarshal(got.body, &payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload.Table != "memos" || payload.Id != id {
+		t.Errorf("the webhook got %s", got.body)
+	}
+
+	hooks, err := internal.LoadWebhooks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hooks) != 1 || hooks[0].Table != "memos" {
+		t.Errorf("the saved webhooks are %v", hooks)
+	}
+}